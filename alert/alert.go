@@ -0,0 +1,532 @@
+// Package alert watches station statuses for stations that are empty or full,
+// and notifies sinks (a webhook, a local command, the log) when a rule starts
+// or stops firing.
+//
+// Rules come in two kinds. A station rule fires separately for each station
+// that has been empty (or full) for longer than For:
+//
+//	rules:
+//	  - name: powell-empty-morning
+//	    condition: empty
+//	    stations: ["3"]
+//	    for: 30m
+//	    hours: "07:00-10:00"
+//	    days: [weekdays]
+//
+// A count rule fires once when more than MoreThan matching stations are in the
+// condition at the same time:
+//
+//	rules:
+//	  - name: sf-full
+//	    condition: full
+//	    city: sf
+//	    more_than: 20
+//
+// An alert fires once, and stays firing until the condition clears, at which
+// point sinks get a "resolved" event. A station that drops out of the feed
+// keeps its alert until it comes back.
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/rest"
+)
+
+// queueSize is the number of events that can wait for delivery after Start
+// before Observe starts dropping them.
+const queueSize = 100
+
+// Conditions a rule can check for.
+const (
+	// Empty means a station has no bikes available.
+	Empty = "empty"
+	// Full means a station has no docks available.
+	Full = "full"
+)
+
+// Event statuses.
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+const defaultTimezone = "America/Los_Angeles"
+
+// Config holds the "alerts" section of a config file.
+type Config struct {
+	Rules []*Rule       `yaml:"rules"`
+	Sinks []*SinkConfig `yaml:"sinks"`
+}
+
+// Rule describes a condition to alert on.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Condition is Empty or Full.
+	Condition string `yaml:"condition"`
+	// Only check these station IDs. If empty, check every station.
+	Stations []string `yaml:"stations"`
+	// Only check stations in this city, by slug ("sf", "oakland", "sj",
+	// "berkeley", "emeryville").
+	City string `yaml:"city"`
+	// Only check stations in this system. If empty, check every system.
+	System string `yaml:"system"`
+	// How long the condition has to hold before the rule fires.
+	For time.Duration `yaml:"for"`
+	// If nonzero, fire once when more than this many stations meet the
+	// condition, instead of once per station.
+	MoreThan int `yaml:"more_than"`
+	// Only fire during these hours, e.g. "07:00-10:00". The range may wrap
+	// past midnight.
+	Hours string `yaml:"hours"`
+	// Only fire on these days: "weekdays", "weekends", or day names like
+	// "mon" or "saturday".
+	Days []string `yaml:"days"`
+	// Timezone for Hours and Days. Defaults to America/Los_Angeles.
+	Timezone string `yaml:"timezone"`
+	// Names of the sinks to notify. If empty, notify every sink.
+	Sinks []string `yaml:"sinks"`
+
+	loc       *time.Location
+	start     int // minutes after midnight
+	end       int
+	days      [7]bool
+	stationOK map[string]bool
+}
+
+var dayNames = map[string][]time.Weekday{
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+func init() {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		dayNames[name] = []time.Weekday{d}
+		dayNames[name[:3]] = []time.Weekday{d}
+	}
+}
+
+func parseClock(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid hour in %q", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid minute in %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// compile checks the rule and fills in unexported fields.
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return errors.New("rule has no name")
+	}
+	if r.Condition != Empty && r.Condition != Full {
+		return fmt.Errorf("rule %q: unknown condition %q (want %q or %q)", r.Name, r.Condition, Empty, Full)
+	}
+	if r.For < 0 || r.MoreThan < 0 {
+		return fmt.Errorf("rule %q: for and more_than must not be negative", r.Name)
+	}
+	tz := r.Timezone
+	if tz == "" {
+		tz = defaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return fmt.Errorf("rule %q: %v", r.Name, err)
+	}
+	r.loc = loc
+	r.start, r.end = 0, 24*60
+	if r.Hours != "" {
+		parts := strings.Split(r.Hours, "-")
+		if len(parts) != 2 {
+			return fmt.Errorf("rule %q: invalid hours %q, want HH:MM-HH:MM", r.Name, r.Hours)
+		}
+		if r.start, err = parseClock(parts[0]); err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+		if r.end, err = parseClock(parts[1]); err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	}
+	r.days = [7]bool{}
+	if len(r.Days) == 0 {
+		r.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, name := range r.Days {
+		days, ok := dayNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("rule %q: unknown day %q", r.Name, name)
+		}
+		for _, d := range days {
+			r.days[d] = true
+		}
+	}
+	r.stationOK = nil
+	if len(r.Stations) > 0 {
+		r.stationOK = make(map[string]bool, len(r.Stations))
+		for _, id := range r.Stations {
+			r.stationOK[id] = true
+		}
+	}
+	return nil
+}
+
+// active reports whether t falls inside the rule's hours and days.
+func (r *Rule) active(t time.Time) bool {
+	t = t.In(r.loc)
+	if !r.days[t.Weekday()] {
+		return false
+	}
+	min := t.Hour()*60 + t.Minute()
+	if r.start <= r.end {
+		return min >= r.start && min < r.end
+	}
+	// wraps past midnight
+	return min >= r.start || min < r.end
+}
+
+func (r *Rule) matches(ss *gobike.StationStatus) bool {
+	if r.Condition == Empty {
+		return ss.Empty()
+	}
+	return ss.Full()
+}
+
+// Event is sent to sinks when an alert starts or stops firing.
+type Event struct {
+	Rule      string `json:"rule"`
+	Status    string `json:"status"`
+	Condition string `json:"condition"`
+	System    string `json:"system,omitempty"`
+	// StationID and StationName are set for station rules.
+	StationID   string `json:"station_id,omitempty"`
+	StationName string `json:"station_name,omitempty"`
+	City        string `json:"city,omitempty"`
+	// Count and MoreThan are set for count rules. Count is the number of
+	// stations meeting the condition.
+	Count    int `json:"count,omitempty"`
+	MoreThan int `json:"more_than,omitempty"`
+	// Since is when the condition started to hold.
+	Since time.Time `json:"since"`
+	Time  time.Time `json:"time"`
+}
+
+// String returns a short human readable description of the event.
+func (e *Event) String() string {
+	var subject string
+	switch {
+	case e.StationID != "" && e.StationName != "":
+		subject = fmt.Sprintf("station %s (%s)", e.StationID, e.StationName)
+	case e.StationID != "":
+		subject = "station " + e.StationID
+	case e.City != "":
+		subject = fmt.Sprintf("%d stations in %s", e.Count, e.City)
+	default:
+		subject = fmt.Sprintf("%d stations", e.Count)
+	}
+	dur := e.Time.Sub(e.Since).Round(time.Minute)
+	if e.Status == Resolved && e.MoreThan > 0 {
+		return fmt.Sprintf("[%s] resolved: %s %s, no more than %d, after %v", e.Rule, subject, e.Condition, e.MoreThan, dur)
+	}
+	if e.Status == Resolved {
+		return fmt.Sprintf("[%s] resolved: %s no longer %s, after %v", e.Rule, subject, e.Condition, dur)
+	}
+	if e.MoreThan > 0 {
+		return fmt.Sprintf("[%s] %s %s, more than %d, for %v", e.Rule, subject, e.Condition, e.MoreThan, dur)
+	}
+	return fmt.Sprintf("[%s] %s %s for %v", e.Rule, subject, e.Condition, dur)
+}
+
+type alertState struct {
+	since  time.Time
+	firing bool
+}
+
+// Engine evaluates rules against station statuses and tracks which alerts
+// are firing. An Engine is not safe for concurrent use.
+type Engine struct {
+	// System is included in events, and matched against Rule.System.
+	System string
+
+	rules    []*Rule
+	sinks    map[string]Sink
+	names    []string // sorted sink names
+	stations map[string]*gobike.Station
+	// keyed by rule name, plus station ID for station rules
+	state map[string]*alertState
+
+	// queue is nil unless Start has been called.
+	queue chan *Event
+	done  chan struct{}
+}
+
+// NewEngine checks cfg and builds its sinks. If cfg has no sinks, events are
+// logged.
+func NewEngine(system string, cfg *Config) (*Engine, error) {
+	e := &Engine{
+		System: system,
+		sinks:  make(map[string]Sink),
+		state:  make(map[string]*alertState),
+	}
+	for _, sc := range cfg.Sinks {
+		if _, ok := e.sinks[sc.Name]; ok {
+			return nil, fmt.Errorf("sink %q is listed more than once", sc.Name)
+		}
+		sink, err := sc.build()
+		if err != nil {
+			return nil, err
+		}
+		e.sinks[sc.Name] = sink
+		e.names = append(e.names, sc.Name)
+	}
+	if len(e.sinks) == 0 {
+		e.sinks["log"] = &LogSink{}
+		e.names = append(e.names, "log")
+	}
+	sort.Strings(e.names)
+	seen := make(map[string]bool)
+	for _, r := range cfg.Rules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rule %q is listed more than once", r.Name)
+		}
+		seen[r.Name] = true
+		for _, name := range r.Sinks {
+			if _, ok := e.sinks[name]; !ok {
+				return nil, fmt.Errorf("rule %q: unknown sink %q", r.Name, name)
+			}
+		}
+		if r.System == "" || r.System == system {
+			e.rules = append(e.rules, r)
+		}
+	}
+	return e, nil
+}
+
+// Rules returns the number of rules that apply to the Engine's system.
+func (e *Engine) Rules() int {
+	return len(e.rules)
+}
+
+// NeedsStations reports whether the caller should load station information
+// with SetStations. It's used to match cities and to name stations in events.
+func (e *Engine) NeedsStations() bool {
+	return len(e.rules) > 0
+}
+
+// SetStations sets the station information used to find a station's city
+// and name.
+func (e *Engine) SetStations(stations []*gobike.Station) {
+	e.stations = make(map[string]*gobike.Station, len(stations))
+	for _, s := range stations {
+		e.stations[strconv.Itoa(s.ID)] = s
+	}
+}
+
+func (e *Engine) inCity(r *Rule, id string) bool {
+	if r.City == "" {
+		return true
+	}
+	s, ok := e.stations[id]
+	return ok && s.City != nil && s.City.Slug == r.City
+}
+
+// Evaluate updates the state of every rule with the statuses observed at
+// now, and returns the events that should be sent.
+func (e *Engine) Evaluate(now time.Time, statuses []*gobike.StationStatus) []*Event {
+	var events []*Event
+	seen := make(map[string]bool)
+	for _, r := range e.rules {
+		active := r.active(now)
+		if r.MoreThan > 0 {
+			count := 0
+			for _, ss := range statuses {
+				if (r.stationOK == nil || r.stationOK[ss.ID]) && e.inCity(r, ss.ID) && r.matches(ss) {
+					count++
+				}
+			}
+			seen[r.Name] = true
+			if ev := e.update(r, r.Name, count > r.MoreThan, active, now); ev != nil {
+				ev.Count = count
+				ev.MoreThan = r.MoreThan
+				ev.City = r.City
+				events = append(events, ev)
+			}
+			continue
+		}
+		for _, ss := range statuses {
+			if r.stationOK != nil && !r.stationOK[ss.ID] || !e.inCity(r, ss.ID) {
+				continue
+			}
+			key := r.Name + "/" + ss.ID
+			seen[key] = true
+			if ev := e.update(r, key, r.matches(ss), active, now); ev != nil {
+				ev.StationID = ss.ID
+				if s, ok := e.stations[ss.ID]; ok {
+					ev.StationName = s.Name
+					if s.City != nil {
+						ev.City = s.City.Slug
+					}
+				}
+				events = append(events, ev)
+			}
+		}
+	}
+	// Stations that disappeared from the feed can't be checked. Don't claim
+	// they've recovered, and keep the alerts that are firing so they don't
+	// fire again when the station comes back, but start over on the ones that
+	// haven't fired yet.
+	for key, st := range e.state {
+		if !seen[key] && !st.firing {
+			delete(e.state, key)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Rule != events[j].Rule {
+			return events[i].Rule < events[j].Rule
+		}
+		return events[i].StationID < events[j].StationID
+	})
+	return events
+}
+
+// update moves the alert with the given key forward, and returns an event if
+// it started or stopped firing.
+func (e *Engine) update(r *Rule, key string, cond, active bool, now time.Time) *Event {
+	st := e.state[key]
+	if !cond {
+		if st == nil {
+			return nil
+		}
+		delete(e.state, key)
+		if !st.firing {
+			return nil
+		}
+		return e.event(r, Resolved, st.since, now)
+	}
+	if st == nil {
+		st = new(alertState)
+		e.state[key] = st
+	}
+	if st.firing {
+		return nil
+	}
+	if !active {
+		// Only count time inside the rule's window.
+		st.since = time.Time{}
+		return nil
+	}
+	if st.since.IsZero() {
+		st.since = now
+	}
+	if now.Sub(st.since) < r.For {
+		return nil
+	}
+	st.firing = true
+	return e.event(r, Firing, st.since, now)
+}
+
+func (e *Engine) event(r *Rule, status string, since, now time.Time) *Event {
+	return &Event{
+		Rule:      r.Name,
+		Status:    status,
+		Condition: r.Condition,
+		System:    e.System,
+		Since:     since,
+		Time:      now,
+	}
+}
+
+// Notify sends each event to the sinks for its rule. It tries every sink and
+// returns the first error.
+func (e *Engine) Notify(ctx context.Context, events []*Event) error {
+	var firstErr error
+	for _, ev := range events {
+		if err := e.send(ctx, ev); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (e *Engine) send(ctx context.Context, ev *Event) error {
+	names := e.names
+	for _, r := range e.rules {
+		if r.Name == ev.Rule && len(r.Sinks) > 0 {
+			names = r.Sinks
+		}
+	}
+	var firstErr error
+	for _, name := range names {
+		if err := e.sinks[name].Send(ctx, ev); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("sink %q: %v", name, err)
+		}
+	}
+	return firstErr
+}
+
+// Start delivers the events from Observe on a background goroutine, so slow
+// sinks don't hold up the caller. Errors from sinks are logged. Call Close to
+// deliver the events that are still queued and stop the goroutine.
+func (e *Engine) Start() {
+	e.queue = make(chan *Event, queueSize)
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		for ev := range e.queue {
+			if err := e.send(context.Background(), ev); err != nil {
+				rest.Logger.Error("could not send alert", "system", e.System, "rule", ev.Rule, "err", err)
+			}
+		}
+	}()
+}
+
+// Close waits for the events queued since Start to be delivered. It's a no-op
+// if Start wasn't called.
+func (e *Engine) Close() {
+	if e.queue == nil {
+		return
+	}
+	close(e.queue)
+	<-e.done
+	e.queue = nil
+}
+
+// Observe evaluates statuses observed at now and notifies sinks of any
+// alerts that started or stopped firing. After Start, it queues the events
+// and returns an error only if the queue is full and events were dropped.
+func (e *Engine) Observe(ctx context.Context, now time.Time, statuses []*gobike.StationStatus) error {
+	events := e.Evaluate(now, statuses)
+	if e.queue == nil {
+		return e.Notify(ctx, events)
+	}
+	dropped := 0
+	for _, ev := range events {
+		select {
+		case e.queue <- ev:
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("alert queue is full, dropped %d events", dropped)
+	}
+	return nil
+}
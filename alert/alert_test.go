@@ -0,0 +1,302 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/geo"
+)
+
+type recordSink struct {
+	events []*Event
+}
+
+func (r *recordSink) Send(ctx context.Context, e *Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+func status(id string, bikes, docks int16) *gobike.StationStatus {
+	return &gobike.StationStatus{ID: id, NumBikesAvailable: bikes, NumDocksAvailable: docks, IsInstalled: true, IsRenting: true, IsReturning: true}
+}
+
+func TestStationRule(t *testing.T) {
+	e, err := NewEngine("gobike", &Config{Rules: []*Rule{{
+		Name:      "morning-empty",
+		Condition: Empty,
+		Stations:  []string{"3"},
+		For:       30 * time.Minute,
+		Hours:     "07:00-10:00",
+		Days:      []string{"weekdays"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	// a Monday
+	start := time.Date(2018, 8, 27, 6, 50, 0, 0, loc)
+	empty := []*gobike.StationStatus{status("3", 0, 30), status("4", 0, 30)}
+	for min := 0; min <= 60; min += 5 {
+		events := e.Evaluate(start.Add(time.Duration(min)*time.Minute), empty)
+		// the clock starts at 07:00, so the rule fires at 07:30
+		wantFire := min == 40
+		if wantFire && len(events) != 1 {
+			t.Fatalf("minute %d: expected one event, got %d", min, len(events))
+		}
+		if !wantFire && len(events) != 0 {
+			t.Fatalf("minute %d: expected no events, got %v", min, events[0])
+		}
+		if wantFire {
+			ev := events[0]
+			if ev.Status != Firing || ev.StationID != "3" || ev.Time.Sub(ev.Since) != 30*time.Minute {
+				t.Errorf("bad event: %#v", ev)
+			}
+		}
+	}
+	events := e.Evaluate(start.Add(2*time.Hour), []*gobike.StationStatus{status("3", 1, 29)})
+	if len(events) != 1 || events[0].Status != Resolved {
+		t.Fatalf("expected a resolved event, got %v", events)
+	}
+	if !strings.Contains(events[0].String(), "resolved: station 3 no longer empty") {
+		t.Errorf("bad message: %q", events[0].String())
+	}
+	// Saturday
+	sat := time.Date(2018, 9, 1, 7, 0, 0, 0, loc)
+	for min := 0; min <= 60; min += 5 {
+		if events := e.Evaluate(sat.Add(time.Duration(min)*time.Minute), empty); len(events) != 0 {
+			t.Fatalf("expected no events on the weekend, got %v", events[0])
+		}
+	}
+}
+
+func TestOutOfService(t *testing.T) {
+	e, err := NewEngine("gobike", &Config{Rules: []*Rule{{
+		Name:      "empty",
+		Condition: Empty,
+		For:       10 * time.Minute,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := status("3", 0, 30)
+	closed.IsRenting = false
+	start := time.Date(2018, 8, 27, 7, 0, 0, 0, time.UTC)
+	for min := 0; min <= 30; min += 5 {
+		if events := e.Evaluate(start.Add(time.Duration(min)*time.Minute), []*gobike.StationStatus{closed}); len(events) != 0 {
+			t.Fatalf("expected a station that isn't renting not to be empty, got %v", events[0])
+		}
+	}
+}
+
+func TestCountRule(t *testing.T) {
+	e, err := NewEngine("gobike", &Config{Rules: []*Rule{{
+		Name:      "sf-full",
+		Condition: Full,
+		City:      "sf",
+		MoreThan:  1,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetStations([]*gobike.Station{
+		{ID: 3, Name: "Powell St BART", City: geo.SF},
+		{ID: 4, Name: "Cyril Magnin St", City: geo.SF},
+		{ID: 5, Name: "Grand Ave", City: geo.Oakland},
+	})
+	now := time.Date(2018, 8, 27, 12, 0, 0, 0, time.UTC)
+	events := e.Evaluate(now, []*gobike.StationStatus{status("3", 30, 0), status("4", 29, 1), status("5", 30, 0)})
+	if len(events) != 0 {
+		t.Fatalf("expected no events, got %v", events[0])
+	}
+	full := []*gobike.StationStatus{status("3", 30, 0), status("4", 30, 0), status("5", 30, 0)}
+	events = e.Evaluate(now.Add(time.Minute), full)
+	if len(events) != 1 || events[0].Count != 2 || events[0].City != "sf" {
+		t.Fatalf("expected one event for two full stations, got %v", events)
+	}
+	if events := e.Evaluate(now.Add(2*time.Minute), full); len(events) != 0 {
+		t.Fatalf("expected firing alert to be deduplicated, got %v", events[0])
+	}
+	events = e.Evaluate(now.Add(3*time.Minute), []*gobike.StationStatus{status("3", 30, 0)})
+	if len(events) != 1 || events[0].Status != Resolved || events[0].Count != 1 {
+		t.Fatalf("expected a resolved event, got %v", events)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	e, err := NewEngine("gobike", &Config{
+		Rules: []*Rule{
+			{Name: "a", Condition: Empty},
+			{Name: "b", Condition: Empty, Sinks: []string{"only-b"}},
+		},
+		Sinks: []*SinkConfig{
+			{Name: "all", Type: SinkLog},
+			{Name: "only-b", Type: SinkLog},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, onlyB := new(recordSink), new(recordSink)
+	e.sinks["all"] = all
+	e.sinks["only-b"] = onlyB
+	if err := e.Observe(context.Background(), time.Now(), []*gobike.StationStatus{status("3", 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(all.events) != 1 || all.events[0].Rule != "a" {
+		t.Errorf("expected rule a to notify every sink, got %v", all.events)
+	}
+	if len(onlyB.events) != 2 {
+		t.Errorf("expected two events in only-b sink, got %d", len(onlyB.events))
+	}
+}
+
+// slowSink blocks until release is closed.
+type slowSink struct {
+	recordSink
+	release chan struct{}
+}
+
+func (s *slowSink) Send(ctx context.Context, e *Event) error {
+	<-s.release
+	return s.recordSink.Send(ctx, e)
+}
+
+func TestStart(t *testing.T) {
+	e, err := NewEngine("gobike", &Config{
+		Rules: []*Rule{{Name: "a", Condition: Empty}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &slowSink{release: make(chan struct{})}
+	e.sinks["log"] = sink
+	e.Start()
+	// Observe returns before the sink does.
+	if err := e.Observe(context.Background(), time.Now(), []*gobike.StationStatus{status("3", 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	close(sink.release)
+	e.Close()
+	if len(sink.events) != 1 || sink.events[0].StationID != "3" {
+		t.Errorf("expected the queued event to be delivered, got %v", sink.events)
+	}
+}
+
+func TestStationLeavesFeed(t *testing.T) {
+	e, err := NewEngine("gobike", &Config{Rules: []*Rule{{Name: "a", Condition: Empty}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 8, 27, 8, 0, 0, 0, time.UTC)
+	empty := []*gobike.StationStatus{status("3", 0, 1)}
+	if events := e.Evaluate(now, empty); len(events) != 1 {
+		t.Fatalf("expected an alert, got %v", events)
+	}
+	if events := e.Evaluate(now.Add(time.Minute), nil); len(events) != 0 {
+		t.Errorf("expected no events while the station is missing, got %v", events)
+	}
+	if events := e.Evaluate(now.Add(2*time.Minute), empty); len(events) != 0 {
+		t.Errorf("expected no new alert when the station comes back empty, got %v", events)
+	}
+	e.Evaluate(now.Add(3*time.Minute), nil)
+	events := e.Evaluate(now.Add(4*time.Minute), []*gobike.StationStatus{status("3", 1, 0)})
+	if len(events) != 1 || events[0].Status != Resolved || !events[0].Since.Equal(now) {
+		t.Errorf("expected the alert to resolve when the station comes back, got %v", events)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		cfg  *Config
+		want string
+	}{
+		{&Config{Rules: []*Rule{{Condition: Empty}}}, "no name"},
+		{&Config{Rules: []*Rule{{Name: "a", Condition: "broken"}}}, "unknown condition"},
+		{&Config{Rules: []*Rule{{Name: "a", Condition: Empty, Hours: "7-10"}}}, "invalid time"},
+		{&Config{Rules: []*Rule{{Name: "a", Condition: Empty, Days: []string{"someday"}}}}, "unknown day"},
+		{&Config{Rules: []*Rule{{Name: "a", Condition: Empty, Sinks: []string{"nope"}}}}, "unknown sink"},
+		{&Config{Sinks: []*SinkConfig{{Name: "a", Type: SinkWebhook}}}, "need a url"},
+		{&Config{Sinks: []*SinkConfig{{Name: "a", Type: "pager"}}}, "unknown type"},
+	}
+	for _, tt := range tests {
+		_, err := NewEngine("gobike", tt.cfg)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expected error containing %q, got %v", tt.want, err)
+		}
+	}
+}
+
+func TestActive(t *testing.T) {
+	r := &Rule{Name: "night", Condition: Full, Hours: "22:00-02:00", Timezone: "UTC"}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	for hour, want := range map[int]bool{21: false, 22: true, 23: true, 0: true, 1: true, 2: false, 12: false} {
+		if got := r.active(time.Date(2018, 8, 27, hour, 0, 0, 0, time.UTC)); got != want {
+			t.Errorf("hour %d: expected active=%t, got %t", hour, want, got)
+		}
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got map[string]interface{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer s.Close()
+	ev := &Event{Rule: "a", Status: Firing, Condition: Empty, StationID: "3"}
+	if err := (&WebhookSink{URL: s.URL}).Send(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if got["station_id"] != "3" || got["message"] != ev.String() {
+		t.Errorf("bad webhook body: %v", got)
+	}
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fail.Close()
+	if err := (&WebhookSink{URL: fail.URL}).Send(context.Background(), ev); err == nil {
+		t.Error("expected an error from a failing webhook, got nil")
+	}
+}
+
+func TestCommandSink(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	dir, err := ioutil.TempDir("", "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	c := &CommandSink{Command: []string{"/bin/sh", "-c", `echo "$ALERT_RULE $ALERT_STATION_ID" > ` + out}}
+	if err := c.Send(context.Background(), &Event{Rule: "a", Status: Firing, StationID: "3"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a 3\n" {
+		t.Errorf("expected command to see the event, got %q", data)
+	}
+	c = &CommandSink{Command: []string{"/bin/sh", "-c", "echo oops; exit 3"}}
+	if err := c.Send(context.Background(), &Event{Rule: "a"}); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected error with command output, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/rest"
+)
+
+// Sink types.
+const (
+	SinkWebhook = "webhook"
+	SinkCommand = "command"
+	SinkLog     = "log"
+)
+
+const defaultSinkTimeout = 10 * time.Second
+
+// A Sink delivers events.
+type Sink interface {
+	Send(ctx context.Context, e *Event) error
+}
+
+// SinkConfig describes a sink in a config file.
+type SinkConfig struct {
+	// Name is used to refer to the sink from a Rule.
+	Name string `yaml:"name"`
+	// Type is "webhook", "command" or "log".
+	Type string `yaml:"type"`
+	// URL to POST events to, for webhook sinks.
+	URL string `yaml:"url"`
+	// Command and arguments to run, for command sinks.
+	Command []string `yaml:"command"`
+	// How long to wait for a webhook or command. Defaults to 10 seconds.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (sc *SinkConfig) build() (Sink, error) {
+	if sc.Name == "" {
+		return nil, errors.New("sink has no name")
+	}
+	timeout := sc.Timeout
+	if timeout == 0 {
+		timeout = defaultSinkTimeout
+	}
+	switch sc.Type {
+	case SinkWebhook:
+		if sc.URL == "" {
+			return nil, fmt.Errorf("sink %q: webhook sinks need a url", sc.Name)
+		}
+		return &WebhookSink{URL: sc.URL, Client: &http.Client{Timeout: timeout}}, nil
+	case SinkCommand:
+		if len(sc.Command) == 0 {
+			return nil, fmt.Errorf("sink %q: command sinks need a command", sc.Name)
+		}
+		return &CommandSink{Command: sc.Command, Timeout: timeout}, nil
+	case SinkLog:
+		return &LogSink{}, nil
+	default:
+		return nil, fmt.Errorf("sink %q: unknown type %q (want %q, %q or %q)", sc.Name, sc.Type, SinkWebhook, SinkCommand, SinkLog)
+	}
+}
+
+// WebhookSink POSTs each event as JSON to URL. The JSON object has the same
+// fields as Event, plus a "message" field with the text of Event.String.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+type eventJSON struct {
+	*Event
+	Message string `json:"message"`
+}
+
+func marshalEvent(e *Event) ([]byte, error) {
+	return json.Marshal(&eventJSON{Event: e, Message: e.String()})
+}
+
+func (w *WebhookSink) Send(ctx context.Context, e *Event) error {
+	data, err := marshalEvent(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gobike-alert/"+gobike.Version)
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s: unexpected status %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// CommandSink runs Command for each event. The event is written to the
+// command's standard input as JSON, and the most useful fields are also set in
+// the environment: ALERT_RULE, ALERT_STATUS, ALERT_STATION_ID and
+// ALERT_MESSAGE.
+type CommandSink struct {
+	Command []string
+	Timeout time.Duration
+}
+
+func (c *CommandSink) Send(ctx context.Context, e *Event) error {
+	data, err := marshalEvent(e)
+	if err != nil {
+		return err
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"ALERT_RULE="+e.Rule,
+		"ALERT_STATUS="+e.Status,
+		"ALERT_STATION_ID="+e.StationID,
+		"ALERT_MESSAGE="+e.String(),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", c.Command[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// LogSink writes events to rest.Logger.
+type LogSink struct{}
+
+func (l *LogSink) Send(ctx context.Context, e *Event) error {
+	if e.Status == Resolved {
+		rest.Logger.Info(e.String(), "rule", e.Rule, "status", e.Status, "system", e.System, "station", e.StationID)
+	} else {
+		rest.Logger.Warn(e.String(), "rule", e.Rule, "status", e.Status, "system", e.System, "station", e.StationID)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// FreeBikeService retrieves the GBFS free_bike_status feed, which lists
+// vehicles that are parked away from a station.
+type FreeBikeService struct {
+	client *Client
+}
+
+type FreeBikeStatusResponse struct {
+	Response
+	Bikes []*gobike.FreeBike
+}
+
+type freeBikeStatusResponse struct {
+	response
+	Data *freeBikeStatusData `json:"data"`
+}
+
+type freeBikeStatusData struct {
+	Bikes []*freeBikeJSON `json:"bikes"`
+}
+
+type freeBikeJSON struct {
+	BikeID        string   `json:"bike_id"`
+	Lat           float64  `json:"lat"`
+	Lon           float64  `json:"lon"`
+	IsReserved    gbfsBool `json:"is_reserved"`
+	IsDisabled    gbfsBool `json:"is_disabled"`
+	VehicleTypeID string   `json:"vehicle_type_id,omitempty"`
+	// Added in GBFS 2.1; older feeds don't include it.
+	LastReported int64 `json:"last_reported,omitempty"`
+}
+
+// gbfsBool is a boolean that may be encoded as 0 or 1 (GBFS 1.x) or as true or
+// false (GBFS 2.x).
+type gbfsBool bool
+
+func (b *gbfsBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "1", "true":
+		*b = true
+	case "0", "false", "null":
+		*b = false
+	default:
+		var v bool
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*b = gbfsBool(v)
+	}
+	return nil
+}
+
+// Status returns the vehicles that are currently parked away from a station.
+func (s *FreeBikeService) Status(ctx context.Context) (*FreeBikeStatusResponse, error) {
+	req, err := s.client.NewRequest("GET", "/free_bike_status.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	body := new(freeBikeStatusResponse)
+	if err := s.client.Client.Do(req, body); err != nil {
+		return nil, err
+	}
+	return buildFreeBikes(body), nil
+}
+
+func buildFreeBikes(body *freeBikeStatusResponse) *FreeBikeStatusResponse {
+	lastUpdated := time.Unix(body.LastUpdated, 0)
+	var bikes []*freeBikeJSON
+	if body.Data != nil {
+		bikes = body.Data.Bikes
+	}
+	resp := &FreeBikeStatusResponse{
+		Response: Response{
+			LastUpdated: lastUpdated,
+			TTL:         body.TTL,
+		},
+		Bikes: make([]*gobike.FreeBike, len(bikes)),
+	}
+	for i, b := range bikes {
+		reported := lastUpdated
+		if b.LastReported != 0 {
+			reported = time.Unix(b.LastReported, 0)
+		}
+		resp.Bikes[i] = &gobike.FreeBike{
+			ID:           b.BikeID,
+			Latitude:     b.Lat,
+			Longitude:    b.Lon,
+			VehicleType:  b.VehicleTypeID,
+			IsReserved:   bool(b.IsReserved),
+			IsDisabled:   bool(b.IsDisabled),
+			LastReported: reported,
+		}
+	}
+	return resp
+}
+
+func (fr *FreeBikeStatusResponse) MarshalJSON() ([]byte, error) {
+	fr2 := &freeBikeStatusResponse{
+		response: response{
+			LastUpdated: fr.LastUpdated.Unix(),
+			TTL:         fr.TTL,
+		},
+		Data: &freeBikeStatusData{
+			Bikes: make([]*freeBikeJSON, len(fr.Bikes)),
+		},
+	}
+	for i, b := range fr.Bikes {
+		fr2.Data.Bikes[i] = &freeBikeJSON{
+			BikeID:        b.ID,
+			Lat:           b.Latitude,
+			Lon:           b.Longitude,
+			IsReserved:    gbfsBool(b.IsReserved),
+			IsDisabled:    gbfsBool(b.IsDisabled),
+			VehicleTypeID: b.VehicleType,
+			LastReported:  b.LastReported.Unix(),
+		}
+	}
+	return json.Marshal(fr2)
+}
+
+func (fr *FreeBikeStatusResponse) UnmarshalJSON(data []byte) error {
+	body := new(freeBikeStatusResponse)
+	if err := json.Unmarshal(data, body); err != nil {
+		return err
+	}
+	*fr = *buildFreeBikes(body)
+	return nil
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const freeBikeStatus = `{"last_updated": 1535241600, "ttl": 10, "data": {"bikes": [
+	{"bike_id": "a", "lat": 37.78, "lon": -122.41, "is_reserved": 0, "is_disabled": 1},
+	{"bike_id": "b", "lat": 37.79, "lon": -122.42, "is_reserved": true, "is_disabled": false, "vehicle_type_id": "ebike", "last_reported": 1535241500}
+]}}`
+
+func TestFreeBikeStatus(t *testing.T) {
+	resp := new(FreeBikeStatusResponse)
+	if err := json.Unmarshal([]byte(freeBikeStatus), resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Bikes) != 2 {
+		t.Fatalf("expected 2 bikes, got %d", len(resp.Bikes))
+	}
+	a, b := resp.Bikes[0], resp.Bikes[1]
+	if a.IsReserved || !a.IsDisabled || !a.LastReported.Equal(resp.LastUpdated) {
+		t.Errorf("bad GBFS 1.0 bike: %#v", a)
+	}
+	if !b.IsReserved || b.IsDisabled || b.VehicleType != "ebike" || b.LastReported.Unix() != 1535241500 {
+		t.Errorf("bad GBFS 2.1 bike: %#v", b)
+	}
+}
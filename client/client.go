@@ -27,16 +27,24 @@ type Client struct {
 	Client *rest.Client
 	Host   string
 
-	Stations *StationService
+	Stations  *StationService
+	FreeBikes *FreeBikeService
 }
 
 // NewClient returns a new Client.
 func NewClient() *Client {
+	return NewClientWithHost(Host)
+}
+
+// NewClientWithHost returns a new Client that retrieves GBFS feeds from host
+// instead of the GoBike servers, for example a gbfstest.Server.
+func NewClientWithHost(host string) *Client {
 	c := new(Client)
-	c.Host = Host
-	c.Client = rest.NewClient("", "", Host)
+	c.Host = host
+	c.Client = rest.NewClient("", "", host)
 
 	c.Stations = &StationService{client: c}
+	c.FreeBikes = &FreeBikeService{client: c}
 	return c
 }
 
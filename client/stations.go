@@ -93,6 +93,20 @@ func (s *StationService) loadStationsFromDisk() (*StationResponse, error) {
 	return resp, nil
 }
 
+// LoadStations reads a station_information.json file, like the one in the
+// data directory.
+func LoadStations(filename string) ([]*gobike.Station, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(StationResponse)
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	return resp.Stations, nil
+}
+
 func buildStations(body *stationResponse) (*StationResponse, error) {
 	stationJSONs := body.Data.Stations
 	stations := make([]*gobike.Station, len(stationJSONs))
@@ -216,6 +230,19 @@ func (sr *StationResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(sr2)
 }
 
+func (sr *StationResponse) UnmarshalJSON(data []byte) error {
+	body := new(stationResponse)
+	if err := json.Unmarshal(data, body); err != nil {
+		return err
+	}
+	resp, err := buildStations(body)
+	if err != nil {
+		return err
+	}
+	*sr = *resp
+	return nil
+}
+
 var cities = map[string]*geo.City{
 	"bayarea":    nil,
 	"berkeley":   geo.Berkeley,
@@ -235,7 +262,14 @@ func (s *StationService) Status(ctx context.Context) (*StationStatusResponse, er
 	if err := s.client.Client.Do(req, body); err != nil {
 		return nil, err
 	}
-	stations := body.Data.Stations
+	return buildStationStatuses(body), nil
+}
+
+func buildStationStatuses(body *stationStatusResponse) *StationStatusResponse {
+	var stations []*stationStatusJSON
+	if body.Data != nil {
+		stations = body.Data.Stations
+	}
 	stationStatuses := make([]*gobike.StationStatus, len(stations))
 	for i := 0; i < len(stations); i++ {
 		stationStatuses[i] = newStationStatus(stations[i])
@@ -249,5 +283,49 @@ func (s *StationService) Status(ctx context.Context) (*StationStatusResponse, er
 			TTL:         body.TTL,
 		},
 		Stations: stationStatuses,
-	}, nil
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (sr *StationStatusResponse) MarshalJSON() ([]byte, error) {
+	sr2 := &stationStatusResponse{
+		response: response{
+			LastUpdated: sr.LastUpdated.Unix(),
+			TTL:         sr.TTL,
+		},
+		Data: &stationStatusData{
+			Stations: make([]*stationStatusJSON, len(sr.Stations)),
+		},
+	}
+	for i := range sr.Stations {
+		ss := sr.Stations[i]
+		sr2.Data.Stations[i] = &stationStatusJSON{
+			StationID:          ss.ID,
+			NumBikesAvailable:  int(ss.NumBikesAvailable),
+			NumEBikesAvailable: int(ss.NumEBikesAvailable),
+			NumBikesDisabled:   int(ss.NumBikesDisabled),
+			NumDocksAvailable:  int(ss.NumDocksAvailable),
+			NumDocksDisabled:   int(ss.NumDocksDisabled),
+			LastReported:       ss.LastReported.Unix(),
+			IsInstalled:        boolToInt(ss.IsInstalled),
+			IsRenting:          boolToInt(ss.IsRenting),
+			IsReturning:        boolToInt(ss.IsReturning),
+		}
+	}
+	return json.Marshal(sr2)
+}
+
+func (sr *StationStatusResponse) UnmarshalJSON(data []byte) error {
+	body := new(stationStatusResponse)
+	if err := json.Unmarshal(data, body); err != nil {
+		return err
+	}
+	*sr = *buildStationStatuses(body)
+	return nil
 }
@@ -1,18 +1,44 @@
-package client
+package client_test
 
 import (
 	"context"
-	"fmt"
+	"path/filepath"
 	"testing"
+
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/gbfstest"
 )
 
 func TestStationsAll(t *testing.T) {
-	c := NewClient()
+	s, err := gbfstest.NewServerFromDir(filepath.Join("..", "gbfstest", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := client.NewClientWithHost(s.URL)
 	stations, err := c.Stations.All(context.TODO())
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(stations.Stations) != 5 {
+		t.Fatalf("expected 5 stations, got %d", len(stations.Stations))
+	}
 	for i := range stations.Stations {
-		fmt.Println(stations.Stations[i].ID, stations.Stations[i].Name)
+		if stations.Stations[i].ID == 0 || stations.Stations[i].Name == "" {
+			t.Errorf("bad station: %#v", stations.Stations[i])
+		}
+	}
+}
+
+func TestLoadStations(t *testing.T) {
+	stations, err := client.LoadStations(filepath.Join("..", "gbfstest", "testdata", "station_information.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) == 0 || stations[0].Name == "" {
+		t.Errorf("expected stations with names, got %v", stations)
+	}
+	if _, err := client.LoadStations(filepath.Join("testdata", "missing.json")); err == nil {
+		t.Error("expected an error loading a missing file")
 	}
 }
@@ -42,13 +42,13 @@ func main() {
 		for j := 0; j < len(byStation[id]); j++ {
 			measurements := byStation[id]
 			status := measurements[j]
-			if status.NumBikesAvailable == 0 {
+			if status.Empty() {
 				if j < len(measurements)-1 {
 					dur := measurements[j+1].LastReported.Sub(status.LastReported)
 					empty[id] = empty[id] + dur
 				}
 			}
-			if status.NumDocksAvailable == 0 {
+			if status.Full() {
 				if j < len(measurements)-1 {
 					dur := measurements[j+1].LastReported.Sub(status.LastReported)
 					full[id] = full[id] + dur
@@ -0,0 +1,191 @@
+// Command forecast-station-capacity predicts how many bikes and docks each
+// station will have 15, 30 and 60 minutes after the last status in the
+// capacity files written by monitor-station-capacity, and lists the stations
+// that are about to run out of bikes or docks.
+//
+//	forecast-station-capacity data/station-capacity
+//
+// With -backtest, it learns from all but the last -test of the files, then
+// replays the rest, predicting every station every -step, and prints how far
+// off the predictions were:
+//
+//	forecast-station-capacity -backtest -test 48h data/station-capacity
+//
+// With -trips, the net flow of bikes from the trips in that directory is used
+// as a starting point for each station's usual pattern on weekdays, which
+// helps stations without much capacity history:
+//
+//	forecast-station-capacity -trips data data/station-capacity
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/stats"
+)
+
+func lastReported(byStation map[string][]*gobike.StationStatus) (first, last time.Time) {
+	for _, statuses := range byStation {
+		if len(statuses) == 0 {
+			continue
+		}
+		if t := statuses[0].LastReported; first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t := statuses[len(statuses)-1].LastReported; t.After(last) {
+			last = t
+		}
+	}
+	return first, last
+}
+
+// newForecaster learns from byStation before end, and from the trips that
+// started before it, if there are any.
+func newForecaster(byStation map[string][]*gobike.StationStatus, end time.Time, stationMap map[string]*gobike.Station, trips []*gobike.Trip, trendWeight float64) *stats.Forecaster {
+	f := stats.NewForecaster(byStation, end)
+	f.TrendWeight = trendWeight
+	if len(trips) > 0 {
+		before := make([]*gobike.Trip, 0, len(trips))
+		for _, t := range trips {
+			if t.StartTime.Before(end) {
+				before = append(before, t)
+			}
+		}
+		f.UseFlows(stats.StationFlows(stationMap, before, nil))
+	}
+	return f
+}
+
+// share formats a share of n things, or "-" if n is 0.
+func share(f float64, n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", f)
+}
+
+func backtest(w *tabwriter.Writer, byStation map[string][]*gobike.StationStatus, f func(end time.Time) *stats.Forecaster, test, step time.Duration) {
+	first, last := lastReported(byStation)
+	trainEnd := last.Add(-test)
+	if !trainEnd.After(first) {
+		log.Fatalf("-test %s leaves no data to learn from; the files cover %s", test, last.Sub(first))
+	}
+	fmt.Fprintf(w, "# learning from %s to %s, testing to %s\n", first.Format(time.RFC3339), trainEnd.Format(time.RFC3339), last.Format(time.RFC3339))
+	fmt.Fprintln(w, "horizon\tpredictions\tmae\trmse\tpersistence_mae\tempty_precision\tempty_recall")
+	for _, r := range stats.Backtest(f(trainEnd), byStation, trainEnd, last, step, stats.ForecastHorizons) {
+		fmt.Fprintf(w, "%s\t%d\t%.2f\t%.2f\t%.2f\t%s\t%s\n", r.Horizon, r.Predictions, r.MAE, r.RMSE, r.PersistenceMAE, share(r.EmptyPrecision, r.PredictedEmpty), share(r.EmptyRecall, r.ActualEmpty))
+	}
+}
+
+func main() {
+	doBacktest := flag.Bool("backtest", false, "Measure how well the forecasts do against the capacity files")
+	test := flag.Duration("test", 24*time.Hour, "With -backtest, how much of the end of the data to test on")
+	step := flag.Duration("step", 15*time.Minute, "With -backtest, how often to make predictions")
+	trendWeight := flag.Float64("trend-weight", 0.5, "How much the recent trend counts against the seasonal baseline, from 0 to 1")
+	all := flag.Bool("all", false, "Print every station, not just the ones predicted to run out of bikes or docks")
+	info := flag.String("station-information", "data/station_information.json", "station_information.json file for station names; may be empty without -trips")
+	tripDir := flag.String("trips", "", "Directory of trip CSV files to learn the usual flow of bikes from")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: forecast-station-capacity [flags] capacity-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *step <= 0 {
+		log.Fatal("-step must be positive")
+	}
+	if *trendWeight < 0 || *trendWeight > 1 {
+		log.Fatal("-trend-weight must be between 0 and 1")
+	}
+	statuses, err := gobike.LoadCapacityDir(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(statuses) == 0 {
+		log.Fatalf("no statuses in %s", flag.Arg(0))
+	}
+	byStation := stats.StatusMap(statuses)
+	if *tripDir != "" && *info == "" {
+		log.Fatal("-trips needs -station-information")
+	}
+	var stations []*gobike.Station
+	if *info != "" {
+		stations, err = client.LoadStations(*info)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	stationMap := gobike.StationMap(stations)
+	var trips []*gobike.Trip
+	if *tripDir != "" {
+		trips, err = gobike.LoadDir(*tripDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	forecaster := func(end time.Time) *stats.Forecaster {
+		return newForecaster(byStation, end, stationMap, trips, *trendWeight)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if *doBacktest {
+		backtest(w, byStation, forecaster, *test, *step)
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	_, now := lastReported(byStation)
+	f := forecaster(now)
+	ids := make([]string, 0, len(byStation))
+	for id := range byStation {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Fprintf(w, "# as of %s\n", now.Format(time.RFC3339))
+	header := "station_id\tname\tbikes\tdocks"
+	for _, h := range stats.ForecastHorizons {
+		header += fmt.Sprintf("\tbikes_%s\tdocks_%s", h, h)
+	}
+	fmt.Fprintln(w, header+"\talert")
+	for _, id := range ids {
+		var row, alert string
+		var current *gobike.StationStatus
+		for _, h := range stats.ForecastHorizons {
+			fc, ok := f.Predict(id, byStation[id], now, h)
+			if !ok {
+				break
+			}
+			current = fc.Current
+			row += fmt.Sprintf("\t%.1f\t%.1f", fc.Bikes, fc.Docks)
+			if alert == "" && fc.Empty() && current.HasBike() {
+				alert = "empty in " + h.String()
+			}
+			if alert == "" && fc.Full() && current.HasDock() {
+				alert = "full in " + h.String()
+			}
+		}
+		if current == nil || (!*all && alert == "") {
+			continue
+		}
+		name := ""
+		if s, ok := stationMap[id]; ok {
+			name = s.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d%s\t%s\n", id, name, current.NumBikesAvailable, current.NumDocksAvailable, row, alert)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+// Command gobike-commutes finds the commutes in the trip data: pairs of
+// stations, cities or San Francisco supervisor districts that riders go
+// between one way in the morning peak (7-10am) and back in the evening peak
+// (4-7pm), on at least half of weekdays. Weekends, holidays and the events in
+// the -events calendar are left out.
+//
+//	gobike-commutes -level city data
+//	gobike-commutes -events data/events.csv data
+//
+// With -report corridors, it prints every pair of zones with peak trips
+// between them instead, and how lopsided each peak is, from 1 if every trip
+// went from the first zone to the second to -1 if every trip went back:
+//
+//	gobike-commutes -level district -report corridors data
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/stats"
+)
+
+func main() {
+	levelFlag := flag.String("level", "station", "Zones to count trips between: station, city or district")
+	report := flag.String("report", "commutes", "What to print: commutes or corridors")
+	info := flag.String("station-information", "data/station_information.json", "station_information.json file for station names and locations; may be empty")
+	events := flag.String("events", "", "CSV file of events like Bay to Breakers to leave out, like holidays")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gobike-commutes [flags] trip-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *report != "commutes" && *report != "corridors" {
+		log.Fatalf("unknown -report %q", *report)
+	}
+	level, err := stats.ParseODLevel(*levelFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *events != "" {
+		calendar, err := stats.LoadCalendar(*events)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stats.SetCalendar(calendar)
+	}
+	var stations []*gobike.Station
+	if *info != "" {
+		stations, err = client.LoadStations(*info)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	trips, err := gobike.LoadDir(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := stats.NewCommutes(trips, gobike.StationMap(stations), level)
+	if c.Skipped > 0 {
+		log.Printf("skipped %d trips that started or ended outside every %s", c.Skipped, level)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if *report == "corridors" {
+		fmt.Fprintln(w, "a\tb\tam_a_to_b\tam_b_to_a\tam_imbalance\tpm_a_to_b\tpm_b_to_a\tpm_imbalance")
+		for _, cr := range c.Corridors() {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.2f\t%d\t%d\t%.2f\n", cr.A.Name, cr.B.Name,
+				cr.AM[0], cr.AM[1], cr.AMImbalance(), cr.PM[0], cr.PM[1], cr.PMImbalance())
+		}
+	} else {
+		fmt.Printf("%d weekdays, not counting holidays and events\n\n", c.Days)
+		fmt.Fprintln(w, "from\tto\tam_trips\tam_days\tpm_trips\tpm_days")
+		for _, f := range c.Flows() {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", f.From.Name, f.To.Name, f.AM, f.AMDays, f.PM, f.PMDays)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,68 @@
+// Command gobike-fleet reconstructs the history of every bike from the trip
+// data, and prints a summary of the fleet followed by the bikes worth a closer
+// look: bikes that went more than a week without a trip, turned up far from
+// where they were last docked, or haven't been ridden lately.
+//
+//	gobike-fleet data
+//	gobike-fleet -all data > bikes.txt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/stats"
+)
+
+func days(d time.Duration) string {
+	return fmt.Sprintf("%.1f", d.Hours()/24)
+}
+
+func main() {
+	all := flag.Bool("all", false, "Print every bike, not just flagged ones")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gobike-fleet [flags] trip-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	trips, err := gobike.LoadDir(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	histories := stats.BikeHistories(trips)
+	s := stats.SummarizeFleet(histories)
+	fmt.Printf("%d bikes: %d active, %d idle, %d missing\n", s.Bikes, s.Active, s.Idle, s.Missing)
+	fmt.Printf("%d trips, %.0f miles; median %.0f trips and %.0f miles per bike\n", s.Trips, s.Miles, s.MedianTripsPerBike, s.MedianMilesPerBike)
+	fmt.Printf("%d bikes went more than a week without a trip, %d turned up far from where they were left\n\n", s.BikesWithGaps, s.BikesWithReappearances)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "bike_id\tstatus\tfirst_seen\tlast_seen\tlast_station\ttrips\tmiles\tlongest_idle_days\tflags")
+	for _, b := range histories {
+		if !*all && !b.Flagged() {
+			continue
+		}
+		var flags []string
+		for _, g := range b.Gaps {
+			flags = append(flags, fmt.Sprintf("gap of %s days after %s", days(g.Duration()), g.After.Format("2006-01-02")))
+		}
+		for _, m := range b.Reappearances {
+			flags = append(flags, fmt.Sprintf("moved %.1f miles from %q to %q", m.Distance, m.FromStationID, m.ToStationID))
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%.1f\t%s\t%s\n", b.BikeID, b.Status,
+			b.FirstSeen.Format("2006-01-02"), b.LastSeen.Format("2006-01-02"), b.LastStationID,
+			b.Trips, b.Miles, days(b.LongestIdle), strings.Join(flags, "; "))
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
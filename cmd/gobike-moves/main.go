@@ -0,0 +1,134 @@
+// Command gobike-moves prints the bikes that were moved between trips, as
+// inferred from the trip data.
+//
+//	gobike-moves data
+//	gobike-moves -report station data
+//	gobike-moves -report day -kind truck,valet data
+//
+// A bike was moved if it started a trip somewhere other than where its last
+// trip ended. Each move is classified as a truck move, a valet move (a short
+// distance, usually to or from a corral), a depot visit, or a dockless bike
+// being collected or left.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/stats"
+)
+
+var kindsByName = map[string]stats.MoveKind{
+	stats.TruckMove.String():    stats.TruckMove,
+	stats.ValetMove.String():    stats.ValetMove,
+	stats.DepotMove.String():    stats.DepotMove,
+	stats.DocklessMove.String(): stats.DocklessMove,
+}
+
+func parseKinds(s string) ([]stats.MoveKind, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var kinds []stats.MoveKind
+	for _, name := range strings.Split(s, ",") {
+		kind, ok := kindsByName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown move kind %q (use truck, valet, depot or dockless)", name)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+func main() {
+	report := flag.String("report", "moves", "What to print: moves, station, day or direction")
+	kindFlag := flag.String("kind", "", "Only count moves of these kinds, e.g. truck,valet")
+	info := flag.String("station-information", "data/station_information.json", "station_information.json file for station names; may be empty")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gobike-moves [flags] trip-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	kinds, err := parseKinds(*kindFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var stations []*gobike.Station
+	if *info != "" {
+		stations, err = client.LoadStations(*info)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	stationMap := gobike.StationMap(stations)
+	name := func(id string) string {
+		if id == "" {
+			return "(dockless)"
+		}
+		if id == gobike.DepotStationID {
+			return "(depot)"
+		}
+		if s, ok := stationMap[id]; ok {
+			return s.Name
+		}
+		return id
+	}
+	trips, err := gobike.LoadDir(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	moves := stats.InferMoves(trips)
+	if len(kinds) > 0 {
+		filtered := moves[:0]
+		for _, m := range moves {
+			for _, k := range kinds {
+				if m.Kind == k {
+					filtered = append(filtered, m)
+					break
+				}
+			}
+		}
+		moves = filtered
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	switch *report {
+	case "moves":
+		fmt.Fprintln(w, "bike_id\tkind\tfrom\tto\tmiles\tafter\tbefore")
+		for _, m := range moves {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.2f\t%s\t%s\n", m.BikeID, m.Kind, name(m.FromStationID), name(m.ToStationID), m.Distance, m.After.Format(time.RFC3339), m.Before.Format(time.RFC3339))
+		}
+	case "station":
+		fmt.Fprintln(w, "station\tremoved\tadded\tnet\ttruck\tvalet\tdepot\tdockless")
+		for _, s := range stats.MovesPerStation(moves) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%+d\t%d\t%d\t%d\t%d\n", name(s.StationID), s.Removed, s.Added, s.Net(),
+				s.ByKind[stats.TruckMove], s.ByKind[stats.ValetMove], s.ByKind[stats.DepotMove], s.ByKind[stats.DocklessMove])
+		}
+	case "day":
+		fmt.Fprintln(w, "day\tmoves")
+		for _, stat := range stats.MovesPerDay(moves) {
+			fmt.Fprintf(w, "%s\t%.0f\n", stat.Date.Format("2006-01-02"), stat.Data)
+		}
+	case "direction":
+		fmt.Fprintln(w, "from\tto\tmoves")
+		for _, r := range stats.MovesPerDirection(moves) {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", name(r.FromStationID), name(r.ToStationID), r.Count)
+		}
+	default:
+		log.Fatalf("unknown -report %q", *report)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
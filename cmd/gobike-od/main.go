@@ -0,0 +1,131 @@
+// Command gobike-od prints the number of trips between every pair of
+// stations, cities or San Francisco supervisor districts.
+//
+//	gobike-od -level city data
+//	gobike-od -level district -user-type Subscriber -hours 7-9 -format geojson data > am-commute.geojson
+//	gobike-od -start 2018-08-01 -end 2018-09-01 -format json data
+//
+// Times and hours are in America/Los_Angeles.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/stats"
+)
+
+// parseHours parses a comma separated list of hours or ranges of hours, like
+// "7-9,16-18". Ranges include both ends.
+func parseHours(s string) ([]int, error) {
+	var hours []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		from, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("bad hour %q", part)
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("bad hour %q", part)
+		}
+		if from < 0 || to > 23 || from > to {
+			return nil, fmt.Errorf("bad hour range %q", part)
+		}
+		for h := from; h <= to; h++ {
+			hours = append(hours, h)
+		}
+	}
+	return hours, nil
+}
+
+func main() {
+	levelFlag := flag.String("level", "station", "Zones to count trips between: station, city or district")
+	startFlag := flag.String("start", "", "Only count trips that start on or after this date")
+	endFlag := flag.String("end", "", "Only count trips that start before this date")
+	userType := flag.String("user-type", "", "Only count trips by this type of user (Subscriber or Customer)")
+	hoursFlag := flag.String("hours", "", "Only count trips that start in these hours, e.g. 7-9,16-18")
+	format := flag.String("format", "csv", "Output format: csv, json or geojson")
+	info := flag.String("station-information", "data/station_information.json", "station_information.json file for station names and locations; may be empty")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gobike-od [flags] trip-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "csv" && *format != "json" && *format != "geojson" {
+		log.Fatalf("unknown -format %q", *format)
+	}
+	level, err := stats.ParseODLevel(*levelFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tz, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter := &stats.ODFilter{UserType: *userType}
+	if *startFlag != "" {
+		filter.Start, err = time.ParseInLocation("2006-01-02", *startFlag, tz)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *endFlag != "" {
+		filter.End, err = time.ParseInLocation("2006-01-02", *endFlag, tz)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *hoursFlag != "" {
+		filter.Hours, err = parseHours(*hoursFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var stations []*gobike.Station
+	if *info != "" {
+		stations, err = client.LoadStations(*info)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	trips, err := gobike.LoadDir(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	m := stats.NewODMatrix(trips, gobike.StationMap(stations), level, filter)
+	if m.Skipped > 0 {
+		log.Printf("skipped %d trips that started or ended outside every %s", m.Skipped, level)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	switch *format {
+	case "csv":
+		err = m.WriteCSV(w)
+	case "json":
+		err = json.NewEncoder(w).Encode(m)
+	case "geojson":
+		err = m.WriteGeoJSON(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
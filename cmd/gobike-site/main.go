@@ -13,7 +13,9 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kevinburke/gobike"
@@ -32,6 +34,8 @@ type homepageData struct {
 
 	TripsPerWeek             template.JS
 	TripsPerWeekCount        int64
+	JourneysPerWeek          template.JS
+	JourneysPerWeekCount     int64
 	StationsPerWeek          template.JS
 	StationsPerWeekCount     int64
 	BikesPerWeek             template.JS
@@ -45,16 +49,60 @@ type homepageData struct {
 	MovesPerWeek      template.JS
 	MovesPerWeekCount int64
 
+	UsabilityPerWeek template.JS
+	// LatestUsability is the share of the last full week stations were
+	// usable, or "" if there's no capacity data for it.
+	LatestUsability string
+
+	DisabledBikesPerWeek, DisabledDocksPerWeek template.JS
+	// LatestDisabledBikes and LatestDisabledDocks are the average number
+	// disabled in the last full week, or "" if there's no capacity data.
+	LatestDisabledBikes, LatestDisabledDocks string
+	DisabledStations                         []*disabledStation
+
+	EBikeSharePerWeek, EBikeAvailablePerWeek template.JS
+	// LatestEBikeShare is the percentage of available bikes that were
+	// e-bikes in the last full week, and LatestEBikeAvailable the percentage
+	// of the time stations had one; both are "" without capacity data.
+	LatestEBikeShare, LatestEBikeAvailable string
+	// EBikeMornings is the number of weekday mornings stations had an e-bike
+	// at the start of stats.EBikeMorning over EBikeLookback.
+	EBikeMornings                          int
+	EBikeMorningStart, EBikeMorningEnd     int
+	EBikeDepletedPct, EBikeMedianDepletion string
+	// EBikeRanOut is true if stations ran out of e-bikes on any morning,
+	// even if too few to show in EBikeDepletedPct.
+	EBikeRanOut   bool
+	EBikeLookback string
+	EBikeStations []*ebikeStation
+
 	EmptyStations, FullStations template.JS
 
+	Comparisons []*stats.Comparison
+
 	PopularStations     []*stats.StationCount
 	PopularBS4AStations []*stats.StationCount
 	TripsByDistrict     [11]int
 	Population          int
 	ShareOfTotalTrips   string
 	AverageWeekdayTrips string
+	// SpecialDaysLastWeek names the holidays and events left out of
+	// AverageWeekdayTrips, or is "" if there weren't any.
+	SpecialDaysLastWeek string
 	EstimatedTotalTrips string
 
+	// SpecialDays are the holidays and events to mark on the weekly charts.
+	SpecialDays template.JS
+
+	// WeatherStation is the name of the weather station the weather comes
+	// from, or "" if there's no weather for the city.
+	WeatherStation                       string
+	WeatherAdjustedTripsPerWeek          template.JS
+	RainyWeekdayTrips, DryWeekdayTrips   string
+	RainyWeekendTrips, DryWeekendTrips   string
+	WeekdayRainEffect, WeekendRainEffect string
+	RainyDay                             float64
+
 	RunRate       template.JS
 	LatestRunRate string
 
@@ -67,54 +115,251 @@ type stationData struct {
 	Stations []*stats.StationCount
 }
 
-func empty(city *geo.City, stationMap map[string]*gobike.Station) func(ss *gobike.StationStatus) bool {
-	return func(ss *gobike.StationStatus) bool {
-		station := stationMap[ss.ID]
-		// yuck pointer comparison
-		if station == nil {
-			return true
+type stationPageData struct {
+	Area     string
+	Station  *stats.StationCount
+	Lookback string
+	// Bikes, Docks and EBikes are JSON arrays from newHeatmap, or "" without
+	// capacity data.
+	Bikes  template.JS
+	Docks  template.JS
+	EBikes template.JS
+	// EBikeMornings describes how often the station ran out of e-bikes on
+	// weekday mornings, or is "" if it never had one then.
+	EBikeMornings string
+}
+
+// newHeatmap returns the percent of the time prob says the station had what
+// a rider needs in each slot of the week, as a JSON array the station page
+// draws a heatmap from. Slots without data are null.
+func newHeatmap(prob func(slot int) (float64, bool)) (template.JS, error) {
+	percents := make([]*int, stats.SlotsPerWeek)
+	for slot := range percents {
+		if p, ok := prob(slot); ok {
+			percent := int(math.Round(100 * p))
+			percents[slot] = &percent
 		}
-		if city != nil && station.City != city {
-			return false
+	}
+	data, err := json.Marshal(percents)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(data), nil
+}
+
+// describeLookback formats d as a number of weeks or days.
+func describeLookback(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	switch {
+	case days == 7:
+		return "week"
+	case days%7 == 0:
+		return fmt.Sprintf("%d weeks", days/7)
+	case days == 1:
+		return "day"
+	default:
+		return fmt.Sprintf("%d days", days)
+	}
+}
+
+// describeDuration formats d to the nearest minute, or hour if it's longer
+// than a day, like "1h 5m" or "3d 4h".
+func describeDuration(d time.Duration) string {
+	if days := int(d.Hours() / 24); days > 0 {
+		return fmt.Sprintf("%dd %dh", days, int(d.Hours())%24)
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// describeSpecialDays lists the names of the holidays and events, once each,
+// like "Memorial Day, Bay to Breakers".
+func describeSpecialDays(events []stats.Event) string {
+	names := make([]string, 0, len(events))
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if !seen[e.Name] {
+			seen[e.Name] = true
+			names = append(names, e.Name)
 		}
-		if !ss.IsInstalled || !ss.IsRenting {
-			return false
+	}
+	return strings.Join(names, ", ")
+}
+
+// describeMornings describes how often a station ran out of e-bikes on
+// weekday mornings, or returns "" if it never had one at the start of them.
+func describeMornings(se *stats.StationEBikes) string {
+	if se.Mornings == 0 {
+		return ""
+	}
+	morning := fmt.Sprintf("%d:00 to %d:00", stats.EBikeMorning.Start, stats.EBikeMorning.End)
+	if len(se.Depletions) == 0 {
+		return fmt.Sprintf("It had an e-bike at the start of %d weekday mornings, and never ran out before %d:00.", se.Mornings, stats.EBikeMorning.End)
+	}
+	return fmt.Sprintf("It ran out of e-bikes between %s on %d of the %d weekday mornings it had one at the start, after %s on the median morning.", morning, len(se.Depletions), se.Mornings, describeDuration(se.MedianDepletion()))
+}
+
+// ebikeStation is a station that runs out of e-bikes on weekday mornings.
+type ebikeStation struct {
+	*stats.StationEBikes
+	Station *gobike.Station
+}
+
+func (e *ebikeStation) MedianDepletionString() string {
+	return describeDuration(e.MedianDepletion())
+}
+
+// minEBikeMornings is how many mornings a station needs to have had an
+// e-bike at the start of to be ranked by how often it runs out.
+const minEBikeMornings = 3
+
+// fastestEBikeDepletion returns up to n stations in stationMap that run out
+// of e-bikes on the most weekday mornings, then the fastest.
+func fastestEBikeDepletion(stationMap map[string]*gobike.Station, stations []*stats.StationEBikes, n int) []*ebikeStation {
+	ranked := make([]*stats.StationEBikes, 0)
+	for _, se := range stations {
+		if se.Mornings >= minEBikeMornings && len(se.Depletions) > 0 {
+			ranked = append(ranked, se)
 		}
-		return ss.NumBikesAvailable == 0
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].DepletedPct() != ranked[j].DepletedPct() {
+			return ranked[i].DepletedPct() > ranked[j].DepletedPct()
+		}
+		if ranked[i].MedianDepletion() != ranked[j].MedianDepletion() {
+			return ranked[i].MedianDepletion() < ranked[j].MedianDepletion()
+		}
+		return ranked[i].StationID < ranked[j].StationID
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	result := make([]*ebikeStation, len(ranked))
+	for i, se := range ranked {
+		result[i] = &ebikeStation{StationEBikes: se, Station: stationMap[se.StationID]}
+	}
+	return result
+}
+
+// disabledStation is a station with disabled bikes or docks in the week
+// before the pages were built.
+type disabledStation struct {
+	*stats.StationDisabled
+	Station *gobike.Station
+}
+
+// LongestStreak describes the longest time the station had a disabled bike
+// or dock.
+func (d *disabledStation) LongestStreak() string {
+	streak := d.LongestBikeStreak()
+	if docks := d.LongestDockStreak(); streak == nil || (docks != nil && docks.Duration() > streak.Duration()) {
+		streak = docks
+	}
+	if streak == nil {
+		return ""
+	}
+	s := describeDuration(streak.Duration())
+	if streak.Ongoing {
+		s += ", ongoing"
+	}
+	return s
+}
+
+// worstDisabledStations returns up to n stations in stationMap with the most
+// disabled bikes and docks in the week before end.
+func worstDisabledStations(stationMap map[string]*gobike.Station, statuses map[string][]*gobike.StationStatus, end time.Time, n int) []*disabledStation {
+	result := make([]*disabledStation, 0, n)
+	for _, sd := range stats.StationsDisabled(statuses, end.Add(-7*24*time.Hour), end) {
+		if len(result) == n || sd.Bikes+sd.Docks == 0 {
+			break
+		}
+		result = append(result, &disabledStation{StationDisabled: sd, Station: stationMap[sd.StationID]})
+	}
+	return result
+}
+
+// inCity reports whether the station with the given ID is in city, or, if
+// city is nil, whether it's a station we know about at all.
+func inCity(city *geo.City, stationMap map[string]*gobike.Station, id string) bool {
+	station := stationMap[id]
+	if station == nil {
+		return false
+	}
+	// yuck pointer comparison
+	return city == nil || station.City == city
+}
+
+func empty(city *geo.City, stationMap map[string]*gobike.Station) func(ss *gobike.StationStatus) bool {
+	return func(ss *gobike.StationStatus) bool {
+		return inCity(city, stationMap, ss.ID) && ss.Empty()
 	}
 }
 
 func full(city *geo.City, stationMap map[string]*gobike.Station) func(ss *gobike.StationStatus) bool {
 	return func(ss *gobike.StationStatus) bool {
-		station := stationMap[ss.ID]
-		// yuck pointer comparison
-		if station == nil {
-			return false
-		}
-		if city != nil && station.City != city {
-			return false
-		}
-		if !ss.IsInstalled || !ss.IsRenting {
-			return false
+		return inCity(city, stationMap, ss.ID) && ss.Full()
+	}
+}
+
+// cityStatuses returns the statuses of the stations in city.
+func cityStatuses(city *geo.City, stationMap map[string]*gobike.Station, statuses map[string][]*gobike.StationStatus) map[string][]*gobike.StationStatus {
+	result := make(map[string][]*gobike.StationStatus)
+	for id := range statuses {
+		if inCity(city, stationMap, id) {
+			result[id] = statuses[id]
 		}
-		return ss.NumDocksAvailable == 0
 	}
+	return result
 }
 
 const stationCapacityInterval = 20 * time.Minute
 
 var printer *message.Printer
 
-func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *template.Template, stationMap map[string]*gobike.Station, trips []*gobike.Trip, statuses map[string][]*gobike.StationStatus) error {
+// renderCity writes the pages for one city to a directory in docsDir. asOf
+// is the time the pages describe; trips and statuses should not include
+// anything after it.
+func renderCity(w io.Writer, docsDir string, asOf time.Time, name string, city *geo.City, tpl, stationTpl *template.Template, stationMap map[string]*gobike.Station, trips []*gobike.Trip, statuses map[string][]*gobike.StationStatus, availability *availability, weather *stats.Weather) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	group, errctx := errgroup.WithContext(ctx)
 	_ = errctx
-	var stationsPerWeek, tripsPerWeek, bikeTripsPerWeek, tripsPerBikePerWeek, bs4aTripsPerWeek, emptyStations, fullStations, runRate, moves stats.TimeSeries
-	var stationBytes, data, bikeData, tripPerBikeData, bs4aData, emptyStationData, fullStationData, runRateData, moveData []byte
+	var stationsPerWeek, tripsPerWeek, bikeTripsPerWeek, tripsPerBikePerWeek, bs4aTripsPerWeek, emptyStations, fullStations, runRate, moves, usability, disabledBikes, disabledDocks stats.TimeSeries
+	var stationBytes, data, bikeData, tripPerBikeData, bs4aData, emptyStationData, fullStationData, runRateData, moveData, usabilityData, disabledBikeData, disabledDockData []byte
+	var disabledStations []*disabledStation
+	var ebikeShare, ebikeAvailable stats.TimeSeries
+	var ebikeShareData, ebikeAvailableData []byte
+	var ebikesByStation map[string]*stats.StationEBikes
+	var ebikeStations []*ebikeStation
+	var ebikeMornings int
+	var ebikeDepleted float64
+	var ebikeMedian time.Duration
 	var mostPopularStations, popularBS4AStations []*stats.StationCount
 	var shareOfTotalTrips, averageWeekdayTrips, estimatedTotalTrips string
+	var specialDaysLastWeek string
+	var specialDayData []byte
+	var journeysPerWeek stats.TimeSeries
+	var journeyData []byte
+	group.Go(func() error {
+		journeysPerWeek = stats.TripsPerWeek(stats.Journeys(trips))
+		var err error
+		journeyData, err = json.Marshal(journeysPerWeek)
+		return err
+	})
+	var weatherAdjustedData []byte
+	var rain *stats.WeatherBreakdown
+	if weather != nil {
+		group.Go(func() error {
+			rain = stats.RainBreakdown(stats.TripsPer(trips, stats.Day), weather)
+			var err error
+			weatherAdjustedData, err = json.Marshal(stats.WeatherAdjustedTripsPer(trips, stats.Week, weather))
+			return err
+		})
+	}
 	var tripsByDistrict [11]int
+	var comparisons []*stats.Comparison
 	if name == "sf" {
 		group.Go(func() error {
 			tripsByDistrict = stats.TripsLastWeekPerDistrict(trips)
@@ -125,7 +370,7 @@ func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *templ
 	if err != nil {
 		panic(err)
 	}
-	now := time.Now().In(tz)
+	now := asOf.In(tz)
 	nowRounded := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute()-now.Minute()%20, 0, 0, tz)
 	fmt.Fprintln(w, "collecting stats")
 	group.Go(func() error {
@@ -134,6 +379,47 @@ func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *templ
 		emptyStationData, err = json.Marshal(emptyStations)
 		return err
 	})
+	cityStatuses := cityStatuses(city, stationMap, statuses)
+	group.Go(func() error {
+		usability = stats.UsabilityPerWeek(cityStatuses, now)
+		var err error
+		usabilityData, err = json.Marshal(usability)
+		return err
+	})
+	group.Go(func() error {
+		disabledBikes, disabledDocks = stats.DisabledPerWeek(cityStatuses, now)
+		var err error
+		disabledBikeData, err = json.Marshal(disabledBikes)
+		if err != nil {
+			return err
+		}
+		disabledDockData, err = json.Marshal(disabledDocks)
+		return err
+	})
+	group.Go(func() error {
+		disabledStations = worstDisabledStations(stationMap, cityStatuses, now, 10)
+		return nil
+	})
+	group.Go(func() error {
+		ebikeShare, ebikeAvailable = stats.EBikesPerWeek(cityStatuses, now)
+		var err error
+		ebikeShareData, err = json.Marshal(ebikeShare)
+		if err != nil {
+			return err
+		}
+		ebikeAvailableData, err = json.Marshal(ebikeAvailable)
+		return err
+	})
+	group.Go(func() error {
+		stations := stats.StationsEBikes(cityStatuses, now.Add(-availability.lookback), now)
+		ebikesByStation = make(map[string]*stats.StationEBikes, len(stations))
+		for _, se := range stations {
+			ebikesByStation[se.StationID] = se
+		}
+		ebikeMornings, ebikeDepleted, ebikeMedian = stats.SummarizeEBikes(stations)
+		ebikeStations = fastestEBikeDepletion(stationMap, stations, 10)
+		return nil
+	})
 	group.Go(func() error {
 		moves = stats.MovesPerWeek(trips)
 		var err error
@@ -181,6 +467,7 @@ func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *templ
 		tripsPerWeek = stats.TripsPerWeek(trips)
 		averageWeekdayTripsf64 := stats.AverageWeekdayTrips(trips)
 		averageWeekdayTrips = fmt.Sprintf("%.1f", averageWeekdayTripsf64)
+		specialDaysLastWeek = describeSpecialDays(stats.SpecialDaysLast7Days(trips))
 		// SF has 4.46 million trips per day
 		// avg trips per person / day = 4.46 million / (SF population) * other population
 		estimatedTotalTripsf64 := float64(4.46*1000*1000) / float64(geo.Populations["sf"]) * float64(geo.Populations[name])
@@ -190,6 +477,10 @@ func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *templ
 		estimatedTotalTrips = strconv.FormatFloat(estimatedTotalTripsf64, 'f', 0, 64)
 		var err error
 		data, err = json.Marshal(tripsPerWeek)
+		if err != nil {
+			return err
+		}
+		specialDayData, err = json.Marshal(stats.Annotate(tripsPerWeek, stats.Week))
 		return err
 	})
 	group.Go(func() error {
@@ -210,6 +501,10 @@ func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *templ
 		bs4aData, err = json.Marshal(bs4aTripsPerWeek)
 		return err
 	})
+	group.Go(func() error {
+		comparisons = stats.Compare(stats.TripsPer(trips, stats.Day))
+		return nil
+	})
 	var distanceBuckets, durationBuckets *Histogram
 	group.Go(func() error {
 		distanceBucketsArr, avg := stats.DistanceBucketsLastWeek(trips, 0.50, 6)
@@ -236,51 +531,128 @@ func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *templ
 		return err
 	}
 
-	tripsPerWeekCountf64 := tripsPerWeek[len(tripsPerWeek)-1].Data
-	bs4aTripsPerWeekCountf64 := bs4aTripsPerWeek[len(bs4aTripsPerWeek)-1].Data
+	tripsPerWeekCountf64 := tripsPerWeek.Last()
+	bs4aTripsPerWeekCountf64 := bs4aTripsPerWeek.Last()
+	bs4aTripPct := "0.0"
+	if tripsPerWeekCountf64 > 0 {
+		bs4aTripPct = fmt.Sprintf("%.1f", 100*bs4aTripsPerWeekCountf64/tripsPerWeekCountf64)
+	}
+
+	var latestUsability string
+	if len(usability) > 0 {
+		latestUsability = fmt.Sprintf("%.1f", usability.Last())
+	}
+	var latestEBikeShare, latestEBikeAvailable string
+	if len(ebikeShare) > 0 {
+		latestEBikeShare = fmt.Sprintf("%.1f", ebikeShare.Last())
+		latestEBikeAvailable = fmt.Sprintf("%.1f", ebikeAvailable.Last())
+	}
+	var latestDisabledBikes, latestDisabledDocks string
+	if len(disabledBikes) > 0 {
+		latestDisabledBikes = fmt.Sprintf("%.1f", disabledBikes.Last())
+		latestDisabledDocks = fmt.Sprintf("%.1f", disabledDocks.Last())
+	}
 
 	var friendlyName string
 	if city != nil {
 		friendlyName = city.Name
 	}
+	var weatherStation string
+	var rainyWeekday, dryWeekday, rainyWeekend, dryWeekend, weekdayRain, weekendRain string
+	if rain != nil {
+		weatherStation = weather.Name
+		if weatherStation == "" {
+			weatherStation = weather.Station
+		}
+		average := func(days int, avg float64) string {
+			if days == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%.1f", avg)
+		}
+		rainyWeekday = average(rain.Weekday.RainyDays, rain.Weekday.RainyAverage())
+		dryWeekday = average(rain.Weekday.DryDays, rain.Weekday.DryAverage())
+		rainyWeekend = average(rain.Weekend.RainyDays, rain.Weekend.RainyAverage())
+		dryWeekend = average(rain.Weekend.DryDays, rain.Weekend.DryAverage())
+		weekdayRain = rain.Weekday.RainEffectString()
+		weekendRain = rain.Weekend.RainEffectString()
+	}
 	hdata := &homepageData{
 		Area:         name,
 		FriendlyName: friendlyName,
 
 		TripsPerWeek:             template.JS(string(data)),
 		TripsPerWeekCount:        int64(tripsPerWeekCountf64),
+		JourneysPerWeek:          template.JS(journeyData),
+		JourneysPerWeekCount:     int64(journeysPerWeek.Last()),
 		StationsPerWeek:          template.JS(string(stationBytes)),
-		StationsPerWeekCount:     int64(stationsPerWeek[len(stationsPerWeek)-1].Data),
+		StationsPerWeekCount:     int64(stationsPerWeek.Last()),
 		BikesPerWeek:             template.JS(string(bikeData)),
-		BikesPerWeekCount:        int64(bikeTripsPerWeek[len(bikeTripsPerWeek)-1].Data),
+		BikesPerWeekCount:        int64(bikeTripsPerWeek.Last()),
 		TripsPerBikePerWeek:      template.JS(string(tripPerBikeData)),
-		TripsPerBikePerWeekCount: fmt.Sprintf("%.1f", tripsPerBikePerWeek[len(tripsPerBikePerWeek)-1].Data),
+		TripsPerBikePerWeekCount: fmt.Sprintf("%.1f", tripsPerBikePerWeek.Last()),
 		PopularStations:          mostPopularStations,
 		PopularBS4AStations:      popularBS4AStations,
 		BS4ATripsPerWeek:         template.JS(string(bs4aData)),
 		BS4ATripsPerWeekCount:    int64(bs4aTripsPerWeekCountf64),
-		BS4ATripPct:              fmt.Sprintf("%.1f", 100*bs4aTripsPerWeekCountf64/tripsPerWeekCountf64),
+		BS4ATripPct:              bs4aTripPct,
 
 		MovesPerWeek:      template.JS(moveData),
-		MovesPerWeekCount: int64(moves[len(moves)-1].Data),
+		MovesPerWeekCount: int64(moves.Last()),
+
+		UsabilityPerWeek: template.JS(usabilityData),
+		LatestUsability:  latestUsability,
+
+		DisabledBikesPerWeek: template.JS(disabledBikeData),
+		DisabledDocksPerWeek: template.JS(disabledDockData),
+		LatestDisabledBikes:  latestDisabledBikes,
+		LatestDisabledDocks:  latestDisabledDocks,
+		DisabledStations:     disabledStations,
+
+		EBikeSharePerWeek:     template.JS(ebikeShareData),
+		EBikeAvailablePerWeek: template.JS(ebikeAvailableData),
+		LatestEBikeShare:      latestEBikeShare,
+		LatestEBikeAvailable:  latestEBikeAvailable,
+		EBikeMornings:         ebikeMornings,
+		EBikeMorningStart:     stats.EBikeMorning.Start,
+		EBikeMorningEnd:       stats.EBikeMorning.End,
+		EBikeDepletedPct:      fmt.Sprintf("%.0f", ebikeDepleted),
+		EBikeRanOut:           ebikeDepleted > 0,
+		EBikeMedianDepletion:  describeDuration(ebikeMedian),
+		EBikeLookback:         describeLookback(availability.lookback),
+		EBikeStations:         ebikeStations,
 
 		TripsByDistrict:     tripsByDistrict,
 		ShareOfTotalTrips:   shareOfTotalTrips,
 		AverageWeekdayTrips: averageWeekdayTrips,
-		DistanceBuckets:     distanceBuckets,
-		DurationBuckets:     durationBuckets,
-		Population:          geo.Populations[name],
-		EstimatedTotalTrips: estimatedTotalTrips,
+		SpecialDaysLastWeek: specialDaysLastWeek,
+		SpecialDays:         template.JS(specialDayData),
+
+		WeatherStation:              weatherStation,
+		WeatherAdjustedTripsPerWeek: template.JS(weatherAdjustedData),
+		RainyWeekdayTrips:           rainyWeekday,
+		DryWeekdayTrips:             dryWeekday,
+		RainyWeekendTrips:           rainyWeekend,
+		DryWeekendTrips:             dryWeekend,
+		WeekdayRainEffect:           weekdayRain,
+		WeekendRainEffect:           weekendRain,
+		RainyDay:                    stats.RainyDay,
+		DistanceBuckets:             distanceBuckets,
+		DurationBuckets:             durationBuckets,
+		Population:                  geo.Populations[name],
+		EstimatedTotalTrips:         estimatedTotalTrips,
 
 		EmptyStations: template.JS(string(emptyStationData)),
 		FullStations:  template.JS(string(fullStationData)),
 
+		Comparisons: comparisons,
+
 		RunRate:       template.JS(string(runRateData)),
-		LatestRunRate: printer.Sprintf("%.0f", runRate[len(runRate)-1].Data/100),
+		LatestRunRate: printer.Sprintf("%.0f", runRate.Last()/100),
 	}
-	dir := filepath.Join("docs", name)
+	dir := filepath.Join(docsDir, name)
 	if city == nil {
-		dir = "docs"
+		dir = docsDir
 	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
@@ -310,9 +682,50 @@ func renderCity(w io.Writer, name string, city *geo.City, tpl, stationTpl *templ
 	if err := ioutil.WriteFile(filepath.Join(stationDir, "index.html"), buf.Bytes(), 0644); err != nil {
 		return err
 	}
+	for _, station := range allStations {
+		id := strconv.Itoa(station.Station.ID)
+		pdata := &stationPageData{
+			Area:     name,
+			Station:  station,
+			Lookback: describeLookback(availability.lookback),
+		}
+		if a, ok := availability.byStation[id]; ok {
+			var err error
+			if pdata.Bikes, err = newHeatmap(a.BikeProbability); err != nil {
+				return err
+			}
+			if pdata.Docks, err = newHeatmap(a.DockProbability); err != nil {
+				return err
+			}
+			if pdata.EBikes, err = newHeatmap(a.EBikeProbability); err != nil {
+				return err
+			}
+		}
+		if se, ok := ebikesByStation[id]; ok {
+			pdata.EBikeMornings = describeMornings(se)
+		}
+		buf.Reset()
+		if err := stationTpl.ExecuteTemplate(buf, "station.html", pdata); err != nil {
+			return err
+		}
+		pageDir := filepath.Join(stationDir, id)
+		if err := os.MkdirAll(pageDir, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(pageDir, "index.html"), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// availability is the Availability of every station over the lookback before
+// the time the site describes.
+type availability struct {
+	lookback  time.Duration
+	byStation map[string]*stats.Availability
+}
+
 type Histogram struct {
 	interval   float64
 	isDuration bool
@@ -366,24 +779,68 @@ var cities = map[string]*geo.City{
 	"sj":         geo.SanJose,
 }
 
+// parseAsOf parses the -as-of flag: an RFC3339 time, or a date, which means
+// midnight at the start of that day in the Bay Area.
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	tz, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, tz)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse %q as a date (2006-01-02) or time (RFC3339)", s)
+	}
+	return t, nil
+}
+
 func main() {
-	// check out loadStationsFromDisk
-	// localStationFile := flag.String("local-station-file", "", "Use local station file instead of retrieving stations over HTTP")
+	asOfFlag := flag.String("as-of", "", "Build the site as it would have looked at this date or RFC3339 time (default now)")
+	stationFile := flag.String("station-information", "", "Read stations from this station_information.json file instead of over HTTP")
+	docsDir := flag.String("docs", "docs", "Directory to write the site to")
+	lookback := flag.Duration("availability-lookback", 28*24*time.Hour, "How much capacity history to use for the availability heatmaps on station pages")
+	weatherDir := flag.String("weather", "", "Directory of NOAA weather CSV files named after each city, like sf.csv, or bayarea.csv for the whole area")
+	events := flag.String("events", "", "CSV file of events like Bay to Breakers to leave out of weekday averages and mark on charts, like holidays")
 	flag.Parse()
+	if *events != "" {
+		calendar, err := stats.LoadCalendar(*events)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stats.SetCalendar(calendar)
+	}
 
+	asOf := time.Now()
+	if *asOfFlag != "" {
+		var err error
+		asOf, err = parseAsOf(*asOfFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	w := tss.NewWriter(os.Stdout, time.Time{})
 	printer = message.NewPrinter(language.English)
 	fmt.Fprintf(w, "get stations\n")
 	var stations []*gobike.Station
-	c := client.NewClient()
-	c.Stations.CacheTTL = 24 * 14 * time.Hour
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	resp, err := c.Stations.All(ctx)
-	if err != nil {
-		log.Fatal(err)
+	if *stationFile != "" {
+		var err error
+		stations, err = client.LoadStations(*stationFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		c := client.NewClient()
+		c.Stations.CacheTTL = 24 * 14 * time.Hour
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp, err := c.Stations.All(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stations = resp.Stations
 	}
-	stations = resp.Stations
 	group := errgroup.Group{}
 	var trips []*gobike.Trip
 	var statuses []*gobike.StationStatus
@@ -401,14 +858,28 @@ func main() {
 		log.Fatal(err)
 	}
 	fmt.Fprintf(w, "loaded data\n")
+	trips = stats.TripsAsOf(trips, asOf)
+	statuses = stats.StatusesAsOf(statuses, asOf)
 	if len(trips) == 0 {
-		log.Fatalf("no trips")
+		log.Fatalf("no trips before %s", asOf.Format(time.RFC3339))
+	}
+	var weather map[string]*stats.Weather
+	if *weatherDir != "" {
+		var err error
+		weather, err = stats.LoadWeatherDir(*weatherDir)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 	byStation := stats.StatusMap(statuses)
 	homepageTpl := template.Must(template.ParseFiles("templates/city.html"))
-	stationTpl := template.Must(template.ParseFiles("templates/stations.html"))
+	stationTpl := template.Must(template.ParseFiles("templates/stations.html", "templates/station.html"))
 
 	stationMap := gobike.StationMap(stations)
+	avail := &availability{
+		lookback:  *lookback,
+		byStation: stats.AvailabilityMap(byStation, asOf, *lookback),
+	}
 	tripsPerCity := make(map[string][]*gobike.Trip)
 	tripsPerCity["bayarea"] = trips
 	unknownStations := make(map[string]string)
@@ -438,7 +909,7 @@ func main() {
 	}
 	for slug, city := range cities {
 		fmt.Fprintf(w, "render %s\n", slug)
-		if err := renderCity(w, slug, city, homepageTpl, stationTpl, stationMap, tripsPerCity[slug], byStation); err != nil {
+		if err := renderCity(w, *docsDir, asOf, slug, city, homepageTpl, stationTpl, stationMap, tripsPerCity[slug], byStation, avail, weather[slug]); err != nil {
 			log.Fatalf("error building city %s: %s", slug, err)
 		}
 	}
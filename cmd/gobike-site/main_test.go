@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/stats"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+func TestParseAsOf(t *testing.T) {
+	asOf, err := parseAsOf("2018-08-27")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asOf.Format(time.RFC3339) != "2018-08-27T00:00:00-07:00" {
+		t.Errorf("bad as-of date: %v", asOf)
+	}
+	asOf, err = parseAsOf("2018-08-27T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asOf.Hour() != 12 {
+		t.Errorf("bad as-of time: %v", asOf)
+	}
+	if _, err := parseAsOf("last tuesday"); err == nil {
+		t.Error("expected an error parsing a bad date")
+	}
+}
+
+// TestGolden renders pages from fixtures as of a fixed date and compares them
+// to the files in testdata. Run "go test -update" to regenerate them after
+// changing a template or a stat.
+func TestGolden(t *testing.T) {
+	printer = message.NewPrinter(language.English)
+	stations, err := client.LoadStations(filepath.Join("..", "..", "data", "station_information.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join("..", "..", "testdata", "golden.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trips, err := gobike.Load(bufio.NewReader(f), false)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := gobike.LoadCapacityDir(filepath.Join("..", "..", "stats", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asOf, err := parseAsOf("2018-08-27")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trips = stats.TripsAsOf(trips, asOf)
+	byStation := stats.StatusMap(stats.StatusesAsOf(statuses, asOf))
+	homepageTpl := template.Must(template.ParseFiles(filepath.Join("..", "..", "templates", "city.html")))
+	stationTpl := template.Must(template.ParseFiles(filepath.Join("..", "..", "templates", "stations.html"), filepath.Join("..", "..", "templates", "station.html")))
+
+	dir, err := ioutil.TempDir("", "gobike-site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stationMap := gobike.StationMap(stations)
+	avail := &availability{
+		lookback:  7 * 24 * time.Hour,
+		byStation: stats.AvailabilityMap(byStation, asOf, 7*24*time.Hour),
+	}
+	for _, name := range []string{"bayarea", "sf"} {
+		if err := renderCity(ioutil.Discard, dir, asOf, name, cities[name], homepageTpl, stationTpl, stationMap, trips, byStation, avail, nil); err != nil {
+			t.Fatalf("error building %s: %v", name, err)
+		}
+	}
+	pages := []string{
+		"index.html",
+		filepath.Join("bayarea", "stations", "index.html"),
+		filepath.Join("sf", "index.html"),
+		filepath.Join("sf", "stations", "index.html"),
+		filepath.Join("sf", "stations", "15", "index.html"),
+	}
+	for _, page := range pages {
+		got, err := ioutil.ReadFile(filepath.Join(dir, page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join("testdata", "golden", page)
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s does not match %s; run go test -update if the change is expected", page, golden)
+		}
+	}
+}
+
+func TestEBikeMornings(t *testing.T) {
+	tpl := template.Must(template.ParseFiles(filepath.Join("..", "..", "templates", "city.html")))
+	// one depletion in 300 mornings rounds to 0%
+	data := &homepageData{
+		LatestEBikeShare:     "10",
+		EBikeMornings:        300,
+		EBikeDepletedPct:     "0",
+		EBikeMedianDepletion: "1h 5m",
+		EBikeRanOut:          true,
+		DistanceBuckets:      new(Histogram),
+		DurationBuckets:      new(Histogram),
+	}
+	buf := new(bytes.Buffer)
+	if err := tpl.ExecuteTemplate(buf, "city.html", data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "after 1h 5m on the median morning") {
+		t.Error("expected the median depletion time when stations ran out on a few mornings")
+	}
+	buf.Reset()
+	data.EBikeRanOut = false
+	if err := tpl.ExecuteTemplate(buf, "city.html", data); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "median morning") {
+		t.Error("expected no median depletion time when stations never ran out")
+	}
+}
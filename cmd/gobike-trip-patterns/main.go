@@ -0,0 +1,79 @@
+// Command gobike-trip-patterns counts the trips that aren't what they seem:
+// failed trips that end where they started within a couple of minutes, round
+// trips, and chains of trips on the same bike that were re-docked on the way,
+// usually to avoid going over the time limit. It prints one row per bucket,
+// along with the number of journeys left once failed trips are removed and
+// each chain is counted once.
+//
+//	gobike-trip-patterns data
+//	gobike-trip-patterns -granularity month data
+//
+// With -chains, it lists every chain instead:
+//
+//	gobike-trip-patterns -chains data > chains.txt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/stats"
+)
+
+func pct(n, total int) string {
+	if total == 0 {
+		return "0.0"
+	}
+	return fmt.Sprintf("%.1f", 100*float64(n)/float64(total))
+}
+
+func main() {
+	granularity := flag.String("granularity", "week", "Bucket size: hour, day, week, month or quarter")
+	chains := flag.Bool("chains", false, "List every chain of re-docked trips instead of counting them")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gobike-trip-patterns [flags] trip-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	g, err := stats.ParseGranularity(*granularity)
+	if err != nil {
+		log.Fatal(err)
+	}
+	trips, err := gobike.LoadDir(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if *chains {
+		fmt.Fprintln(w, "bike_id\tstart\tend\tminutes\tredocks\tstations")
+		for _, c := range stats.Chains(trips) {
+			stations := make([]string, 0, len(c.Trips)+1)
+			stations = append(stations, c.Trips[0].StartStationName)
+			for _, t := range c.Trips {
+				stations = append(stations, t.EndStationName)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%.0f\t%d\t%s\n", c.BikeID, c.Start().Format(time.RFC3339), c.End().Format(time.RFC3339),
+				c.End().Sub(c.Start()).Minutes(), c.Redocks(), strings.Join(stations, " -> "))
+		}
+	} else {
+		fmt.Fprintln(w, "date\ttrips\tfailed\tfailed_pct\tround\tround_pct\tchains\tchained_trips\tjourneys")
+		for _, p := range stats.TripPatternsPer(trips, g) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", p.Date.Format("2006-01-02"), p.Trips,
+				p.Failed, pct(p.Failed, p.Trips), p.Round, pct(p.Round, p.Trips),
+				p.Chains, p.ChainedTrips, p.Journeys)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+// Command gobike-validate-feed checks a GBFS station_information and
+// station_status feed for problems and prints a JSON report.
+//
+//	gobike-validate-feed -host https://gbfs.fordgobike.com/gbfs/en
+//	gobike-validate-feed -station-information data/station_information.json -station-status status.json
+//
+// It exits with status 1 if the feed has errors, and status 2 if the feeds
+// can't be retrieved.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/validate"
+)
+
+func main() {
+	host := flag.String("host", "", "GBFS base URL to check, for example "+client.Host)
+	info := flag.String("station-information", "", "File or URL for station_information.json")
+	status := flag.String("station-status", "", "File or URL for station_status.json")
+	now := flag.String("now", "", "Check timestamps against this time (RFC3339) instead of the current time. Useful for saved files")
+	text := flag.Bool("text", false, "Print one problem per line instead of a JSON report")
+	flag.Parse()
+	if *host == "" && *info == "" && *status == "" {
+		*host = client.Host
+	}
+	v := new(validate.Validator)
+	if *now != "" {
+		t, err := time.Parse(time.RFC3339, *now)
+		if err != nil {
+			log.Fatalf("could not parse -now: %v", err)
+		}
+		v.Now = t
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	report, err := v.Run(ctx, validate.Sources{Host: *host, Information: *info, Status: *status})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobike-validate-feed: %v\n", err)
+		os.Exit(2)
+	}
+	if *text {
+		for _, p := range report.Problems {
+			fmt.Println(p.String())
+		}
+		fmt.Printf("%d stations, %d statuses, %d errors, %d warnings\n", report.Stations, report.Statuses, report.Errors, report.Warnings)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
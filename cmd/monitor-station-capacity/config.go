@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/gobike/alert"
+	"github.com/kevinburke/gobike/client"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Feeds the monitor knows how to record.
+const (
+	feedStationStatus  = "station_status"
+	feedFreeBikeStatus = "free_bike_status"
+)
+
+var knownFeeds = map[string]bool{
+	feedStationStatus:  true,
+	feedFreeBikeStatus: true,
+}
+
+// Places the monitor can store statuses.
+const (
+	// Daily CSV files in the output directory.
+	storageCSV = "csv"
+	// Newline delimited JSON on stdout.
+	storageJSONL = "jsonl"
+	// A SQLite database. Requires building with -tags sqlite.
+	storageSQLite = "sqlite"
+)
+
+var knownStorage = map[string]bool{
+	storageCSV:    true,
+	storageJSONL:  true,
+	storageSQLite: true,
+}
+
+// defaultInterval is used when neither the config nor the feed specify how
+// often to poll.
+const defaultInterval = 10 * time.Second
+
+// Config configures the monitor. It's read from the "monitor" section of a
+// YAML file; see config.yml in the root of the repository for an example.
+type Config struct {
+	// Directory to write capacity files to. If there's more than one system,
+	// each system writes to a subdirectory named after the system, unless it
+	// sets its own OutputDir.
+	OutputDir string `yaml:"output_dir"`
+	// How often to poll. If zero, poll as often as the feed's TTL allows.
+	Interval time.Duration `yaml:"interval"`
+	// Feeds to record, by GBFS name. Defaults to station_status.
+	Feeds []string `yaml:"feeds"`
+	// Where to store statuses: "csv", "jsonl" or "sqlite". Defaults to csv.
+	Storage []string `yaml:"storage"`
+	// Path to the SQLite database, for sqlite storage. Defaults to
+	// capacity.db in the output directory.
+	Database string `yaml:"database"`
+	// Bike share systems to poll. Defaults to the GoBike system.
+	Systems []*SystemConfig `yaml:"systems"`
+	// If set, serve Prometheus metrics at /metrics and a health check at
+	// /healthz on this address, e.g. "localhost:9090".
+	HTTPAddr string `yaml:"http_addr"`
+	// /healthz fails if any feed a system polls hasn't been updated in this long.
+	// Defaults to five minutes.
+	StaleAfter time.Duration `yaml:"stale_after"`
+	// Rules to check on every poll, and where to send alerts. Rules apply to
+	// every system unless they name one.
+	Alerts *alert.Config `yaml:"alerts"`
+}
+
+// SystemConfig describes a single bike share system. Zero values are filled
+// in from the Config.
+type SystemConfig struct {
+	Name      string        `yaml:"name"`
+	Host      string        `yaml:"host"`
+	OutputDir string        `yaml:"output_dir"`
+	Interval  time.Duration `yaml:"interval"`
+	Feeds     []string      `yaml:"feeds"`
+	Storage   []string      `yaml:"storage"`
+	Database  string        `yaml:"database"`
+}
+
+type fileConfig struct {
+	Monitor *Config `yaml:"monitor"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		OutputDir:  filepath.Join("data", "station-capacity"),
+		Feeds:      []string{feedStationStatus},
+		Storage:    []string{storageCSV},
+		StaleAfter: defaultStaleAfter,
+	}
+}
+
+// loadConfig reads the "monitor" section of the YAML file at path.
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc := new(fileConfig)
+	if err := yaml.Unmarshal(data, fc); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	cfg := defaultConfig()
+	if fc.Monitor == nil {
+		return cfg, nil
+	}
+	if fc.Monitor.OutputDir != "" {
+		cfg.OutputDir = fc.Monitor.OutputDir
+	}
+	if len(fc.Monitor.Feeds) > 0 {
+		cfg.Feeds = fc.Monitor.Feeds
+	}
+	if len(fc.Monitor.Storage) > 0 {
+		cfg.Storage = fc.Monitor.Storage
+	}
+	cfg.Database = fc.Monitor.Database
+	cfg.Interval = fc.Monitor.Interval
+	if fc.Monitor.StaleAfter != 0 {
+		cfg.StaleAfter = fc.Monitor.StaleAfter
+	}
+	cfg.Systems = fc.Monitor.Systems
+	cfg.HTTPAddr = fc.Monitor.HTTPAddr
+	cfg.Alerts = fc.Monitor.Alerts
+	return cfg, nil
+}
+
+// parseList parses a comma separated list, like a list of feed names.
+func parseList(s string) []string {
+	parts := strings.Split(s, ",")
+	feeds := make([]string, 0, len(parts))
+	for i := range parts {
+		if feed := strings.TrimSpace(parts[i]); feed != "" {
+			feeds = append(feeds, feed)
+		}
+	}
+	return feeds
+}
+
+// overrides holds settings from the command line, which take precedence over
+// the config file. Zero values are ignored.
+type overrides struct {
+	OutputDir string
+	Interval  time.Duration
+	Feeds     string
+	Host      string
+	// System selects a single system from the config file, or names the
+	// system if the config doesn't list any.
+	System     string
+	HTTPAddr   string
+	StaleAfter time.Duration
+	Storage    string
+	Database   string
+}
+
+func (cfg *Config) override(o *overrides) error {
+	if o.System != "" && len(cfg.Systems) > 0 {
+		var selected *SystemConfig
+		for i := range cfg.Systems {
+			if cfg.Systems[i].Name == o.System {
+				selected = cfg.Systems[i]
+			}
+		}
+		if selected == nil {
+			return fmt.Errorf("system %q is not in the config file", o.System)
+		}
+		cfg.Systems = []*SystemConfig{selected}
+	}
+	if o.Host != "" || o.System != "" {
+		if len(cfg.Systems) > 1 {
+			return errors.New("cannot use -host with more than one system in the config file")
+		}
+		if len(cfg.Systems) == 0 {
+			cfg.Systems = []*SystemConfig{{}}
+		}
+		if o.Host != "" {
+			cfg.Systems[0].Host = o.Host
+		}
+		if o.System != "" {
+			cfg.Systems[0].Name = o.System
+		}
+	}
+	if o.OutputDir != "" {
+		cfg.OutputDir = o.OutputDir
+		if len(cfg.Systems) == 1 {
+			cfg.Systems[0].OutputDir = o.OutputDir
+		}
+	}
+	if o.Interval != 0 {
+		cfg.Interval = o.Interval
+		for i := range cfg.Systems {
+			cfg.Systems[i].Interval = o.Interval
+		}
+	}
+	if o.Feeds != "" {
+		cfg.Feeds = parseList(o.Feeds)
+		for i := range cfg.Systems {
+			cfg.Systems[i].Feeds = nil
+		}
+	}
+	if o.Storage != "" {
+		cfg.Storage = parseList(o.Storage)
+		for i := range cfg.Systems {
+			cfg.Systems[i].Storage = nil
+		}
+	}
+	if o.Database != "" {
+		cfg.Database = o.Database
+		if len(cfg.Systems) == 1 {
+			cfg.Systems[0].Database = o.Database
+		}
+	}
+	if o.HTTPAddr != "" {
+		cfg.HTTPAddr = o.HTTPAddr
+	}
+	if o.StaleAfter != 0 {
+		cfg.StaleAfter = o.StaleAfter
+	}
+	return nil
+}
+
+// resolve fills in each system's settings from cfg and checks that they make
+// sense.
+func (cfg *Config) resolve() ([]*SystemConfig, error) {
+	if cfg.Interval < 0 {
+		return nil, errors.New("interval must not be negative")
+	}
+	if cfg.StaleAfter <= 0 {
+		return nil, errors.New("stale_after must be positive")
+	}
+	systems := cfg.Systems
+	if len(systems) == 0 {
+		systems = []*SystemConfig{{}}
+	}
+	resolved := make([]*SystemConfig, len(systems))
+	seen := make(map[string]bool)
+	dirs := make(map[string]string)
+	for i := range systems {
+		sys := *systems[i]
+		if sys.Name == "" {
+			if len(systems) > 1 {
+				return nil, fmt.Errorf("system %d has no name", i+1)
+			}
+			sys.Name = "gobike"
+		}
+		if seen[sys.Name] {
+			return nil, fmt.Errorf("system %q is listed more than once", sys.Name)
+		}
+		seen[sys.Name] = true
+		if sys.Host == "" {
+			if len(systems) > 1 {
+				return nil, fmt.Errorf("system %q has no host", sys.Name)
+			}
+			sys.Host = client.Host
+		}
+		if sys.OutputDir == "" {
+			sys.OutputDir = cfg.OutputDir
+			if len(systems) > 1 {
+				sys.OutputDir = filepath.Join(cfg.OutputDir, sys.Name)
+			}
+		}
+		if other, ok := dirs[filepath.Clean(sys.OutputDir)]; ok {
+			return nil, fmt.Errorf("systems %q and %q both write to %s", other, sys.Name, sys.OutputDir)
+		}
+		dirs[filepath.Clean(sys.OutputDir)] = sys.Name
+		if sys.Interval == 0 {
+			sys.Interval = cfg.Interval
+		}
+		if sys.Interval < 0 {
+			return nil, fmt.Errorf("system %q: interval must not be negative", sys.Name)
+		}
+		if len(sys.Feeds) == 0 {
+			sys.Feeds = cfg.Feeds
+		}
+		if len(sys.Feeds) == 0 {
+			return nil, fmt.Errorf("system %q: no feeds to record", sys.Name)
+		}
+		for _, feed := range sys.Feeds {
+			if !knownFeeds[feed] {
+				return nil, fmt.Errorf("system %q: unknown feed %q (known feeds: %s)", sys.Name, feed, strings.Join(sortedKeys(knownFeeds), ", "))
+			}
+		}
+		if len(sys.Storage) == 0 {
+			sys.Storage = cfg.Storage
+		}
+		if len(sys.Storage) == 0 {
+			return nil, fmt.Errorf("system %q: no storage to write to", sys.Name)
+		}
+		for _, storage := range sys.Storage {
+			if !knownStorage[storage] {
+				return nil, fmt.Errorf("system %q: unknown storage %q (known storage: %s)", sys.Name, storage, strings.Join(sortedKeys(knownStorage), ", "))
+			}
+		}
+		if sys.Database == "" {
+			sys.Database = cfg.Database
+		}
+		if sys.Database == "" {
+			sys.Database = filepath.Join(sys.OutputDir, "capacity.db")
+		}
+		resolved[i] = &sys
+	}
+	return resolved, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
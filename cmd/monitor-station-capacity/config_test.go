@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike/client"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := loadConfig(filepath.Join("..", "..", "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	systems, err := cfg.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(systems) != 1 {
+		t.Fatalf("expected one system, got %d", len(systems))
+	}
+	sys := systems[0]
+	if sys.Name != "gobike" || sys.Host != client.Host {
+		t.Errorf("bad system: %#v", sys)
+	}
+	if want := filepath.Join("data", "station-capacity"); sys.OutputDir != want {
+		t.Errorf("expected output dir %q, got %q", want, sys.OutputDir)
+	}
+	if sys.Interval != 0 {
+		t.Errorf("expected interval to default to the feed TTL, got %v", sys.Interval)
+	}
+}
+
+func TestResolveMultipleSystems(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Interval = 30 * time.Second
+	cfg.Systems = []*SystemConfig{
+		{Name: "baywheels", Host: "https://gbfs.baywheels.com/gbfs/en"},
+		{Name: "citibike", Host: "https://gbfs.citibikenyc.com/gbfs/en", Interval: time.Minute},
+	}
+	systems, err := cfg.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("data", "station-capacity", "citibike"); systems[1].OutputDir != want {
+		t.Errorf("expected output dir %q, got %q", want, systems[1].OutputDir)
+	}
+	if systems[0].Interval != 30*time.Second || systems[1].Interval != time.Minute {
+		t.Errorf("bad intervals: %v, %v", systems[0].Interval, systems[1].Interval)
+	}
+	if err := cfg.override(&overrides{Host: "http://localhost"}); err == nil {
+		t.Errorf("expected error setting -host with two systems")
+	}
+	if err := cfg.override(&overrides{System: "citibike", OutputDir: "/tmp/citibike", Interval: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	systems, err = cfg.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(systems) != 1 || systems[0].OutputDir != "/tmp/citibike" || systems[0].Interval != time.Second {
+		t.Errorf("bad override: %#v", systems[0])
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		systems []*SystemConfig
+		feeds   []string
+		err     string
+	}{
+		{[]*SystemConfig{{Name: "a", Host: "h"}, {Host: "h"}}, nil, "has no name"},
+		{[]*SystemConfig{{Name: "a", Host: "h"}, {Name: "a", Host: "h"}}, nil, "more than once"},
+		{[]*SystemConfig{{Name: "a", Host: "h", OutputDir: "x"}, {Name: "b", Host: "h", OutputDir: "x/"}}, nil, "both write to"},
+		{nil, []string{"vehicle_types"}, "unknown feed"},
+		{[]*SystemConfig{{Storage: []string{"parquet"}}}, nil, "unknown storage"},
+	}
+	for _, tt := range tests {
+		cfg := defaultConfig()
+		cfg.Systems = tt.systems
+		if tt.feeds != nil {
+			cfg.Feeds = tt.feeds
+		}
+		_, err := cfg.resolve()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("expected error containing %q, got %v", tt.err, err)
+		}
+	}
+}
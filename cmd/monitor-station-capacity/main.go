@@ -1,21 +1,23 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/alert"
 	"github.com/kevinburke/gobike/client"
 	"github.com/kevinburke/rest"
+	"golang.org/x/sync/errgroup"
 )
 
 func writeStation(buf *bytes.Buffer, station *gobike.StationStatus) {
@@ -51,112 +53,445 @@ func writeStation(buf *bytes.Buffer, station *gobike.StationStatus) {
 	buf.WriteByte('\n')
 }
 
+// writeRetries is the number of times we try to write buffered rows to a file
+// before giving up until the next poll.
+const writeRetries = 3
+
+// maxWriteFailures is the number of consecutive polls that can fail to write
+// their rows before the monitor exits. Rows stay buffered in memory until
+// then.
+const maxWriteFailures = 30
+
+// stationsRefresh is how often to reload station information for alert
+// rules. Stations don't change often.
+const stationsRefresh = time.Hour
+
+// monitor polls the station status and free bike feeds and hands new rows to
+// its sinks.
+type monitor struct {
+	name   string
+	client *client.Client
+	// How often to poll. If zero, use the TTL from the last response.
+	interval time.Duration
+	ttl      time.Duration
+
+	// feeds to record, by GBFS name
+	feeds        map[string]bool
+	sinks        []sink
+	lastReported map[string]time.Time
+	// lastBikes is the last snapshot we stored for each free bike in the
+	// feed.
+	lastBikes map[string]*gobike.FreeBike
+
+	count         int
+	logMessage    bool
+	writeFailures int
+
+	metrics *systemMetrics
+	// alerts is nil if there are no alert rules for this system.
+	alerts         *alert.Engine
+	stationsLoaded time.Time
+}
+
+// newMonitor returns a monitor that writes to sinks. Statuses the sinks
+// already have aren't written again.
+func newMonitor(c *client.Client, now time.Time, sinks ...sink) (*monitor, error) {
+	var lastReported map[string]time.Time
+	for _, s := range sinks {
+		r, ok := s.(resumer)
+		if !ok {
+			continue
+		}
+		reported, err := r.LastReported()
+		if err != nil {
+			return nil, fmt.Errorf("could not read statuses from %s storage: %v", s.Name(), err)
+		}
+		if lastReported == nil {
+			lastReported = reported
+			continue
+		}
+		// If sinks disagree, write a status again rather than skip it.
+		for id, t := range lastReported {
+			if other, ok := reported[id]; !ok {
+				delete(lastReported, id)
+			} else if other.Before(t) {
+				lastReported[id] = other
+			}
+		}
+	}
+	if lastReported == nil {
+		lastReported = make(map[string]time.Time)
+	}
+	lastBikes := make(map[string]*gobike.FreeBike)
+	for _, s := range sinks {
+		if r, ok := s.(bikeResumer); ok {
+			bikes, err := r.LastBikes()
+			if err != nil {
+				return nil, fmt.Errorf("could not read free bikes from %s storage: %v", s.Name(), err)
+			}
+			for id, b := range bikes {
+				lastBikes[id] = b
+			}
+		}
+	}
+	return &monitor{
+		client:       c,
+		feeds:        map[string]bool{feedStationStatus: true},
+		sinks:        sinks,
+		lastReported: lastReported,
+		lastBikes:    lastBikes,
+		metrics:      newSystemMetrics(now),
+	}, nil
+}
+
+// write hands statuses to every sink, even if one of them fails, and returns
+// the first error.
+func (m *monitor) write(statuses []*gobike.StationStatus) error {
+	var firstErr error
+	for _, s := range m.sinks {
+		before := s.Pending()
+		err := s.Write(statuses)
+		m.metrics.addRows(s.Name(), before+len(statuses)-s.Pending())
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s storage: %v", s.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+// writeBikes hands free bikes to every sink that can store them.
+func (m *monitor) writeBikes(bikes []*gobike.FreeBike) error {
+	var firstErr error
+	for _, s := range m.sinks {
+		bs, ok := s.(bikeSink)
+		if !ok {
+			continue
+		}
+		if err := bs.WriteBikes(bikes); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s storage: %v", s.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+// pending returns the number of statuses that haven't been stored by every
+// sink.
+func (m *monitor) pending() int {
+	n := 0
+	for _, s := range m.sinks {
+		if p := s.Pending(); p > n {
+			n = p
+		}
+	}
+	return n
+}
+
+// poll fetches each feed and stores anything new. It only returns an error if
+// rows could not be stored.
+func (m *monitor) poll(ctx context.Context) error {
+	var err error
+	if m.feeds[feedStationStatus] {
+		err = m.pollStations(ctx)
+	}
+	if m.feeds[feedFreeBikeStatus] {
+		if bikeErr := m.pollBikes(ctx); err == nil {
+			err = bikeErr
+		}
+	}
+	return err
+}
+
+// pollStations fetches the latest station statuses and stores new ones.
+func (m *monitor) pollStations(ctx context.Context) error {
+	start := time.Now()
+	response, err := m.client.Stations.Status(ctx)
+	if ctx.Err() == nil {
+		m.metrics.observePoll(time.Since(start), err)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("%s: error fetching status: %v\n", m.name, err)
+		}
+		return nil
+	}
+	m.ttl = time.Duration(response.TTL) * time.Second
+	var station *gobike.StationStatus
+	var fullStations, emptyStations int
+	batch := make([]*gobike.StationStatus, 0, len(response.Stations))
+	for i := 0; i < len(response.Stations); i++ {
+		station = response.Stations[i]
+		if station.Full() {
+			fullStations++
+		}
+		if station.Empty() {
+			emptyStations++
+		}
+		if station.LastReported.Equal(m.lastReported[station.ID]) || station.LastReported.Before(m.lastReported[station.ID]) {
+			continue
+		}
+		batch = append(batch, station)
+		m.lastReported[station.ID] = station.LastReported
+		m.count++
+		if m.count%5000 == 0 {
+			m.logMessage = true
+		}
+	}
+	m.metrics.observeFeed(response.LastUpdated, len(response.Stations), emptyStations, fullStations)
+	if m.alerts != nil {
+		m.checkAlerts(ctx, response)
+	}
+	if m.logMessage {
+		rest.Logger.Info("Processing", "system", m.name, "rows", m.count, "full_stations", fullStations, "empty_stations", emptyStations)
+		m.logMessage = false
+	}
+	return m.write(batch)
+}
+
+// pollBikes fetches the free bikes and stores the ones that are new or have
+// moved since we last saw them, and a gone record for each one that dropped
+// out of the feed.
+func (m *monitor) pollBikes(ctx context.Context) error {
+	response, err := m.client.FreeBikes.Status(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		m.metrics.observeFreeBikes(time.Time{}, 0, err)
+		log.Printf("%s: error fetching free bikes: %v\n", m.name, err)
+		return nil
+	}
+	m.metrics.observeFreeBikes(response.LastUpdated, len(response.Bikes), nil)
+	if !m.feeds[feedStationStatus] {
+		m.ttl = time.Duration(response.TTL) * time.Second
+	}
+	batch := make([]*gobike.FreeBike, 0)
+	seen := make(map[string]bool, len(response.Bikes))
+	for _, b := range response.Bikes {
+		seen[b.ID] = true
+		if prev, ok := m.lastBikes[b.ID]; ok && !b.Moved(prev) {
+			continue
+		}
+		batch = append(batch, b)
+		m.lastBikes[b.ID] = b
+	}
+	goneAt := response.LastUpdated
+	if goneAt.IsZero() {
+		goneAt = time.Now()
+	}
+	for id, prev := range m.lastBikes {
+		if seen[id] {
+			continue
+		}
+		gone := *prev
+		gone.Gone = true
+		gone.LastReported = goneAt
+		batch = append(batch, &gone)
+		delete(m.lastBikes, id)
+	}
+	return m.writeBikes(batch)
+}
+
+// checkAlerts runs the alert rules against response. Alerts are delivered in
+// the background. Failing to load stations or to notify a sink is logged, but
+// doesn't stop the monitor.
+func (m *monitor) checkAlerts(ctx context.Context, response *client.StationStatusResponse) {
+	if m.alerts.NeedsStations() && time.Since(m.stationsLoaded) > stationsRefresh {
+		stations, err := m.client.Stations.All(ctx)
+		if err != nil {
+			rest.Logger.Error("could not load stations for alerts", "system", m.name, "err", err)
+		} else {
+			m.alerts.SetStations(stations.Stations)
+			m.stationsLoaded = time.Now()
+		}
+	}
+	now := response.LastUpdated
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if err := m.alerts.Observe(ctx, now, response.Stations); err != nil && ctx.Err() == nil {
+		rest.Logger.Error("could not send alert", "system", m.name, "err", err)
+	}
+}
+
+// nextPoll returns how long to wait before polling again.
+func (m *monitor) nextPoll() time.Duration {
+	if m.interval > 0 {
+		return m.interval
+	}
+	if m.ttl > 0 {
+		return m.ttl
+	}
+	return defaultInterval
+}
+
+// run polls until ctx is canceled, or until writes have failed too many times
+// in a row.
+func (m *monitor) run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		if err := m.poll(ctx); err != nil {
+			m.writeFailures++
+			m.metrics.writeError()
+			rest.Logger.Error("could not write statuses", "system", m.name, "err", err, "pending_rows", m.pending(), "failures", m.writeFailures)
+			if m.writeFailures >= maxWriteFailures {
+				return fmt.Errorf("%s: giving up after %d consecutive write failures: %v", m.name, m.writeFailures, err)
+			}
+		} else {
+			m.writeFailures = 0
+		}
+		timer.Reset(m.nextPoll())
+	}
+}
+
+// Close delivers any queued alerts and closes every sink, which stores any
+// pending rows.
+func (m *monitor) Close() error {
+	if m.alerts != nil {
+		m.alerts.Close()
+	}
+	var err error
+	for _, s := range m.sinks {
+		before := s.Pending()
+		closeErr := s.Close()
+		m.metrics.addRows(s.Name(), before-s.Pending())
+		if closeErr != nil && err == nil {
+			err = fmt.Errorf("%s storage: %v", s.Name(), closeErr)
+		}
+	}
+	return err
+}
+
 func main() {
+	cfgPath := flag.String("config", "", "Load configuration from the \"monitor\" section of this YAML file")
+	dir := flag.String("dir", "", "Directory to write capacity files to (default "+defaultConfig().OutputDir+")")
+	interval := flag.Duration("interval", 0, "How often to poll (default: the TTL reported by the feed)")
+	feeds := flag.String("feeds", "", "Comma separated list of feeds to record: "+feedStationStatus+", "+feedFreeBikeStatus+" (default "+feedStationStatus+")")
+	host := flag.String("host", "", "GBFS base URL of the system to poll (default "+client.Host+")")
+	system := flag.String("system", "", "Name of the system to poll, for logging. With -config, only poll this system")
+	storage := flag.String("storage", "", "Comma separated list of places to store statuses: csv, jsonl (stdout) or sqlite (default "+storageCSV+")")
+	db := flag.String("db", "", "Path to the SQLite database, for sqlite storage (default capacity.db in the output directory)")
+	httpAddr := flag.String("http", "", "Serve /metrics and /healthz on this address (e.g. localhost:9090)")
+	staleAfter := flag.Duration("stale-after", 0, "Fail /healthz if a feed hasn't updated in this long (default "+defaultStaleAfter.String()+")")
 	version := flag.Bool("version", false, "Print the version string")
 	flag.Parse()
 	if *version {
-		fmt.Fprintf(os.Stderr, "monitor-station-capacity version %s\n", gobike.Version)
-		os.Exit(1)
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	dir := filepath.Join("data", "station-capacity")
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		log.Fatal(err)
+		fmt.Printf("monitor-station-capacity version %s\n", gobike.Version)
+		os.Exit(0)
 	}
-	now := time.Now().UTC()
-	lockfile, err := os.Create(filepath.Join(dir, "capacity.lock"))
-	if err != nil {
-		log.Fatal(err)
+	cfg := defaultConfig()
+	if *cfgPath != "" {
+		var err error
+		cfg, err = loadConfig(*cfgPath)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	if err := lock(lockfile); err != nil {
+	if err := cfg.override(&overrides{
+		OutputDir:  *dir,
+		Interval:   *interval,
+		Feeds:      *feeds,
+		Host:       *host,
+		System:     *system,
+		HTTPAddr:   *httpAddr,
+		StaleAfter: *staleAfter,
+		Storage:    *storage,
+		Database:   *db,
+	}); err != nil {
 		log.Fatal(err)
 	}
-	prefix := now.Format("2006-01-02")
-	filename := prefix + "-capacity.csv"
-	f, err := os.OpenFile(filepath.Join(dir, filename), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	systems, err := cfg.resolve()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
-		unlock(lockfile)
-		lockfile.Close()
-		f.Sync()
-		f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		rest.Logger.Info("shutting down", "signal", sig)
+		cancel()
 	}()
-	lastReported := make(map[string]time.Time)
-	f.Seek(0, io.SeekStart)
-	gobike.ForeachStationStatus(bufio.NewReader(f), func(stationStatus *gobike.StationStatus) error {
-		if stationStatus.LastReported.Equal(lastReported[stationStatus.ID]) || stationStatus.LastReported.Before(lastReported[stationStatus.ID]) {
-			return nil
+
+	now := time.Now().UTC()
+	reg := newRegistry()
+	reg.StaleAfter = cfg.StaleAfter
+	monitors := make([]*monitor, 0, len(systems))
+	closeAll := func() bool {
+		ok := true
+		for _, m := range monitors {
+			if err := m.Close(); err != nil {
+				rest.Logger.Error("could not close storage", "system", m.name, "err", err, "lost_rows", m.pending())
+				ok = false
+			}
 		}
-		lastReported[stationStatus.ID] = stationStatus.LastReported
-		return nil
-	})
-	ticker := time.NewTicker(10 * time.Second)
-	buf := new(bytes.Buffer)
-	client := client.NewClient()
-	count := 0
-	logMessage := false
-
-	rest.Logger.Info("started", "version", gobike.Version, "filename", filename)
-	for range ticker.C {
-		response, err := client.Stations.Status(ctx)
+		return ok
+	}
+	for _, sys := range systems {
+		metrics := reg.system(sys.Name)
+		sinks, err := openSinks(sys, now, metrics)
 		if err != nil {
-			log.Printf("error fetching status: %v\n", err)
-			continue
+			closeAll()
+			log.Fatal(err)
 		}
-		var station *gobike.StationStatus
-		var fullStations, emptyStations int
-		for i := 0; i < len(response.Stations); i++ {
-			station = response.Stations[i]
-			if station.NumDocksAvailable == 0 {
-				fullStations++
-			}
-			if station.NumBikesAvailable == 0 {
-				emptyStations++
-			}
-			if station.LastReported.Equal(lastReported[station.ID]) || station.LastReported.Before(lastReported[station.ID]) {
-				continue
-			}
-			if station.LastReported.After(now) && station.LastReported.Format("2006-01-02") > prefix {
-				// write buf to file
-				if _, err := f.Write(buf.Bytes()); err != nil {
-					log.Fatal(err)
-				}
-				if err := f.Sync(); err != nil {
-					log.Fatal(err)
-				}
-				if err := f.Close(); err != nil {
-					log.Fatal(err)
-				}
-				buf.Reset()
-				oldName := f.Name()
-				filename = station.LastReported.Format("2006-01-02") + "-capacity.csv"
-				f, err = os.Create(filepath.Join(dir, filename))
-				if err != nil {
-					log.Fatal(err)
-				}
-				prefix = station.LastReported.Format("2006-01-02")
-				rest.Logger.Info("rotate file", "old", oldName, "new", filename)
-			}
-			writeStation(buf, station)
-			lastReported[station.ID] = station.LastReported
-			count++
-			if count%5000 == 0 {
-				logMessage = true
+		m, err := newMonitor(client.NewClientWithHost(sys.Host), now, sinks...)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
 			}
+			closeAll()
+			log.Fatal(err)
 		}
-		if logMessage {
-			rest.Logger.Info("Processing", "rows", count, "full_stations", fullStations, "empty_stations", emptyStations)
-			logMessage = false
-		}
-		if buf.Len() == 0 {
-			continue
+		m.name = sys.Name
+		m.interval = sys.Interval
+		m.feeds = make(map[string]bool, len(sys.Feeds))
+		for _, feed := range sys.Feeds {
+			m.feeds[feed] = true
 		}
-		if _, err := f.Write(buf.Bytes()); err != nil {
-			log.Fatal(err)
+		m.metrics = metrics
+		metrics.setFeeds(m.feeds)
+		monitors = append(monitors, m)
+		if cfg.Alerts != nil {
+			engine, err := alert.NewEngine(m.name, cfg.Alerts)
+			if err != nil {
+				closeAll()
+				log.Fatal(err)
+			}
+			if engine.Rules() > 0 {
+				engine.Start()
+				m.alerts = engine
+			}
 		}
-		buf.Reset()
+		rest.Logger.Info("started", "version", gobike.Version, "system", m.name, "host", sys.Host, "storage", strings.Join(sys.Storage, ","), "dir", sys.OutputDir)
+	}
+	group, errctx := errgroup.WithContext(ctx)
+	for _, m := range monitors {
+		m := m
+		group.Go(func() error {
+			return m.run(errctx)
+		})
+	}
+	if cfg.HTTPAddr != "" {
+		group.Go(func() error {
+			return reg.serve(errctx, cfg.HTTPAddr)
+		})
+	}
+	runErr := group.Wait()
+	if !closeAll() {
+		os.Exit(1)
+	}
+	if runErr != nil {
+		rest.Logger.Error("exiting", "err", runErr)
+		os.Exit(1)
+	}
+	for _, m := range monitors {
+		rest.Logger.Info("stopped", "system", m.name, "rows", m.count)
 	}
 }
@@ -1,6 +1,21 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/alert"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/gbfstest"
+)
 
 func TestPrefixAfter(t *testing.T) {
 	prefix := "2018-09-01"
@@ -17,3 +32,197 @@ func TestPrefixAfter(t *testing.T) {
 		t.Errorf("2019-01-01 should be greater than 09-01, wasn't")
 	}
 }
+
+// testMonitor returns a monitor that writes to a temporary directory and polls
+// a replay of the gbfstest fixtures. Call the returned func to clean up.
+func testMonitor(t *testing.T) (*monitor, *gbfstest.Server, func()) {
+	t.Helper()
+	s, err := gbfstest.NewServerFromDir(filepath.Join("..", "..", "gbfstest", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "monitor-station-capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+	csv, err := newCSVSink(dir, s.Now().UTC())
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	m, err := newMonitor(client.NewClientWithHost(s.URL), s.Now().UTC(), csv)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return m, s, cleanup
+}
+
+func TestMonitorWritesOnShutdown(t *testing.T) {
+	m, s, cleanup := testMonitor(t)
+	defer cleanup()
+	s.Step = time.Second
+	m.interval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.run(ctx)
+	}()
+	start := s.Now()
+	for s.Now().Sub(start) < 6*time.Minute {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(m.sinks[0].(*csvSink).dir, "2018-08-26-capacity.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	statuses, err := gobike.LoadCapacity(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the replay advances one second per poll, so the monitor sees every status
+	if len(statuses) != 14 {
+		t.Errorf("expected 14 statuses, got %d", len(statuses))
+	}
+}
+
+func TestMonitorKeepsRowsOnWriteFailure(t *testing.T) {
+	m, _, cleanup := testMonitor(t)
+	defer cleanup()
+	csv := m.sinks[0].(*csvSink)
+	f := csv.capacity.f
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.poll(context.Background()); err == nil {
+		t.Fatal("expected poll to fail writing to a closed file")
+	}
+	if m.pending() == 0 {
+		t.Fatal("expected failed rows to stay buffered")
+	}
+	buffered := csv.capacity.buf.Len()
+	csv.capacity.f, _ = os.OpenFile(f.Name(), os.O_APPEND|os.O_RDWR, 0644)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != buffered {
+		t.Errorf("expected %d bytes to be written on close, got %d", buffered, len(data))
+	}
+}
+
+func TestMonitorAlerts(t *testing.T) {
+	m, s, cleanup := testMonitor(t)
+	defer cleanup()
+	var events []map[string]interface{}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events = append(events, ev)
+	}))
+	defer hook.Close()
+	engine, err := alert.NewEngine("gobike", &alert.Config{
+		Rules: []*alert.Rule{{Name: "full", Condition: alert.Full, Stations: []string{"6"}}},
+		Sinks: []*alert.SinkConfig{{Name: "hook", Type: alert.SinkWebhook, URL: hook.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Start()
+	m.alerts = engine
+	// station 6 runs out of docks at 00:04:29
+	s.Advance(10 * time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := m.poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// wait for the alerts to be delivered
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one alert, got %d", len(events))
+	}
+	if events[0]["station_id"] != "6" || events[0]["status"] != alert.Firing || events[0]["station_name"] == "" {
+		t.Errorf("bad alert: %v", events[0])
+	}
+}
+
+func TestMonitorFreeBikes(t *testing.T) {
+	m, s, cleanup := testMonitor(t)
+	defer cleanup()
+	m.feeds = map[string]bool{feedFreeBikeStatus: true}
+	now := s.Now()
+	bikes := []*gobike.FreeBike{
+		{ID: "b1", Latitude: 37.77, Longitude: -122.42, LastReported: now},
+		{ID: "b2", Latitude: 37.78, Longitude: -122.41, LastReported: now},
+	}
+	s.SetFreeBikes(bikes...)
+	if err := m.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// b2 hasn't moved, so only b1 is stored again
+	moved := *bikes[0]
+	moved.Latitude = 37.79
+	moved.LastReported = now.Add(time.Minute)
+	s.SetFreeBikes(&moved, bikes[1])
+	if err := m.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.metrics.freeBikes != 2 {
+		t.Errorf("expected 2 free bikes in metrics, got %d", m.metrics.freeBikes)
+	}
+	// b2 is rented, then parked again in the same place
+	s.Advance(time.Minute)
+	s.SetFreeBikes(&moved)
+	if err := m.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.lastBikes["b2"]; ok {
+		t.Error("expected b2 to be forgotten once it left the feed")
+	}
+	s.Advance(time.Minute)
+	back := *bikes[1]
+	back.LastReported = s.Now()
+	s.SetFreeBikes(&moved, &back)
+	if err := m.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := gobike.LoadFreeBikesDir(m.sinks[0].(*csvSink).dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 5 {
+		t.Fatalf("expected 5 snapshots, got %d", len(stored))
+	}
+	if b := stored[2]; b.ID != "b1" || b.Latitude != 37.79 || b.Gone {
+		t.Errorf("bad moved snapshot: %+v", b)
+	}
+	if b := stored[3]; b.ID != "b2" || !b.Gone || b.Latitude != 37.78 {
+		t.Errorf("bad gone snapshot: %+v", b)
+	}
+	if b := stored[4]; b.ID != "b2" || b.Gone {
+		t.Errorf("expected b2 to be stored again when it came back, got %+v", b)
+	}
+}
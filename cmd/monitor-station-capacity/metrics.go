@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/handlers"
+	"github.com/kevinburke/rest"
+)
+
+// defaultStaleAfter is how old the feed can get before /healthz starts
+// failing.
+const defaultStaleAfter = 5 * time.Minute
+
+// pollBuckets are the upper bounds of the poll latency histogram, in seconds.
+var pollBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// systemMetrics tracks what a single monitor has been doing. Methods are safe
+// to call concurrently with registry.WriteTo.
+type systemMetrics struct {
+	mu sync.Mutex
+
+	started time.Time
+
+	polls       int64
+	pollErrors  int64
+	writeErrors int64
+	rotations   int64
+	// rowsWritten is keyed by storage type.
+	rowsWritten map[string]int64
+	// pollCounts[i] is the number of polls that took at most pollBuckets[i].
+	pollCounts  []int64
+	pollSeconds float64
+
+	stations      int
+	emptyStations int
+	fullStations  int
+	freeBikes     int
+	// freeBikeErrors counts failures to fetch free_bike_status.
+	freeBikeErrors int64
+	// lastUpdated is the last_updated time of the most recent station status
+	// response, and bikesUpdated the same for free bike status.
+	lastUpdated  time.Time
+	bikesUpdated time.Time
+	// feeds are the feeds being polled, by GBFS name. The system is only as
+	// fresh as the stalest of them.
+	feeds map[string]bool
+}
+
+func newSystemMetrics(started time.Time) *systemMetrics {
+	return &systemMetrics{
+		started:     started,
+		pollCounts:  make([]int64, len(pollBuckets)),
+		rowsWritten: make(map[string]int64),
+		feeds:       map[string]bool{feedStationStatus: true},
+	}
+}
+
+// setFeeds sets the feeds whose age counts toward the health check.
+func (s *systemMetrics) setFeeds(feeds map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feeds = feeds
+}
+
+func (s *systemMetrics) observePoll(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.polls++
+	if err != nil {
+		s.pollErrors++
+	}
+	secs := d.Seconds()
+	s.pollSeconds += secs
+	for i := range pollBuckets {
+		if secs <= pollBuckets[i] {
+			s.pollCounts[i]++
+		}
+	}
+}
+
+func (s *systemMetrics) observeFeed(lastUpdated time.Time, stations, empty, full int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUpdated = lastUpdated
+	s.stations = stations
+	s.emptyStations = empty
+	s.fullStations = full
+}
+
+func (s *systemMetrics) observeFreeBikes(lastUpdated time.Time, n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.freeBikeErrors++
+		return
+	}
+	s.freeBikes = n
+	s.bikesUpdated = lastUpdated
+}
+
+func (s *systemMetrics) addRows(storage string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rowsWritten[storage] += int64(n)
+}
+
+func (s *systemMetrics) writeError() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeErrors++
+}
+
+func (s *systemMetrics) rotated() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotations++
+}
+
+// feedAge returns how long ago lastUpdated was. If we haven't seen a response
+// yet, it's the time since the monitor started. s.mu must be held.
+func (s *systemMetrics) feedAge(lastUpdated, now time.Time) time.Duration {
+	if lastUpdated.IsZero() {
+		return now.Sub(s.started)
+	}
+	return now.Sub(lastUpdated)
+}
+
+// age returns how stale the stalest feed being polled is.
+func (s *systemMetrics) age(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var age time.Duration
+	if s.feeds[feedStationStatus] {
+		age = s.feedAge(s.lastUpdated, now)
+	}
+	if s.feeds[feedFreeBikeStatus] {
+		if bikeAge := s.feedAge(s.bikesUpdated, now); bikeAge > age {
+			age = bikeAge
+		}
+	}
+	return age
+}
+
+// registry holds the metrics for every system, and serves them over HTTP.
+type registry struct {
+	// StaleAfter is how old a feed can be before /healthz fails.
+	StaleAfter time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	systems map[string]*systemMetrics
+}
+
+func newRegistry() *registry {
+	return &registry{
+		StaleAfter: defaultStaleAfter,
+		Now:        time.Now,
+		systems:    make(map[string]*systemMetrics),
+	}
+}
+
+// system returns the metrics for the named system, creating them if
+// necessary.
+func (r *registry) system(name string) *systemMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.systems[name]; ok {
+		return s
+	}
+	s := newSystemMetrics(r.Now())
+	r.systems[name] = s
+	return s
+}
+
+func (r *registry) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.systems))
+	for name := range r.systems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type sample struct {
+	name  string
+	help  string
+	typ   string
+	value func(s *systemMetrics, now time.Time) float64
+}
+
+var samples = []sample{
+	{"gobike_monitor_poll_errors_total", "Number of polls that failed to fetch the station status feed.", "counter", func(s *systemMetrics, now time.Time) float64 { return float64(s.pollErrors) }},
+	{"gobike_monitor_write_errors_total", "Number of polls that failed to store rows.", "counter", func(s *systemMetrics, now time.Time) float64 { return float64(s.writeErrors) }},
+	{"gobike_monitor_file_rotations_total", "Number of times the monitor started writing to a new daily file.", "counter", func(s *systemMetrics, now time.Time) float64 { return float64(s.rotations) }},
+	{"gobike_monitor_stations", "Number of stations in the most recent response.", "gauge", func(s *systemMetrics, now time.Time) float64 { return float64(s.stations) }},
+	{"gobike_monitor_empty_stations", "Number of stations open for rentals with no bikes in the most recent response.", "gauge", func(s *systemMetrics, now time.Time) float64 { return float64(s.emptyStations) }},
+	{"gobike_monitor_full_stations", "Number of stations open for returns with no docks in the most recent response.", "gauge", func(s *systemMetrics, now time.Time) float64 { return float64(s.fullStations) }},
+	{"gobike_monitor_free_bike_errors_total", "Number of polls that failed to fetch the free bike status feed.", "counter", func(s *systemMetrics, now time.Time) float64 { return float64(s.freeBikeErrors) }},
+	{"gobike_monitor_free_bikes", "Number of free bikes in the most recent free bike status response.", "gauge", func(s *systemMetrics, now time.Time) float64 { return float64(s.freeBikes) }},
+	{"gobike_monitor_feed_age_seconds", "Seconds since the last_updated time of the most recent station status response.", "gauge", func(s *systemMetrics, now time.Time) float64 {
+		return s.feedAge(s.lastUpdated, now).Seconds()
+	}},
+	{"gobike_monitor_free_bike_feed_age_seconds", "Seconds since the last_updated time of the most recent free bike status response.", "gauge", func(s *systemMetrics, now time.Time) float64 {
+		return s.feedAge(s.bikesUpdated, now).Seconds()
+	}},
+}
+
+// WriteTo writes every metric to w in the Prometheus text format.
+func (r *registry) WriteTo(w io.Writer) (int64, error) {
+	now := r.Now()
+	cw := &countingWriter{w: w}
+	names := r.names()
+	systems := make([]*systemMetrics, len(names))
+	for i := range names {
+		systems[i] = r.system(names[i])
+	}
+	const histogram = "gobike_monitor_poll_duration_seconds"
+	fmt.Fprintf(cw, "# HELP %s Time spent fetching the station status feed.\n# TYPE %s histogram\n", histogram, histogram)
+	for i, s := range systems {
+		label := `system="` + labelEscaper.Replace(names[i]) + `"`
+		s.mu.Lock()
+		for j := range pollBuckets {
+			fmt.Fprintf(cw, "%s_bucket{%s,le=\"%s\"} %d\n", histogram, label, formatFloat(pollBuckets[j]), s.pollCounts[j])
+		}
+		fmt.Fprintf(cw, "%s_bucket{%s,le=\"+Inf\"} %d\n", histogram, label, s.polls)
+		fmt.Fprintf(cw, "%s_sum{%s} %s\n", histogram, label, formatFloat(s.pollSeconds))
+		fmt.Fprintf(cw, "%s_count{%s} %d\n", histogram, label, s.polls)
+		s.mu.Unlock()
+	}
+	const rows = "gobike_monitor_rows_written_total"
+	fmt.Fprintf(cw, "# HELP %s Number of station statuses stored, by storage type.\n# TYPE %s counter\n", rows, rows)
+	for i, s := range systems {
+		s.mu.Lock()
+		storage := make([]string, 0, len(s.rowsWritten))
+		for name := range s.rowsWritten {
+			storage = append(storage, name)
+		}
+		sort.Strings(storage)
+		for _, name := range storage {
+			fmt.Fprintf(cw, "%s{system=\"%s\",storage=\"%s\"} %d\n", rows, labelEscaper.Replace(names[i]), name, s.rowsWritten[name])
+		}
+		s.mu.Unlock()
+	}
+	for _, smp := range samples {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", smp.name, smp.help, smp.name, smp.typ)
+		for i, s := range systems {
+			s.mu.Lock()
+			val := smp.value(s, now)
+			s.mu.Unlock()
+			fmt.Fprintf(cw, "%s{system=\"%s\"} %s\n", smp.name, labelEscaper.Replace(names[i]), formatFloat(val))
+		}
+	}
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+type systemHealth struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	AgeSeconds float64 `json:"feed_age_seconds"`
+}
+
+type health struct {
+	OK      bool            `json:"ok"`
+	Systems []*systemHealth `json:"systems"`
+}
+
+func (r *registry) health() *health {
+	now := r.Now()
+	h := &health{OK: true, Systems: make([]*systemHealth, 0)}
+	for _, name := range r.names() {
+		age := r.system(name).age(now)
+		ok := age <= r.StaleAfter
+		h.Systems = append(h.Systems, &systemHealth{Name: name, OK: ok, AgeSeconds: age.Round(time.Second).Seconds()})
+		if !ok {
+			h.OK = false
+		}
+	}
+	return h
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	case "/healthz":
+		h := r.health()
+		data, err := json.MarshalIndent(h, "", "    ")
+		if err != nil {
+			rest.ServerError(w, req, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if !h.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(data)
+	default:
+		rest.NotFound(w, req)
+	}
+}
+
+// serve runs an HTTP server for the registry on addr until ctx is canceled.
+func (r *registry) serve(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handlers.Log(r),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	handlers.Logger.Info("Starting metrics server", "addr", addr, "protocol", "http")
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m, s, cleanup := testMonitor(t)
+	defer cleanup()
+	reg := newRegistry()
+	reg.Now = func() time.Time { return s.Now().Add(30 * time.Second) }
+	m.name = "gobike"
+	m.metrics = reg.system(m.name)
+	s.Advance(10 * time.Minute)
+	if err := m.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if _, err := reg.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE gobike_monitor_poll_duration_seconds histogram\n",
+		`gobike_monitor_poll_duration_seconds_count{system="gobike"} 1` + "\n",
+		`gobike_monitor_poll_duration_seconds_bucket{system="gobike",le="+Inf"} 1` + "\n",
+		`gobike_monitor_poll_errors_total{system="gobike"} 0` + "\n",
+		`gobike_monitor_rows_written_total{system="gobike",storage="csv"} 5` + "\n",
+		`gobike_monitor_stations{system="gobike"} 5` + "\n",
+		`gobike_monitor_feed_age_seconds{system="gobike"} 30` + "\n",
+		`gobike_monitor_file_rotations_total{system="gobike"} 0` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("metrics output missing %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	now := time.Date(2018, 8, 26, 0, 0, 0, 0, time.UTC)
+	reg := newRegistry()
+	reg.Now = func() time.Time { return now }
+	reg.system("gobike").observeFeed(now.Add(-time.Minute), 5, 1, 0)
+	check := func(wantCode int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		reg.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		if w.Code != wantCode {
+			t.Errorf("expected code %d, got %d: %s", wantCode, w.Code, w.Body.String())
+		}
+		h := new(health)
+		if err := json.Unmarshal(w.Body.Bytes(), h); err != nil {
+			t.Fatal(err)
+		}
+		if h.OK != (wantCode == http.StatusOK) {
+			t.Errorf("expected ok to match the status code, got %v", h.OK)
+		}
+	}
+	check(http.StatusOK)
+	now = now.Add(10 * time.Minute)
+	check(http.StatusServiceUnavailable)
+	reg.StaleAfter = time.Hour
+	check(http.StatusOK)
+	// a system that has never responded is stale once it's been running for
+	// longer than the threshold.
+	reg.system("other")
+	now = now.Add(2 * time.Hour)
+	reg.system("gobike").observeFeed(now, 5, 1, 0)
+	check(http.StatusServiceUnavailable)
+
+	// a fresh free bike feed doesn't hide a stale station feed, or the other
+	// way around
+	reg = newRegistry()
+	reg.Now = func() time.Time { return now }
+	sys := reg.system("gobike")
+	sys.setFeeds(map[string]bool{feedStationStatus: true, feedFreeBikeStatus: true})
+	sys.observeFeed(now.Add(-time.Hour), 5, 1, 0)
+	sys.observeFreeBikes(now, 10, nil)
+	check(http.StatusServiceUnavailable)
+	sys.observeFeed(now, 5, 1, 0)
+	check(http.StatusOK)
+	now = now.Add(time.Hour)
+	sys.observeFeed(now, 5, 1, 0)
+	check(http.StatusServiceUnavailable)
+	// unless the system doesn't poll it
+	sys.setFeeds(map[string]bool{feedStationStatus: true})
+	check(http.StatusOK)
+}
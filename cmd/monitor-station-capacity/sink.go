@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/rest"
+)
+
+// A sink stores the new station statuses from each poll.
+type sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	// Write stores the statuses from a single poll. If Write returns an
+	// error, the sink holds on to the statuses it couldn't store, and tries
+	// them again on the next call to Write or Close.
+	Write(statuses []*gobike.StationStatus) error
+	// Pending returns the number of statuses that haven't been stored yet.
+	Pending() int
+	// Close stores any pending statuses and releases the sink's resources.
+	Close() error
+}
+
+// A bikeSink is a sink that can also store free bike snapshots.
+type bikeSink interface {
+	WriteBikes(bikes []*gobike.FreeBike) error
+}
+
+// A bikeResumer is a bikeSink that can report the last snapshot it has stored
+// for each free bike.
+type bikeResumer interface {
+	LastBikes() (map[string]*gobike.FreeBike, error)
+}
+
+// A resumer is a sink that can report the most recent status it has stored
+// for each station, so the monitor doesn't store the same rows twice after a
+// restart.
+type resumer interface {
+	LastReported() (map[string]time.Time, error)
+}
+
+// openSinks opens the storage configured for sys. If one of them fails to
+// open, the ones already open are closed.
+func openSinks(sys *SystemConfig, now time.Time, metrics *systemMetrics) ([]sink, error) {
+	sinks := make([]sink, 0, len(sys.Storage))
+	for _, storage := range sys.Storage {
+		var s sink
+		var err error
+		switch storage {
+		case storageCSV:
+			var c *csvSink
+			c, err = newCSVSink(sys.OutputDir, now)
+			if err == nil {
+				c.metrics = metrics
+				c.system = sys.Name
+				s = c
+			}
+		case storageJSONL:
+			s = newJSONLSink(os.Stdout, sys.Name)
+		case storageSQLite:
+			s, err = newSQLiteSink(sys.Database, sys.Name)
+		default:
+			err = fmt.Errorf("unknown storage %q", storage)
+		}
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, fmt.Errorf("%s: could not open %s storage: %v", sys.Name, storage, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// writeBuffer writes as much of buf to w as it can, retrying a few times.
+// Rows that can't be written stay in buf.
+func writeBuffer(w io.Writer, buf *bytes.Buffer) error {
+	var err error
+	for i := 0; i < writeRetries && buf.Len() > 0; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+		}
+		var n int
+		n, err = w.Write(buf.Bytes())
+		// don't write the same rows twice if the write was partial
+		buf.Next(n)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// dailyFile appends rows to a file in dir named after the day, for example
+// 2018-08-26-capacity.csv, and moves to a new file when the day changes.
+type dailyFile struct {
+	dir    string
+	suffix string
+	// f is nil until the first row is written.
+	f *os.File
+	// prefix is the date of the file we're currently writing to.
+	prefix string
+	buf    *bytes.Buffer
+}
+
+func newDailyFile(dir, suffix string, now time.Time) *dailyFile {
+	return &dailyFile{
+		dir:    dir,
+		suffix: suffix,
+		prefix: now.Format("2006-01-02"),
+		buf:    new(bytes.Buffer),
+	}
+}
+
+func (d *dailyFile) filename() string {
+	return filepath.Join(d.dir, d.prefix+d.suffix)
+}
+
+func (d *dailyFile) open() error {
+	if d.f != nil {
+		return nil
+	}
+	f, err := os.OpenFile(d.filename(), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	d.f = f
+	return nil
+}
+
+// flush writes buffered rows to the current file. Rows that can't be written
+// stay in the buffer.
+func (d *dailyFile) flush() error {
+	if d.buf.Len() == 0 {
+		return nil
+	}
+	if err := d.open(); err != nil {
+		return err
+	}
+	return writeBuffer(d.f, d.buf)
+}
+
+func (d *dailyFile) pending() int {
+	return bytes.Count(d.buf.Bytes(), []byte{'\n'})
+}
+
+// rotate flushes and closes the current file; the next flush opens the file
+// for prefix.
+func (d *dailyFile) rotate(prefix string) error {
+	if err := d.close(); err != nil {
+		return err
+	}
+	d.prefix = prefix
+	return nil
+}
+
+// close flushes buffered rows, and syncs and closes the current file.
+func (d *dailyFile) close() error {
+	err := d.flush()
+	if d.f == nil {
+		return err
+	}
+	if syncErr := d.f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := d.f.Close(); err == nil {
+		err = closeErr
+	}
+	d.f = nil
+	return err
+}
+
+// csvSink appends statuses to daily CSV files in dir, in the format read by
+// gobike.LoadCapacity, and free bikes to separate daily files in the format
+// read by gobike.LoadFreeBikes.
+type csvSink struct {
+	dir      string
+	lockfile *os.File
+	capacity *dailyFile
+	bikes    *dailyFile
+	now      time.Time
+	// metrics and system are used to report file rotations.
+	metrics *systemMetrics
+	system  string
+}
+
+// newCSVSink locks dir and opens the capacity file for the day containing
+// now.
+func newCSVSink(dir string, now time.Time) (*csvSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lockfile, err := os.Create(filepath.Join(dir, "capacity.lock"))
+	if err != nil {
+		return nil, err
+	}
+	if err := lock(lockfile); err != nil {
+		lockfile.Close()
+		return nil, err
+	}
+	capacity := newDailyFile(dir, "-capacity.csv", now)
+	if err := capacity.open(); err != nil {
+		unlock(lockfile)
+		lockfile.Close()
+		return nil, err
+	}
+	return &csvSink{
+		dir:      dir,
+		lockfile: lockfile,
+		capacity: capacity,
+		bikes:    newDailyFile(dir, "-free-bikes.csv", now),
+		now:      now,
+		metrics:  newSystemMetrics(now),
+	}, nil
+}
+
+func (s *csvSink) Name() string { return storageCSV }
+
+// LastReported reads the statuses already in today's file.
+func (s *csvSink) LastReported() (map[string]time.Time, error) {
+	f, err := os.Open(s.capacity.filename())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lastReported := make(map[string]time.Time)
+	err = gobike.ForeachStationStatus(bufio.NewReader(f), func(stationStatus *gobike.StationStatus) error {
+		if stationStatus.LastReported.Equal(lastReported[stationStatus.ID]) || stationStatus.LastReported.Before(lastReported[stationStatus.ID]) {
+			return nil
+		}
+		lastReported[stationStatus.ID] = stationStatus.LastReported
+		return nil
+	})
+	// a partially written last line shouldn't stop us from starting up
+	if err != nil && len(lastReported) == 0 {
+		return nil, err
+	}
+	return lastReported, nil
+}
+
+// LastBikes reads the free bikes already in today's file that haven't gone
+// from the feed.
+func (s *csvSink) LastBikes() (map[string]*gobike.FreeBike, error) {
+	bikes := make(map[string]*gobike.FreeBike)
+	f, err := os.Open(s.bikes.filename())
+	if os.IsNotExist(err) {
+		return bikes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = gobike.ForeachFreeBike(bufio.NewReader(f), func(b *gobike.FreeBike) error {
+		if b.Gone {
+			delete(bikes, b.ID)
+		} else {
+			bikes[b.ID] = b
+		}
+		return nil
+	})
+	if err != nil && len(bikes) == 0 {
+		return nil, err
+	}
+	return bikes, nil
+}
+
+// rotate moves d to a new day's file if t is on a later day.
+func (s *csvSink) rotate(d *dailyFile, t time.Time) error {
+	prefix := t.Format("2006-01-02")
+	if !t.After(s.now) || prefix <= d.prefix {
+		return nil
+	}
+	oldName := d.filename()
+	if err := d.rotate(prefix); err != nil {
+		return err
+	}
+	s.metrics.rotated()
+	rest.Logger.Info("rotate file", "system", s.system, "old", oldName, "new", d.filename())
+	return nil
+}
+
+func (s *csvSink) Write(statuses []*gobike.StationStatus) error {
+	for _, station := range statuses {
+		if err := s.rotate(s.capacity, station.LastReported); err != nil {
+			return err
+		}
+		writeStation(s.capacity.buf, station)
+	}
+	return s.capacity.flush()
+}
+
+func (s *csvSink) WriteBikes(bikes []*gobike.FreeBike) error {
+	for _, b := range bikes {
+		if err := s.rotate(s.bikes, b.LastReported); err != nil {
+			return err
+		}
+		gobike.WriteFreeBike(s.bikes.buf, b)
+	}
+	return s.bikes.flush()
+}
+
+func (s *csvSink) Pending() int {
+	return s.capacity.pending() + s.bikes.pending()
+}
+
+// Close flushes any buffered rows, syncs the current files to disk and
+// releases the lock on the data directory.
+func (s *csvSink) Close() error {
+	err := s.capacity.close()
+	if closeErr := s.bikes.close(); err == nil {
+		err = closeErr
+	}
+	if unlockErr := unlock(s.lockfile); err == nil {
+		err = unlockErr
+	}
+	if closeErr := s.lockfile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// stdoutMu serializes writes to stdout, which is shared by every system.
+var stdoutMu sync.Mutex
+
+// jsonlSink writes one JSON object per status or free bike to w, for piping
+// into other programs. The "feed" field says which kind of object it is. The
+// rows from a poll are written together.
+type jsonlSink struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	system string
+	buf    *bytes.Buffer
+}
+
+func newJSONLSink(w io.Writer, system string) *jsonlSink {
+	return &jsonlSink{
+		w:      w,
+		mu:     &stdoutMu,
+		system: system,
+		buf:    new(bytes.Buffer),
+	}
+}
+
+// statusJSON uses the field names from the GBFS station_status feed.
+type statusJSON struct {
+	Feed               string    `json:"feed"`
+	System             string    `json:"system"`
+	StationID          string    `json:"station_id"`
+	NumBikesAvailable  int16     `json:"num_bikes_available"`
+	NumEBikesAvailable int16     `json:"num_ebikes_available"`
+	NumBikesDisabled   int16     `json:"num_bikes_disabled"`
+	NumDocksAvailable  int16     `json:"num_docks_available"`
+	NumDocksDisabled   int16     `json:"num_docks_disabled"`
+	IsInstalled        bool      `json:"is_installed"`
+	IsRenting          bool      `json:"is_renting"`
+	IsReturning        bool      `json:"is_returning"`
+	LastReported       time.Time `json:"last_reported"`
+}
+
+func (s *jsonlSink) Name() string { return storageJSONL }
+
+func (s *jsonlSink) Write(statuses []*gobike.StationStatus) error {
+	enc := json.NewEncoder(s.buf)
+	for _, ss := range statuses {
+		if err := enc.Encode(&statusJSON{
+			Feed:               feedStationStatus,
+			System:             s.system,
+			StationID:          ss.ID,
+			NumBikesAvailable:  ss.NumBikesAvailable,
+			NumEBikesAvailable: ss.NumEBikesAvailable,
+			NumBikesDisabled:   ss.NumBikesDisabled,
+			NumDocksAvailable:  ss.NumDocksAvailable,
+			NumDocksDisabled:   ss.NumDocksDisabled,
+			IsInstalled:        ss.IsInstalled,
+			IsRenting:          ss.IsRenting,
+			IsReturning:        ss.IsReturning,
+			LastReported:       ss.LastReported.UTC(),
+		}); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+// freeBikeJSON uses the field names from the GBFS free_bike_status feed.
+type freeBikeJSON struct {
+	Feed   string `json:"feed"`
+	System string `json:"system"`
+	*gobike.FreeBike
+}
+
+func (s *jsonlSink) WriteBikes(bikes []*gobike.FreeBike) error {
+	enc := json.NewEncoder(s.buf)
+	for _, b := range bikes {
+		utc := *b
+		utc.LastReported = b.LastReported.UTC()
+		if err := enc.Encode(&freeBikeJSON{Feed: feedFreeBikeStatus, System: s.system, FreeBike: &utc}); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+func (s *jsonlSink) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeBuffer(s.w, s.buf)
+}
+
+func (s *jsonlSink) Pending() int {
+	return bytes.Count(s.buf.Bytes(), []byte{'\n'})
+}
+
+func (s *jsonlSink) Close() error {
+	return s.flush()
+}
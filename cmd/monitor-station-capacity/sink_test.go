@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+var sinkStatuses = []*gobike.StationStatus{
+	{ID: "3", NumBikesAvailable: 20, NumDocksAvailable: 11, IsInstalled: true, IsRenting: true, IsReturning: true, LastReported: time.Unix(1535241601, 0)},
+	{ID: "4", NumBikesAvailable: 4, NumDocksAvailable: 27, IsInstalled: true, LastReported: time.Unix(1535241698, 0)},
+}
+
+func TestJSONLSink(t *testing.T) {
+	buf := new(bytes.Buffer)
+	s := newJSONLSink(buf, "gobike")
+	if err := s.Write(sinkStatuses); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(buf)
+	var rows []statusJSON
+	for scanner.Scan() {
+		var row statusJSON
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if rows[0].System != "gobike" || rows[0].StationID != "3" || rows[0].NumBikesAvailable != 20 || !rows[0].LastReported.Equal(sinkStatuses[0].LastReported) {
+		t.Errorf("bad row: %#v", rows[0])
+	}
+	if rows[1].IsRenting {
+		t.Errorf("expected station 4 to not be renting")
+	}
+}
+
+type fakeResumer struct {
+	*jsonlSink
+	reported map[string]time.Time
+}
+
+func (f *fakeResumer) LastReported() (map[string]time.Time, error) {
+	return f.reported, nil
+}
+
+func TestNewMonitorResume(t *testing.T) {
+	t0 := time.Unix(1535241600, 0)
+	a := &fakeResumer{newJSONLSink(new(bytes.Buffer), "a"), map[string]time.Time{"3": t0.Add(time.Minute), "4": t0}}
+	b := &fakeResumer{newJSONLSink(new(bytes.Buffer), "b"), map[string]time.Time{"3": t0}}
+	m, err := newMonitor(nil, t0, a, b, newJSONLSink(new(bytes.Buffer), "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.lastReported) != 1 || !m.lastReported["3"].Equal(t0) {
+		t.Errorf("expected to resume from the oldest status in any sink, got %v", m.lastReported)
+	}
+}
+
+func TestSQLiteNotCompiled(t *testing.T) {
+	if sqliteAvailable() {
+		t.Skip("sqlite driver is compiled in")
+	}
+	_, err := newSQLiteSink("capacity.db", "gobike")
+	if err == nil || !strings.Contains(err.Error(), "-tags sqlite") {
+		t.Errorf("expected an error explaining how to build with sqlite, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// sqliteDriver is registered by sqlite_driver.go, which is only compiled with
+// the "sqlite" build tag, since it requires cgo.
+const sqliteDriver = "sqlite3"
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS station_status (
+	system TEXT NOT NULL,
+	station_id TEXT NOT NULL,
+	last_reported TEXT NOT NULL,
+	num_bikes_available INTEGER NOT NULL,
+	num_ebikes_available INTEGER NOT NULL,
+	num_bikes_disabled INTEGER NOT NULL,
+	num_docks_available INTEGER NOT NULL,
+	num_docks_disabled INTEGER NOT NULL,
+	is_installed BOOLEAN NOT NULL,
+	is_renting BOOLEAN NOT NULL,
+	is_returning BOOLEAN NOT NULL,
+	PRIMARY KEY (system, station_id, last_reported)
+);
+CREATE INDEX IF NOT EXISTS station_status_last_reported ON station_status (last_reported);
+CREATE TABLE IF NOT EXISTS free_bike_status (
+	system TEXT NOT NULL,
+	bike_id TEXT NOT NULL,
+	last_reported TEXT NOT NULL,
+	lat REAL NOT NULL,
+	lon REAL NOT NULL,
+	vehicle_type_id TEXT NOT NULL,
+	is_reserved BOOLEAN NOT NULL,
+	is_disabled BOOLEAN NOT NULL,
+	is_gone BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (system, bike_id, last_reported)
+);`
+
+const sqliteInsert = `INSERT OR IGNORE INTO station_status (
+	system, station_id, last_reported, num_bikes_available, num_ebikes_available,
+	num_bikes_disabled, num_docks_available, num_docks_disabled, is_installed,
+	is_renting, is_returning
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const sqliteInsertBike = `INSERT OR IGNORE INTO free_bike_status (
+	system, bike_id, last_reported, lat, lon, vehicle_type_id, is_reserved,
+	is_disabled, is_gone
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// sqliteTimeFormat sorts correctly as text and works with SQLite's date and
+// time functions.
+const sqliteTimeFormat = "2006-01-02T15:04:05Z"
+
+// sqlSink stores statuses in the station_status table of a SQLite database,
+// and free bikes in the free_bike_status table. The rows from a poll are
+// written in a single transaction.
+type sqlSink struct {
+	db           *sql.DB
+	system       string
+	pending      []*gobike.StationStatus
+	pendingBikes []*gobike.FreeBike
+}
+
+func sqliteAvailable() bool {
+	for _, name := range sql.Drivers() {
+		if name == sqliteDriver {
+			return true
+		}
+	}
+	return false
+}
+
+// newSQLiteSink opens (or creates) the SQLite database at path.
+func newSQLiteSink(path string, system string) (*sqlSink, error) {
+	if !sqliteAvailable() {
+		return nil, errors.New("sqlite storage is not compiled in; rebuild monitor-station-capacity with -tags sqlite")
+	}
+	// Several systems may share a database; wait for each other's writes
+	// instead of failing.
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	return newSQLSink(db, system)
+}
+
+func newSQLSink(db *sql.DB, system string) (*sqlSink, error) {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create tables: %v", err)
+	}
+	return &sqlSink{db: db, system: system}, nil
+}
+
+func (s *sqlSink) Name() string { return storageSQLite }
+
+// LastReported returns the most recent status stored for each station.
+func (s *sqlSink) LastReported() (map[string]time.Time, error) {
+	rows, err := s.db.Query("SELECT station_id, MAX(last_reported) FROM station_status WHERE system = ? GROUP BY station_id", s.system)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lastReported := make(map[string]time.Time)
+	for rows.Next() {
+		var id, reported string
+		if err := rows.Scan(&id, &reported); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(sqliteTimeFormat, reported)
+		if err != nil {
+			return nil, err
+		}
+		lastReported[id] = t
+	}
+	return lastReported, rows.Err()
+}
+
+// LastBikes returns the most recent snapshot stored for each free bike that
+// hasn't gone from the feed.
+func (s *sqlSink) LastBikes() (map[string]*gobike.FreeBike, error) {
+	rows, err := s.db.Query(`SELECT b.bike_id, b.last_reported, b.lat, b.lon, b.vehicle_type_id, b.is_reserved, b.is_disabled
+FROM free_bike_status b JOIN (
+	SELECT bike_id, MAX(last_reported) AS last_reported FROM free_bike_status WHERE system = ? GROUP BY bike_id
+) latest ON b.bike_id = latest.bike_id AND b.last_reported = latest.last_reported
+WHERE b.system = ? AND NOT b.is_gone`, s.system, s.system)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bikes := make(map[string]*gobike.FreeBike)
+	for rows.Next() {
+		b := new(gobike.FreeBike)
+		var reported string
+		if err := rows.Scan(&b.ID, &reported, &b.Latitude, &b.Longitude, &b.VehicleType, &b.IsReserved, &b.IsDisabled); err != nil {
+			return nil, err
+		}
+		b.LastReported, err = time.Parse(sqliteTimeFormat, reported)
+		if err != nil {
+			return nil, err
+		}
+		bikes[b.ID] = b
+	}
+	return bikes, rows.Err()
+}
+
+func (s *sqlSink) Write(statuses []*gobike.StationStatus) error {
+	s.pending = append(s.pending, statuses...)
+	return s.flush()
+}
+
+func (s *sqlSink) WriteBikes(bikes []*gobike.FreeBike) error {
+	s.pendingBikes = append(s.pendingBikes, bikes...)
+	return s.flush()
+}
+
+func (s *sqlSink) flush() error {
+	if len(s.pending) == 0 && len(s.pendingBikes) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := s.insertStatuses(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := s.insertBikes(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.pending = s.pending[:0]
+	s.pendingBikes = s.pendingBikes[:0]
+	return nil
+}
+
+func (s *sqlSink) insertStatuses(tx *sql.Tx) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(sqliteInsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, ss := range s.pending {
+		if _, err := stmt.Exec(
+			s.system, ss.ID, ss.LastReported.UTC().Format(sqliteTimeFormat),
+			ss.NumBikesAvailable, ss.NumEBikesAvailable, ss.NumBikesDisabled,
+			ss.NumDocksAvailable, ss.NumDocksDisabled,
+			ss.IsInstalled, ss.IsRenting, ss.IsReturning,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlSink) insertBikes(tx *sql.Tx) error {
+	if len(s.pendingBikes) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(sqliteInsertBike)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, b := range s.pendingBikes {
+		if _, err := stmt.Exec(
+			s.system, b.ID, b.LastReported.UTC().Format(sqliteTimeFormat),
+			b.Latitude, b.Longitude, b.VehicleType, b.IsReserved, b.IsDisabled,
+			b.Gone,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlSink) Pending() int {
+	return len(s.pending) + len(s.pendingBikes)
+}
+
+func (s *sqlSink) Close() error {
+	err := s.flush()
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+// +build sqlite
+
+package main
+
+import (
+	// registers the "sqlite3" database/sql driver. It isn't vendored; go get
+	// it before building with -tags sqlite.
+	_ "github.com/mattn/go-sqlite3"
+)
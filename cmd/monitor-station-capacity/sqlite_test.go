@@ -0,0 +1,79 @@
+// +build sqlite
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestSQLiteSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor-station-capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capacity.db")
+	s, err := newSQLiteSink(path, "gobike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(sinkStatuses); err != nil {
+		t.Fatal(err)
+	}
+	// writing the same rows again is harmless
+	if err := s.Write(sinkStatuses[:1]); err != nil {
+		t.Fatal(err)
+	}
+	bike := &gobike.FreeBike{ID: "b1", Latitude: 37.77, Longitude: -122.42, LastReported: sinkStatuses[0].LastReported}
+	if err := s.WriteBikes([]*gobike.FreeBike{bike}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = newSQLiteSink(path, "gobike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM station_status WHERE is_renting").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected one renting station, got %d", count)
+	}
+	reported, err := s.LastReported()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 2 || !reported["4"].Equal(sinkStatuses[1].LastReported) {
+		t.Errorf("bad last reported times: %v", reported)
+	}
+	bikes, err := s.LastBikes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := bikes["b1"]; b == nil || b.Latitude != bike.Latitude || !b.LastReported.Equal(bike.LastReported) {
+		t.Errorf("bad last bikes: %v", bikes)
+	}
+	gone := *bike
+	gone.Gone = true
+	gone.LastReported = bike.LastReported.Add(time.Minute)
+	if err := s.WriteBikes([]*gobike.FreeBike{&gone}); err != nil {
+		t.Fatal(err)
+	}
+	bikes, err = s.LastBikes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bikes) != 0 {
+		t.Errorf("expected no bikes once b1 is gone, got %v", bikes)
+	}
+}
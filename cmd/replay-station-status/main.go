@@ -0,0 +1,251 @@
+// Command replay-station-status reconstructs the state of every station from
+// the capacity files written by monitor-station-capacity, and replays it.
+//
+//	replay-station-status -start 2018-08-26T08:00 -end 2018-08-26T10:00 data/station-capacity
+//	replay-station-status -mode diff -step 30s -start 2018-08-26T08:00 -end 2018-08-26T09:00 data/station-capacity
+//	replay-station-status -mode serve -speedup 120 -start 2018-08-26 data/station-capacity
+//
+// In print mode it prints every station's status at each step. In diff mode it
+// prints the state at -start, then only the stations that changed at each
+// step. In serve mode it serves gbfs.json, station_information.json and
+// station_status.json on -http, advancing the clock by -step every
+// step/speedup, so the site, the monitor or its alert rules can be pointed at
+// a past incident:
+//
+//	monitor-station-capacity -host http://localhost:8334 -dir /tmp/replay
+//
+// Times without a zone are in America/Los_Angeles.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/gbfstest"
+	"github.com/kevinburke/gobike/stats"
+)
+
+const (
+	modePrint = "print"
+	modeDiff  = "diff"
+	modeServe = "serve"
+)
+
+var timeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	for _, format := range timeFormats {
+		if t, err := time.ParseInLocation(format, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse %q as a time; use a format like 2018-08-26T08:00", s)
+}
+
+// loadRange reads the statuses reported between from and to from the capacity
+// files in directory. Files are named after the day they were written, so
+// only the ones that could hold statuses in the range are read.
+func loadRange(directory string, from, to time.Time) ([]*gobike.StationStatus, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	// a file's name is the date in the monitor's time zone, which may not be
+	// ours, so allow a day on either side.
+	first := from.AddDate(0, 0, -1).Format("2006-01-02")
+	last := to.AddDate(0, 0, 1).Format("2006-01-02")
+	statuses := make([]*gobike.StationStatus, 0)
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, "-capacity.csv") {
+			continue
+		}
+		day := strings.TrimSuffix(name, "-capacity.csv")
+		if day < first || day > last {
+			continue
+		}
+		f, err := os.Open(filepath.Join(directory, name))
+		if err != nil {
+			return nil, err
+		}
+		err = gobike.ForeachStationStatus(bufio.NewReader(f), func(ss *gobike.StationStatus) error {
+			if !ss.LastReported.Before(from) && !ss.LastReported.After(to) {
+				statuses = append(statuses, ss)
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not load file %q: %v", name, err)
+		}
+	}
+	return statuses, nil
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "t"
+	}
+	return "f"
+}
+
+func stationName(stations map[string]*gobike.Station, id string) string {
+	if s, ok := stations[id]; ok {
+		return s.Name
+	}
+	return ""
+}
+
+func printState(w *tabwriter.Writer, t time.Time, state []*gobike.StationStatus, stations map[string]*gobike.Station) {
+	fmt.Fprintf(w, "# %s\n", t.Format(time.RFC3339))
+	fmt.Fprintln(w, "station_id\tname\tbikes\tebikes\tdisabled\tdocks\tdocks_disabled\tinstalled\trenting\treturning\tlast_reported")
+	for _, ss := range state {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", ss.ID, stationName(stations, ss.ID),
+			ss.NumBikesAvailable, ss.NumEBikesAvailable, ss.NumBikesDisabled,
+			ss.NumDocksAvailable, ss.NumDocksDisabled,
+			formatBool(ss.IsInstalled), formatBool(ss.IsRenting), formatBool(ss.IsReturning),
+			ss.LastReported.Format(time.RFC3339))
+	}
+}
+
+func describe(ss *gobike.StationStatus) string {
+	if ss == nil {
+		return "-"
+	}
+	desc := strconv.Itoa(int(ss.NumBikesAvailable)) + " bikes, " + strconv.Itoa(int(ss.NumDocksAvailable)) + " docks"
+	if ss.NumBikesDisabled > 0 || ss.NumDocksDisabled > 0 {
+		desc += fmt.Sprintf(" (%d/%d disabled)", ss.NumBikesDisabled, ss.NumDocksDisabled)
+	}
+	if !ss.IsInstalled {
+		desc += ", not installed"
+	} else if !ss.IsRenting || !ss.IsReturning {
+		desc += fmt.Sprintf(", renting=%s returning=%s", formatBool(ss.IsRenting), formatBool(ss.IsReturning))
+	}
+	return desc
+}
+
+func printDiff(w *tabwriter.Writer, t time.Time, changes []*stats.StatusChange, stations map[string]*gobike.Station) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t->\t%s\n", t.Format(time.RFC3339), c.ID, stationName(stations, c.ID), describe(c.Old), describe(c.New))
+	}
+}
+
+func serve(addr string, stations []*gobike.Station, statuses []*gobike.StationStatus, start, end time.Time, step time.Duration, speedup float64) error {
+	feed := gbfstest.NewFeed(stations, statuses, start)
+	interval := time.Duration(float64(step) / speedup)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	feed.TTL = int(math.Ceil(interval.Seconds()))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !feed.Now().Before(end) {
+				log.Printf("reached %s; serving the final state until interrupted", end.Format(time.RFC3339))
+				return
+			}
+			feed.Advance(step)
+		}
+	}()
+	log.Printf("replaying %s to %s on http://%s, %s every %s", start.Format(time.RFC3339), end.Format(time.RFC3339), addr, step, interval)
+	return http.ListenAndServe(addr, feed)
+}
+
+func main() {
+	startFlag := flag.String("start", "", "Time to start replaying from (required)")
+	endFlag := flag.String("end", "", "Time to stop replaying (default: a day after -start)")
+	step := flag.Duration("step", time.Minute, "Time between steps")
+	mode := flag.String("mode", modePrint, "What to do with each step: "+modePrint+", "+modeDiff+" or "+modeServe)
+	lookback := flag.Duration("lookback", 24*time.Hour, "How far before -start to look for each station's last status")
+	info := flag.String("station-information", "data/station_information.json", "station_information.json file for station names; may be empty")
+	addr := flag.String("http", "localhost:8334", "Address to serve the feed on in serve mode")
+	speedup := flag.Float64("speedup", 60, "In serve mode, how much faster than real time to replay")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: replay-station-status [flags] capacity-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *startFlag == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *step <= 0 {
+		log.Fatal("-step must be positive")
+	}
+	if *speedup <= 0 {
+		log.Fatal("-speedup must be positive")
+	}
+	if *mode != modePrint && *mode != modeDiff && *mode != modeServe {
+		log.Fatalf("unknown -mode %q", *mode)
+	}
+	tz, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		log.Fatal(err)
+	}
+	start, err := parseTime(*startFlag, tz)
+	if err != nil {
+		log.Fatal(err)
+	}
+	end := start.AddDate(0, 0, 1)
+	if *endFlag != "" {
+		end, err = parseTime(*endFlag, tz)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if end.Before(start) {
+		log.Fatal("-end is before -start")
+	}
+	var stations []*gobike.Station
+	if *info != "" {
+		stations, err = client.LoadStations(*info)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	statuses, err := loadRange(flag.Arg(0), start.Add(-*lookback), end)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(statuses) == 0 {
+		log.Fatalf("no statuses between %s and %s in %s", start.Add(-*lookback).Format(time.RFC3339), end.Format(time.RFC3339), flag.Arg(0))
+	}
+
+	if *mode == modeServe {
+		log.Fatal(serve(*addr, stations, statuses, start, end, *step, *speedup))
+	}
+	stationMap := gobike.StationMap(stations)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	var prev []*gobike.StationStatus
+	err = stats.Replay(stats.StatusMap(statuses), start, end, *step, func(t time.Time, state []*gobike.StationStatus) error {
+		if *mode == modePrint || t.Equal(start) {
+			printState(w, t, state, stationMap)
+		} else {
+			printDiff(w, t, stats.DiffStatuses(prev, state), stationMap)
+		}
+		prev = state
+		return w.Flush()
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
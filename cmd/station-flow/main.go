@@ -0,0 +1,95 @@
+// Command station-flow prints the stations that chronically lose or gain bikes
+// on weekdays, by time of day, most imbalanced first.
+//
+//	station-flow -capacity data/station-capacity data
+//
+// Each column is the average number of bikes per weekday the station gains
+// from trips (negative if it loses them), marked with "source" or "sink" if it
+// happens most days. If -capacity is set, the number in brackets is the
+// average change in bikes per day that trips don't explain, usually
+// rebalancing.
+//
+// Weekends and holidays are left out, along with the events in the -events
+// calendar:
+//
+//	station-flow -events data/events.csv data
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/stats"
+)
+
+func main() {
+	capacity := flag.String("capacity", "", "Directory of capacity files written by monitor-station-capacity")
+	info := flag.String("station-information", "data/station_information.json", "station_information.json file for station names")
+	all := flag.Bool("all", false, "Print every station, not just chronic sources and sinks")
+	events := flag.String("events", "", "CSV file of events like Bay to Breakers to leave out, like holidays")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: station-flow [flags] trip-directory\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *events != "" {
+		calendar, err := stats.LoadCalendar(*events)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stats.SetCalendar(calendar)
+	}
+	stations, err := client.LoadStations(*info)
+	if err != nil {
+		log.Fatal(err)
+	}
+	trips, err := gobike.LoadDir(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var byStation map[string][]*gobike.StationStatus
+	if *capacity != "" {
+		statuses, err := gobike.LoadCapacityDir(*capacity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		byStation = stats.StatusMap(statuses)
+	}
+	flows := stats.StationFlows(gobike.StationMap(stations), trips, byStation)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	header := []string{"station_id", "name"}
+	for _, tod := range stats.TimesOfDay {
+		header = append(header, fmt.Sprintf("%s (%d-%d)", strings.ToLower(tod.Name), tod.Start, tod.End))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, f := range flows {
+		if !*all && !f.Chronic() {
+			continue
+		}
+		row := []string{fmt.Sprintf("%d", f.Station.ID), f.Station.Name}
+		for i, tod := range stats.TimesOfDay {
+			col := fmt.Sprintf("%+.1f", f.NetFlowDuring(tod))
+			if f.Classes[i] != stats.Balanced {
+				col += " " + f.Classes[i].String()
+			}
+			if f.ObservedDays > 0 {
+				col += fmt.Sprintf(" [%+.1f]", f.RebalancedDuring(tod))
+			}
+			row = append(row, col)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,170 @@
+package gobike
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// FreeBike is a vehicle that is parked somewhere other than a station, from
+// the GBFS free_bike_status feed.
+type FreeBike struct {
+	ID        string  `json:"bike_id"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	// VehicleType is the vehicle_type_id from the feed, if it has one, for
+	// example "ebike". It may be empty.
+	VehicleType string `json:"vehicle_type_id"`
+	IsReserved  bool   `json:"is_reserved"`
+	IsDisabled  bool   `json:"is_disabled"`
+	// LastReported is when the vehicle reported this position, or if the
+	// feed doesn't say, when the feed was updated.
+	LastReported time.Time `json:"last_reported"`
+	// Gone is true if the vehicle dropped out of the feed at LastReported,
+	// because it was rented or docked at a station. The position and state
+	// are the last ones seen.
+	Gone bool `json:"is_gone,omitempty"`
+}
+
+// Moved reports whether b is in a different place or state than prev, a
+// previous snapshot of the same vehicle.
+func (b *FreeBike) Moved(prev *FreeBike) bool {
+	return b.Latitude != prev.Latitude || b.Longitude != prev.Longitude ||
+		b.IsReserved != prev.IsReserved || b.IsDisabled != prev.IsDisabled ||
+		b.Gone != prev.Gone
+}
+
+// Distance returns the distance between two snapshots, in miles.
+func (b *FreeBike) Distance(other *FreeBike) float64 {
+	start := s2.LatLngFromDegrees(b.Latitude, b.Longitude)
+	end := s2.LatLngFromDegrees(other.Latitude, other.Longitude)
+	return earthRadiusMiles * start.Distance(end).Radians()
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "t"
+	}
+	return "f"
+}
+
+// WriteFreeBike writes b to buf in the CSV format read by LoadFreeBikes:
+//
+//	last_reported,bike_id,lat,lon,vehicle_type_id,is_reserved,is_disabled,is_gone
+func WriteFreeBike(buf *bytes.Buffer, b *FreeBike) {
+	buf.WriteString(b.LastReported.Format(time.RFC3339))
+	buf.WriteByte(',')
+	buf.WriteString(b.ID)
+	buf.WriteByte(',')
+	buf.WriteString(strconv.FormatFloat(b.Latitude, 'f', -1, 64))
+	buf.WriteByte(',')
+	buf.WriteString(strconv.FormatFloat(b.Longitude, 'f', -1, 64))
+	buf.WriteByte(',')
+	buf.WriteString(b.VehicleType)
+	buf.WriteByte(',')
+	buf.WriteString(formatBool(b.IsReserved))
+	buf.WriteByte(',')
+	buf.WriteString(formatBool(b.IsDisabled))
+	buf.WriteByte(',')
+	buf.WriteString(formatBool(b.Gone))
+	buf.WriteByte('\n')
+}
+
+func parseFreeBike(line []byte) (*FreeBike, error) {
+	fields := strings.Split(string(line), ",")
+	// files written before is_gone was added have 7 fields
+	if len(fields) != 7 && len(fields) != 8 {
+		return nil, fmt.Errorf("expected 7 or 8 fields, got %d", len(fields))
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return nil, err
+	}
+	lat, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	lon, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return nil, err
+	}
+	return &FreeBike{
+		LastReported: t.In(tz),
+		ID:           fields[1],
+		Latitude:     lat,
+		Longitude:    lon,
+		VehicleType:  fields[4],
+		IsReserved:   fields[5] == "t",
+		IsDisabled:   fields[6] == "t",
+		Gone:         len(fields) == 8 && fields[7] == "t",
+	}, nil
+}
+
+// ForeachFreeBike calls f with every snapshot in r, a file written by
+// monitor-station-capacity.
+func ForeachFreeBike(r io.Reader, f func(*FreeBike) error) error {
+	tzOnce.Do(populateTZ)
+	bs := bufio.NewScanner(r)
+	for bs.Scan() {
+		bike, err := parseFreeBike(bs.Bytes())
+		if err != nil {
+			return fmt.Errorf("error parsing line %q: %v", bs.Text(), err)
+		}
+		if err := f(bike); err != nil {
+			return err
+		}
+	}
+	return bs.Err()
+}
+
+// LoadFreeBikes reads every snapshot in r.
+func LoadFreeBikes(r io.Reader) ([]*FreeBike, error) {
+	bikes := make([]*FreeBike, 0)
+	err := ForeachFreeBike(r, func(b *FreeBike) error {
+		bikes = append(bikes, b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bikes, nil
+}
+
+// LoadFreeBikesDir reads every "-free-bikes.csv" file in directory. Snapshots
+// are sorted by LastReported.
+func LoadFreeBikesDir(directory string) ([]*FreeBike, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	bikes := make([]*FreeBike, 0)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), "-free-bikes.csv") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fileBikes, err := LoadFreeBikes(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not load file %q: %v", file.Name(), err)
+		}
+		bikes = append(bikes, fileBikes...)
+	}
+	sort.SliceStable(bikes, func(i, j int) bool {
+		return bikes[i].LastReported.Before(bikes[j].LastReported)
+	})
+	return bikes, nil
+}
@@ -0,0 +1,276 @@
+// Package gbfstest runs a fake GBFS server, for use in tests and for working
+// on the site or the capacity monitor without a network connection.
+//
+// A Server serves gbfs.json, station_information.json and station_status.json
+// from fixtures, and free_bike_status.json if any free bikes are set. Station
+// statuses live on a timeline; station_status.json reports the most recent
+// status for each station at or before the server's clock. Scripting a state
+// change is a matter of adding a status with a later LastReported time and
+// moving the clock forward:
+//
+//	s := gbfstest.NewServer(stations, statuses)
+//	defer s.Close()
+//	s.Update(&gobike.StationStatus{ID: "3", LastReported: s.Now().Add(time.Minute)})
+//	s.Advance(time.Minute)
+//	c := client.NewClientWithHost(s.URL)
+//
+// Recorded capacity files can be replayed with NewReplayServer or
+// NewServerFromDir.
+package gbfstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+)
+
+// DefaultTTL is the TTL, in seconds, reported by a Feed unless it is
+// overridden.
+const DefaultTTL = 10
+
+// Feed is an http.Handler that serves GBFS feeds for a set of stations and
+// a timeline of station statuses. The zero value is not usable; create a Feed
+// with NewFeed.
+type Feed struct {
+	// TTL is reported in the "ttl" field of every response, in seconds.
+	TTL int
+	// If Step is nonzero, the clock advances by Step after every request for
+	// station_status.json, so a client polling the Feed sees the timeline play
+	// out.
+	Step time.Duration
+
+	mu       sync.Mutex
+	now      time.Time
+	stations []*gobike.Station
+	timeline []*gobike.StationStatus // sorted by LastReported
+	// cursor is the number of timeline entries at or before now that have
+	// been applied to current.
+	cursor  int
+	current map[string]*gobike.StationStatus
+	// bikes is nil if the Feed doesn't serve free_bike_status.json.
+	bikes []*gobike.FreeBike
+}
+
+// NewFeed returns a Feed serving the given stations and statuses. The clock
+// starts at start.
+func NewFeed(stations []*gobike.Station, statuses []*gobike.StationStatus, start time.Time) *Feed {
+	f := &Feed{
+		TTL:      DefaultTTL,
+		now:      start,
+		stations: stations,
+		current:  make(map[string]*gobike.StationStatus),
+	}
+	f.Update(statuses...)
+	return f
+}
+
+// Now returns the Feed's clock.
+func (f *Feed) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// SetTime moves the clock to t. t may be before the current time.
+func (f *Feed) SetTime(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setTime(t)
+}
+
+// Advance moves the clock forward by d.
+func (f *Feed) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setTime(f.now.Add(d))
+}
+
+func (f *Feed) setTime(t time.Time) {
+	if t.Before(f.now) {
+		f.reset()
+	}
+	f.now = t
+}
+
+// reset discards the computed state; it is rebuilt on the next call to
+// snapshot.
+func (f *Feed) reset() {
+	f.cursor = 0
+	f.current = make(map[string]*gobike.StationStatus)
+}
+
+// Update adds statuses to the timeline. A status becomes visible once the
+// clock reaches its LastReported time.
+func (f *Feed) Update(statuses ...*gobike.StationStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timeline = append(f.timeline, statuses...)
+	sort.SliceStable(f.timeline, func(i, j int) bool {
+		return f.timeline[i].LastReported.Before(f.timeline[j].LastReported)
+	})
+	f.reset()
+}
+
+// SetFreeBikes sets the vehicles reported by free_bike_status.json, replacing
+// any that were set before. Until SetFreeBikes is called, the Feed doesn't
+// serve or advertise free_bike_status.json.
+func (f *Feed) SetFreeBikes(bikes ...*gobike.FreeBike) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bikes = make([]*gobike.FreeBike, len(bikes))
+	copy(f.bikes, bikes)
+}
+
+// Snapshot returns the latest status for every station at the current time,
+// sorted by station ID.
+func (f *Feed) Snapshot() []*gobike.StationStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshot()
+}
+
+func (f *Feed) snapshot() []*gobike.StationStatus {
+	for f.cursor < len(f.timeline) && !f.timeline[f.cursor].LastReported.After(f.now) {
+		ss := f.timeline[f.cursor]
+		f.current[ss.ID] = ss
+		f.cursor++
+	}
+	statuses := make([]*gobike.StationStatus, 0, len(f.current))
+	for id := range f.current {
+		statuses = append(statuses, f.current[id])
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+type gbfsFeed struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type gbfsResponse struct {
+	LastUpdated int64                            `json:"last_updated"`
+	TTL         int                              `json:"ttl"`
+	Data        map[string]map[string][]gbfsFeed `json:"data"`
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (f *Feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := client.Response{LastUpdated: f.now, TTL: f.TTL}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/gbfs.json"):
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base := scheme + "://" + r.Host + strings.TrimSuffix(r.URL.Path, "/gbfs.json")
+		names := feedNames
+		if f.bikes != nil {
+			names = append(names[:len(names):len(names)], "free_bike_status")
+		}
+		feeds := make([]gbfsFeed, 0, len(names))
+		for _, name := range names {
+			feeds = append(feeds, gbfsFeed{Name: name, URL: base + "/" + name + ".json"})
+		}
+		writeJSON(w, &gbfsResponse{
+			LastUpdated: f.now.Unix(),
+			TTL:         f.TTL,
+			Data:        map[string]map[string][]gbfsFeed{"en": {"feeds": feeds}},
+		})
+	case strings.HasSuffix(r.URL.Path, "/station_information.json"):
+		writeJSON(w, &client.StationResponse{Response: resp, Stations: f.stations})
+	case strings.HasSuffix(r.URL.Path, "/station_status.json"):
+		writeJSON(w, &client.StationStatusResponse{Response: resp, Stations: f.snapshot()})
+		if f.Step != 0 {
+			f.setTime(f.now.Add(f.Step))
+		}
+	case strings.HasSuffix(r.URL.Path, "/free_bike_status.json") && f.bikes != nil:
+		writeJSON(w, &client.FreeBikeStatusResponse{Response: resp, Bikes: f.bikes})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var feedNames = []string{"station_information", "station_status"}
+
+// Server is a Feed running on an httptest.Server. Point a client at it with
+// client.NewClientWithHost(s.URL).
+type Server struct {
+	*httptest.Server
+	*Feed
+}
+
+// NewServer starts a Server serving the given stations and statuses. The
+// clock starts at the most recent LastReported time in statuses, so every
+// status is visible.
+func NewServer(stations []*gobike.Station, statuses []*gobike.StationStatus) *Server {
+	var start time.Time
+	for i := range statuses {
+		if statuses[i].LastReported.After(start) {
+			start = statuses[i].LastReported
+		}
+	}
+	return newServer(NewFeed(stations, statuses, start))
+}
+
+// NewReplayServer starts a Server that replays recorded statuses. The clock
+// starts at the earliest LastReported time in statuses; move it with Advance,
+// SetTime or Step.
+func NewReplayServer(stations []*gobike.Station, statuses []*gobike.StationStatus) *Server {
+	var start time.Time
+	for i := range statuses {
+		if start.IsZero() || statuses[i].LastReported.Before(start) {
+			start = statuses[i].LastReported
+		}
+	}
+	return newServer(NewFeed(stations, statuses, start))
+}
+
+func newServer(f *Feed) *Server {
+	return &Server{
+		Server: httptest.NewServer(f),
+		Feed:   f,
+	}
+}
+
+// NewServerFromDir starts a replay Server using station_information.json and
+// every capacity CSV (as written by monitor-station-capacity) in directory.
+func NewServerFromDir(directory string) (*Server, error) {
+	stations, err := client.LoadStations(filepath.Join(directory, "station_information.json"))
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := gobike.LoadCapacityDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayServer(stations, statuses), nil
+}
@@ -0,0 +1,158 @@
+package gbfstest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+)
+
+func TestServerFromDir(t *testing.T) {
+	s, err := NewServerFromDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := client.NewClientWithHost(s.URL)
+	stations, err := c.Stations.All(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations.Stations) != 5 {
+		t.Errorf("expected 5 stations, got %d", len(stations.Stations))
+	}
+	if stations.Stations[0].ID != 3 || stations.Stations[0].Capacity != 35 {
+		t.Errorf("bad first station: %#v", stations.Stations[0])
+	}
+	// the replay starts at the first recorded status.
+	status, err := c.Stations.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Stations) != 1 || status.Stations[0].ID != "3" {
+		t.Fatalf("expected one status for station 3, got %v", status.Stations)
+	}
+	if status.Stations[0].NumBikesAvailable != 20 {
+		t.Errorf("expected 20 bikes at station 3, got %d", status.Stations[0].NumBikesAvailable)
+	}
+	if status.TTL != DefaultTTL {
+		t.Errorf("expected TTL %d, got %d", DefaultTTL, status.TTL)
+	}
+
+	s.Advance(5 * time.Minute)
+	status, err = c.Stations.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Stations) != 5 {
+		t.Fatalf("expected 5 statuses after advancing, got %d", len(status.Stations))
+	}
+	for _, ss := range status.Stations {
+		if ss.ID == "3" && ss.NumBikesAvailable != 19 {
+			t.Errorf("expected latest status for station 3 to have 19 bikes, got %d", ss.NumBikesAvailable)
+		}
+	}
+}
+
+func TestScriptedUpdate(t *testing.T) {
+	start := time.Date(2018, time.August, 26, 0, 0, 0, 0, time.UTC)
+	s := NewServer(nil, []*gobike.StationStatus{
+		{ID: "3", NumBikesAvailable: 4, NumDocksAvailable: 31, LastReported: start, IsInstalled: true, IsRenting: true, IsReturning: true},
+	})
+	defer s.Close()
+	s.Update(&gobike.StationStatus{ID: "3", NumBikesAvailable: 0, NumDocksAvailable: 35, LastReported: start.Add(time.Minute), IsInstalled: true, IsRenting: true, IsReturning: true})
+	c := client.NewClientWithHost(s.URL)
+
+	status, err := c.Stations.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status.Stations[0].NumBikesAvailable; got != 4 {
+		t.Errorf("update should not be visible yet, got %d bikes", got)
+	}
+	if !status.LastUpdated.Equal(start) {
+		t.Errorf("expected last_updated %v, got %v", start, status.LastUpdated)
+	}
+	s.Advance(time.Minute)
+	status, err = c.Stations.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status.Stations[0].NumBikesAvailable; got != 0 {
+		t.Errorf("expected station to be empty after update, got %d bikes", got)
+	}
+	if !status.Stations[0].IsReturning {
+		t.Errorf("expected station to be returning")
+	}
+
+	// rewinding the clock hides the update again.
+	s.SetTime(start)
+	if got := s.Snapshot()[0].NumBikesAvailable; got != 4 {
+		t.Errorf("expected 4 bikes after rewinding, got %d", got)
+	}
+}
+
+func TestStep(t *testing.T) {
+	s, err := NewServerFromDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Step = time.Minute
+	start := s.Now()
+	c := client.NewClientWithHost(s.URL)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Stations.Status(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := s.Now().Sub(start); got != 3*time.Minute {
+		t.Errorf("expected clock to advance 3m, advanced %v", got)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	s := NewServer(nil, nil)
+	defer s.Close()
+	req, err := http.NewRequest("GET", s.URL+"/gbfs.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.NewClientWithHost(s.URL)
+	body := new(gbfsResponse)
+	if err := c.Client.Do(req, body); err != nil {
+		t.Fatal(err)
+	}
+	feeds := body.Data["en"]["feeds"]
+	if len(feeds) != len(feedNames) {
+		t.Fatalf("expected %d feeds, got %d", len(feedNames), len(feeds))
+	}
+	if want := s.URL + "/station_status.json"; feeds[1].URL != want {
+		t.Errorf("expected station_status URL %q, got %q", want, feeds[1].URL)
+	}
+}
+
+func TestFreeBikes(t *testing.T) {
+	s := NewServer(nil, nil)
+	defer s.Close()
+	c := client.NewClientWithHost(s.URL)
+	if _, err := c.FreeBikes.Status(context.Background()); err == nil {
+		t.Fatal("expected an error before any free bikes are set")
+	}
+	reported := time.Unix(1535241600, 0)
+	s.SetFreeBikes(&gobike.FreeBike{ID: "abc", Latitude: 37.78, Longitude: -122.41, VehicleType: "ebike", IsReserved: true, LastReported: reported})
+	resp, err := c.FreeBikes.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Bikes) != 1 {
+		t.Fatalf("expected one bike, got %d", len(resp.Bikes))
+	}
+	b := resp.Bikes[0]
+	if b.ID != "abc" || b.Latitude != 37.78 || b.VehicleType != "ebike" || !b.IsReserved || b.IsDisabled || !b.LastReported.Equal(reported) {
+		t.Errorf("bad bike: %#v", b)
+	}
+}
@@ -77,6 +77,15 @@ type City struct {
 	points [][][][]float64
 }
 
+// Cities are the cities with bike share stations, in alphabetical order.
+var Cities = [...]*City{
+	Berkeley,
+	Emeryville,
+	Oakland,
+	SanJose,
+	SF,
+}
+
 var SFDistricts = [...]*City{
 	SFD1,
 	SFD2,
@@ -370,6 +370,35 @@ type StationStatus struct {
 	IsReturning        bool      `json:"is_returning"`
 }
 
+// HasBike reports whether a rider could rent a bike from the station.
+func (ss *StationStatus) HasBike() bool {
+	return ss.IsInstalled && ss.IsRenting && ss.NumBikesAvailable > 0
+}
+
+// HasDock reports whether a rider could return a bike to the station.
+func (ss *StationStatus) HasDock() bool {
+	return ss.IsInstalled && ss.IsReturning && ss.NumDocksAvailable > 0
+}
+
+// Usable reports whether the station is installed, renting and returning,
+// with at least one bike and one dock.
+func (ss *StationStatus) Usable() bool {
+	return ss.HasBike() && ss.HasDock()
+}
+
+// Empty reports whether the station is open for rentals but has no bikes.
+// Stations that are not installed or not renting are out of service, not
+// empty.
+func (ss *StationStatus) Empty() bool {
+	return ss.IsInstalled && ss.IsRenting && ss.NumBikesAvailable == 0
+}
+
+// Full reports whether the station is open for returns but has no docks
+// free.
+func (ss *StationStatus) Full() bool {
+	return ss.IsInstalled && ss.IsReturning && ss.NumDocksAvailable == 0
+}
+
 func parseInt16(line []byte) ([]byte, int16, error) {
 	idx := bytes.IndexByte(line, ',')
 	if idx == -1 {
@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+const (
+	// SlotDuration is the length of one slot of an Availability week.
+	SlotDuration = 15 * time.Minute
+	// SlotsPerWeek is the number of slots in a week.
+	SlotsPerWeek = 7 * 24 * int(time.Hour/SlotDuration)
+)
+
+// maxStatusAge is the longest we assume a station's status holds after it was
+// reported. Stations report every few minutes, so a longer gap means the
+// monitor wasn't running, and we don't know what happened.
+const maxStatusAge = time.Hour
+
+// Availability is how likely a station is to have a bike to rent, and a dock
+// to return one to, in each 15 minute slot of a typical week. Slot 0 starts at
+// midnight on Sunday, in the Bay Area.
+type Availability struct {
+	// Observed is how long the station's status was known in each slot.
+	Observed [SlotsPerWeek]time.Duration
+	// Bike and Dock are how long, during Observed, the station had a bike to
+	// rent and a dock to return a bike to. EBike is how long it had an
+	// e-bike to rent.
+	Bike  [SlotsPerWeek]time.Duration
+	Dock  [SlotsPerWeek]time.Duration
+	EBike [SlotsPerWeek]time.Duration
+}
+
+// Slot returns the slot of the week t falls in.
+func Slot(t time.Time) int {
+	tzOnce.Do(populateTZ)
+	t = t.In(tz)
+	return (int(t.Weekday())*24+t.Hour())*int(time.Hour/SlotDuration) + t.Minute()/int(SlotDuration/time.Minute)
+}
+
+// SlotStart returns the day of the week and the time of day (since midnight)
+// that slot starts at.
+func SlotStart(slot int) (time.Weekday, time.Duration) {
+	perDay := SlotsPerWeek / 7
+	return time.Weekday(slot / perDay), time.Duration(slot%perDay) * SlotDuration
+}
+
+// BikeProbability returns the probability the station had at least one bike
+// to rent in slot. ok is false if the station's status was never known then.
+func (a *Availability) BikeProbability(slot int) (p float64, ok bool) {
+	if a.Observed[slot] == 0 {
+		return 0, false
+	}
+	return float64(a.Bike[slot]) / float64(a.Observed[slot]), true
+}
+
+// DockProbability returns the probability the station had at least one dock
+// to return a bike to in slot. ok is false if the station's status was never
+// known then.
+func (a *Availability) DockProbability(slot int) (p float64, ok bool) {
+	if a.Observed[slot] == 0 {
+		return 0, false
+	}
+	return float64(a.Dock[slot]) / float64(a.Observed[slot]), true
+}
+
+// EBikeProbability returns the probability the station had at least one
+// e-bike to rent in slot. ok is false if the station's status was never
+// known then.
+func (a *Availability) EBikeProbability(slot int) (p float64, ok bool) {
+	if a.Observed[slot] == 0 {
+		return 0, false
+	}
+	return float64(a.EBike[slot]) / float64(a.Observed[slot]), true
+}
+
+// add records that the station was in status ss from start to end, splitting
+// the time between the slots it covers.
+func (a *Availability) add(ss *gobike.StationStatus, start, end time.Time) {
+	hasBike := ss.HasBike()
+	hasDock := ss.HasDock()
+	hasEBike := HasEBike(ss)
+	for t := start; t.Before(end); {
+		// Bay Area offsets are whole hours, so slots line up with UTC.
+		next := t.Truncate(SlotDuration).Add(SlotDuration)
+		if next.After(end) {
+			next = end
+		}
+		slot := Slot(t)
+		d := next.Sub(t)
+		a.Observed[slot] += d
+		if hasBike {
+			a.Bike[slot] += d
+		}
+		if hasDock {
+			a.Dock[slot] += d
+		}
+		if hasEBike {
+			a.EBike[slot] += d
+		}
+		t = next
+	}
+}
+
+// StationAvailability computes a station's Availability between start and
+// end, from its statuses sorted by time, as they are by StatusMap. Each
+// status is assumed to hold until the next one, or for an hour, whichever
+// comes first.
+func StationAvailability(statuses []*gobike.StationStatus, start, end time.Time) *Availability {
+	a := new(Availability)
+	eachSpan(statuses, start, end, a.add)
+	return a
+}
+
+// eachSpan calls f with each of statuses, sorted by time, and the part of
+// start to end that it held for: until the next status, or for maxStatusAge,
+// whichever comes first. Statuses that held for none of it are skipped.
+func eachSpan(statuses []*gobike.StationStatus, start, end time.Time, f func(ss *gobike.StationStatus, from, to time.Time)) {
+	for i, ss := range statuses {
+		from := ss.LastReported
+		to := from.Add(maxStatusAge)
+		if i+1 < len(statuses) && statuses[i+1].LastReported.Before(to) {
+			to = statuses[i+1].LastReported
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if from.Before(to) {
+			f(ss, from, to)
+		}
+	}
+}
+
+// AvailabilityMap computes the Availability of every station in statuses, a
+// map like the one returned by StatusMap, over the lookback before end.
+func AvailabilityMap(statuses map[string][]*gobike.StationStatus, end time.Time, lookback time.Duration) map[string]*Availability {
+	result := make(map[string]*Availability, len(statuses))
+	for id := range statuses {
+		result[id] = StationAvailability(statuses[id], end.Add(-lookback), end)
+	}
+	return result
+}
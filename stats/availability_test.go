@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestSlot(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	// a Monday
+	if s := Slot(time.Date(2018, time.August, 27, 8, 40, 0, 0, tz)); s != 96+32+2 {
+		t.Errorf("bad slot: %d", s)
+	}
+	day, start := SlotStart(96 + 32 + 2)
+	if day != time.Monday || start != 8*time.Hour+30*time.Minute {
+		t.Errorf("bad slot start: %s %v", day, start)
+	}
+	if s := Slot(time.Date(2018, time.September, 1, 23, 59, 0, 0, tz)); s != SlotsPerWeek-1 {
+		t.Errorf("expected the last slot, got %d", s)
+	}
+}
+
+func TestStationAvailability(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	statuses := []*gobike.StationStatus{
+		// Monday the 20th: empty for the first five minutes of 8am
+		testStatus("1", augustAt(20, 8, 0), 0, 10),
+		testStatus("1", augustAt(20, 8, 5), 3, 7),
+		// then nothing until Monday the 27th, at the same time
+		testStatus("1", augustAt(27, 8, 10), 0, 10),
+		testStatus("1", augustAt(27, 8, 20), 0, 10),
+	}
+	a := StationAvailability(statuses, augustAt(20, 0, 0), augustAt(28, 0, 0))
+	slot := Slot(augustAt(20, 8, 0))
+	// 15 minutes on the 20th and 5 on the 27th
+	if a.Observed[slot] != 20*time.Minute {
+		t.Errorf("expected 20 minutes observed, got %v", a.Observed[slot])
+	}
+	p, ok := a.BikeProbability(slot)
+	if !ok || p != 0.5 {
+		t.Errorf("expected a 50%% chance of a bike, got %v", p)
+	}
+	if p, ok := a.DockProbability(slot); !ok || p != 1 {
+		t.Errorf("expected a dock the whole time, got %v", p)
+	}
+	// statuses last an hour: 8:05 to 9:05 on the 20th, 8:20 to 9:20 on the
+	// 27th
+	if p, _ := a.BikeProbability(slot + 4); a.Observed[slot+4] != 20*time.Minute || p != 0.25 {
+		t.Errorf("expected statuses to expire after an hour, got %v observed", a.Observed[slot+4])
+	}
+	if _, ok := a.BikeProbability(slot + 6); ok {
+		t.Error("expected no data at 9:30")
+	}
+	if _, ok := a.BikeProbability(Slot(augustAt(21, 12, 0))); ok {
+		t.Error("expected no data on Tuesday")
+	}
+	// lookback cuts off the first week
+	a = StationAvailability(statuses, augustAt(27, 0, 0), augustAt(28, 0, 0))
+	if p, _ := a.BikeProbability(slot); p != 0 || a.Observed[slot] != 5*time.Minute {
+		t.Errorf("expected an empty station, got %v over %v", p, a.Observed[slot])
+	}
+}
+
+func TestAvailabilityMap(t *testing.T) {
+	statuses, err := gobike.LoadCapacityDir(filepath.Join("testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := time.Date(2018, time.August, 27, 0, 0, 0, 0, time.UTC)
+	m := AvailabilityMap(StatusMap(statuses), end, 7*24*time.Hour)
+	a, ok := m["3"]
+	if !ok {
+		t.Fatal("no availability for station 3")
+	}
+	var observed time.Duration
+	for i := range a.Observed {
+		observed += a.Observed[i]
+		if a.Bike[i] > a.Observed[i] || a.Dock[i] > a.Observed[i] || a.EBike[i] > a.Bike[i] {
+			t.Fatalf("slot %d: more available time than observed", i)
+		}
+	}
+	if observed < 23*time.Hour || observed > 24*time.Hour {
+		t.Errorf("expected about a day of data, got %v", observed)
+	}
+}
@@ -0,0 +1,297 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// Granularity is the size of the buckets Aggregate groups trips into.
+type Granularity int
+
+const (
+	Hour Granularity = iota
+	Day
+	Week
+	Month
+	Quarter
+)
+
+var granularityNames = [...]string{"hour", "day", "week", "month", "quarter"}
+
+func (g Granularity) String() string {
+	if g < 0 || int(g) >= len(granularityNames) {
+		return fmt.Sprintf("Granularity(%d)", int(g))
+	}
+	return granularityNames[g]
+}
+
+// ParseGranularity parses the name of a Granularity, like "week".
+func ParseGranularity(s string) (Granularity, error) {
+	for i, name := range granularityNames {
+		if s == name {
+			return Granularity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown granularity %q", s)
+}
+
+// Truncate returns the start of the bucket that t falls in, in the Bay Area
+// time zone. Weeks start on weekStart; it is ignored for other granularities.
+func (g Granularity) Truncate(t time.Time, weekStart time.Weekday) time.Time {
+	tzOnce.Do(populateTZ)
+	t = t.In(tz)
+	switch g {
+	case Hour:
+		return t.Truncate(time.Hour)
+	case Day:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, tz)
+	case Week:
+		offset := (int(t.Weekday()) - int(weekStart) + 7) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, tz)
+	case Month:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, tz)
+	case Quarter:
+		month := t.Month() - (t.Month()-1)%3
+		return time.Date(t.Year(), month, 1, 0, 0, 0, 0, tz)
+	default:
+		panic("stats: unknown granularity " + g.String())
+	}
+}
+
+// Next returns the start of the bucket after the one starting at start.
+func (g Granularity) Next(start time.Time) time.Time {
+	switch g {
+	case Hour:
+		return start.Add(time.Hour)
+	case Day:
+		return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, tz)
+	case Week:
+		return time.Date(start.Year(), start.Month(), start.Day()+7, 0, 0, 0, 0, tz)
+	case Month:
+		return time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, tz)
+	case Quarter:
+		return time.Date(start.Year(), start.Month()+3, 1, 0, 0, 0, 0, tz)
+	default:
+		panic("stats: unknown granularity " + g.String())
+	}
+}
+
+// An Accumulator reduces the trips in one bucket to a number.
+type Accumulator interface {
+	Add(*gobike.Trip)
+	// Value returns the result. It should return 0, not NaN, if no trips were
+	// added, since buckets without trips are still reported.
+	Value() float64
+}
+
+// A Reducer returns a new Accumulator for each bucket.
+type Reducer func() Accumulator
+
+type countAcc int
+
+func (c *countAcc) Add(*gobike.Trip) { *c++ }
+func (c *countAcc) Value() float64   { return float64(*c) }
+
+// Count counts the trips in a bucket.
+func Count() Reducer {
+	return func() Accumulator { return new(countAcc) }
+}
+
+type distinctAcc struct {
+	key  func(*gobike.Trip) string
+	seen map[string]bool
+}
+
+func (d *distinctAcc) Add(t *gobike.Trip) { d.seen[d.key(t)] = true }
+func (d *distinctAcc) Value() float64     { return float64(len(d.seen)) }
+
+// Distinct counts the distinct values of key in a bucket, for example the
+// number of different bikes.
+func Distinct(key func(*gobike.Trip) string) Reducer {
+	return func() Accumulator {
+		return &distinctAcc{key: key, seen: make(map[string]bool)}
+	}
+}
+
+type sumAcc struct {
+	value func(*gobike.Trip) float64
+	sum   float64
+	count int
+}
+
+func (s *sumAcc) Add(t *gobike.Trip) {
+	s.sum += s.value(t)
+	s.count++
+}
+
+func (s *sumAcc) Value() float64 { return s.sum }
+
+type meanAcc struct{ sumAcc }
+
+func (m *meanAcc) Value() float64 {
+	if m.count == 0 {
+		return 0
+	}
+	return m.sum / float64(m.count)
+}
+
+// Sum adds up value for every trip in a bucket.
+func Sum(value func(*gobike.Trip) float64) Reducer {
+	return func() Accumulator { return &sumAcc{value: value} }
+}
+
+// Mean averages value over the trips in a bucket.
+func Mean(value func(*gobike.Trip) float64) Reducer {
+	return func() Accumulator { return &meanAcc{sumAcc{value: value}} }
+}
+
+type percentileAcc struct {
+	value  func(*gobike.Trip) float64
+	p      float64
+	values []float64
+}
+
+func (pa *percentileAcc) Add(t *gobike.Trip) {
+	pa.values = append(pa.values, pa.value(t))
+}
+
+func (pa *percentileAcc) Value() float64 {
+	sort.Float64s(pa.values)
+	return percentile(pa.values, pa.p)
+}
+
+// percentile returns the pth percentile (0-100) of sorted values,
+// interpolating between the closest ranks.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(values)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return values[lo] + (values[hi]-values[lo])*(rank-float64(lo))
+}
+
+// Percentile returns the pth percentile (0-100) of value over the trips in a
+// bucket, interpolating between the closest ranks.
+func Percentile(value func(*gobike.Trip) float64, p float64) Reducer {
+	if p < 0 || p > 100 {
+		panic("stats: percentile must be between 0 and 100")
+	}
+	return func() Accumulator { return &percentileAcc{value: value, p: p} }
+}
+
+type ratioAcc struct {
+	num, den Accumulator
+}
+
+func (r *ratioAcc) Add(t *gobike.Trip) {
+	r.num.Add(t)
+	r.den.Add(t)
+}
+
+func (r *ratioAcc) Value() float64 {
+	den := r.den.Value()
+	if den == 0 {
+		return 0
+	}
+	return r.num.Value() / den
+}
+
+// Ratio divides num by den in each bucket, for example trips per bike.
+func Ratio(num, den Reducer) Reducer {
+	return func() Accumulator { return &ratioAcc{num: num(), den: den()} }
+}
+
+// Query describes how Aggregate should bucket and reduce trips.
+type Query struct {
+	Granularity Granularity
+	// WeekStart is the first day of a Week bucket. The zero value is Sunday.
+	WeekStart time.Weekday
+	// Filter, if set, is called with every trip, in order; trips it returns
+	// false for are skipped.
+	Filter func(*gobike.Trip) bool
+	// If SkipSpecialDays is true, trips that start on a holiday or an event
+	// in the calendar passed to SetCalendar are skipped.
+	SkipSpecialDays bool
+	// Reducer turns the trips in a bucket into a number. Defaults to Count.
+	Reducer Reducer
+	// Buckets that start at or after End are dropped. If End is zero,
+	// Aggregate drops the last bucket unless the trip data runs through the
+	// end of it, so a partial week doesn't look like a drop in ridership.
+	End time.Time
+}
+
+// completeBefore returns the start of the first bucket that the trips don't
+// fully cover. Trip data is published a day at a time, so the day of the
+// latest trip is assumed to be complete.
+func completeBefore(trips []*gobike.Trip, g Granularity, weekStart time.Weekday) time.Time {
+	tzOnce.Do(populateTZ)
+	var latest time.Time
+	for i := range trips {
+		if trips[i].StartTime.After(latest) {
+			latest = trips[i].StartTime
+		}
+	}
+	if g == Hour {
+		return g.Truncate(latest, weekStart)
+	}
+	latest = latest.In(tz)
+	nextDay := time.Date(latest.Year(), latest.Month(), latest.Day()+1, 0, 0, 0, 0, tz)
+	return g.Truncate(nextDay, weekStart)
+}
+
+// Aggregate groups trips into buckets by start time and reduces each bucket to
+// a number. The series runs from the first bucket with a trip to the last
+// bucket before q.End; buckets without trips are included with the value of
+// an empty Accumulator, usually 0.
+func Aggregate(trips []*gobike.Trip, q Query) TimeSeries {
+	tzOnce.Do(populateTZ)
+	reducer := q.Reducer
+	if reducer == nil {
+		reducer = Count()
+	}
+	end := q.End
+	if end.IsZero() {
+		end = completeBefore(trips, q.Granularity, q.WeekStart)
+	}
+	buckets := make(map[int64]Accumulator)
+	var earliest time.Time
+	for i := range trips {
+		if q.Filter != nil && !q.Filter(trips[i]) {
+			continue
+		}
+		if q.SkipSpecialDays && IsSpecialDay(trips[i].StartTime) {
+			continue
+		}
+		start := q.Granularity.Truncate(trips[i].StartTime, q.WeekStart)
+		if !start.Before(end) {
+			continue
+		}
+		acc, ok := buckets[start.Unix()]
+		if !ok {
+			acc = reducer()
+			buckets[start.Unix()] = acc
+		}
+		acc.Add(trips[i])
+		if earliest.IsZero() || start.Before(earliest) {
+			earliest = start
+		}
+	}
+	result := make(TimeSeries, 0)
+	if len(buckets) == 0 {
+		return result
+	}
+	for t := earliest; t.Before(end); t = q.Granularity.Next(t) {
+		acc, ok := buckets[t.Unix()]
+		if !ok {
+			acc = reducer()
+		}
+		result = append(result, &TimeStat{Date: t, Data: acc.Value()})
+	}
+	return result
+}
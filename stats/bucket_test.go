@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestGranularityTruncate(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	// a Wednesday
+	ts := time.Date(2018, time.August, 15, 13, 45, 0, 0, tz)
+	tests := []struct {
+		g         Granularity
+		weekStart time.Weekday
+		want      time.Time
+	}{
+		{Hour, time.Sunday, time.Date(2018, time.August, 15, 13, 0, 0, 0, tz)},
+		{Day, time.Sunday, time.Date(2018, time.August, 15, 0, 0, 0, 0, tz)},
+		{Week, time.Sunday, time.Date(2018, time.August, 12, 0, 0, 0, 0, tz)},
+		{Week, time.Monday, time.Date(2018, time.August, 13, 0, 0, 0, 0, tz)},
+		{Week, time.Thursday, time.Date(2018, time.August, 9, 0, 0, 0, 0, tz)},
+		{Month, time.Sunday, time.Date(2018, time.August, 1, 0, 0, 0, 0, tz)},
+		{Quarter, time.Sunday, time.Date(2018, time.July, 1, 0, 0, 0, 0, tz)},
+	}
+	for _, tt := range tests {
+		if got := tt.g.Truncate(ts, tt.weekStart); !got.Equal(tt.want) {
+			t.Errorf("%s (week start %s): got %v, want %v", tt.g, tt.weekStart, got, tt.want)
+		}
+	}
+	// the day DST ends is 25 hours long
+	fallBack := time.Date(2018, time.November, 4, 0, 0, 0, 0, tz)
+	if next := Day.Next(fallBack); next.Sub(fallBack) != 25*time.Hour {
+		t.Errorf("expected a 25 hour day, got %v", next.Sub(fallBack))
+	}
+	if g, err := ParseGranularity("quarter"); err != nil || g != Quarter {
+		t.Errorf("ParseGranularity: got %v, %v", g, err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	trips := []*gobike.Trip{
+		// week of the 5th
+		{StartTime: augustAt(5, 8, 0), BikeID: 1, Duration: 10 * time.Minute},
+		{StartTime: augustAt(6, 8, 0), BikeID: 1, Duration: 20 * time.Minute},
+		{StartTime: augustAt(7, 8, 0), BikeID: 2, Duration: 30 * time.Minute},
+		// nothing in the week of the 12th
+		// week of the 19th
+		{StartTime: augustAt(25, 8, 0), BikeID: 3, Duration: 40 * time.Minute, BikeShareForAllTrip: true},
+		// week of the 26th is partial
+		{StartTime: augustAt(27, 8, 0), BikeID: 3, Duration: 50 * time.Minute},
+	}
+	series := TripsPerWeek(trips)
+	want := []float64{3, 0, 1}
+	if len(series) != len(want) {
+		t.Fatalf("expected %d weeks, got %d", len(want), len(series))
+	}
+	for i := range want {
+		if series[i].Data != want[i] {
+			t.Errorf("week %d: got %v, want %v", i, series[i].Data, want[i])
+		}
+	}
+	if !series[1].Date.Equal(augustAt(12, 0, 0)) {
+		t.Errorf("expected the empty week to start on the 12th, got %v", series[1].Date)
+	}
+	if tpb := TripsPerBikePerWeek(trips); tpb[0].Data != 1.5 || tpb[1].Data != 0 {
+		t.Errorf("bad trips per bike: %v, %v", tpb[0].Data, tpb[1].Data)
+	}
+	if bs4a := BikeShareForAllTripsPerWeek(trips); len(bs4a) != 1 || bs4a[0].Data != 1 {
+		t.Errorf("expected one Bike Share for All week, got %d", len(bs4a))
+	}
+	// Monday weeks put the 5th in the week of July 30th
+	monday := Aggregate(trips, Query{Granularity: Week, WeekStart: time.Monday})
+	if !monday[0].Date.Equal(time.Date(2018, time.July, 30, 0, 0, 0, 0, tz)) || monday[0].Data != 1 {
+		t.Errorf("bad first Monday week: %v %v", monday[0].Date, monday[0].Data)
+	}
+	// the 27th is the last day of data, so it's a complete day
+	daily := TripsPer(trips, Day)
+	if len(daily) != 23 || daily.Last() != 1 {
+		t.Errorf("expected 23 days ending with one trip, got %d ending with %v", len(daily), daily.Last())
+	}
+	minutes := func(t *gobike.Trip) float64 { return t.Duration.Minutes() }
+	month := Aggregate(trips, Query{Granularity: Month, Reducer: Mean(minutes)})
+	if len(month) != 0 {
+		t.Errorf("expected the partial month to be dropped, got %d months", len(month))
+	}
+	month = Aggregate(trips, Query{Granularity: Month, Reducer: Mean(minutes), End: time.Date(2018, time.September, 1, 0, 0, 0, 0, tz)})
+	if len(month) != 1 || month[0].Data != 30 {
+		t.Errorf("expected a mean of 30 minutes, got %v", month)
+	}
+	median := Aggregate(trips, Query{Granularity: Quarter, Reducer: Percentile(minutes, 50), End: time.Date(2018, time.October, 1, 0, 0, 0, 0, tz)})
+	if len(median) != 1 || median[0].Data != 30 {
+		t.Errorf("expected a median of 30 minutes, got %v", median)
+	}
+	p90 := Aggregate(trips, Query{Granularity: Quarter, Reducer: Percentile(minutes, 90), End: time.Date(2018, time.October, 1, 0, 0, 0, 0, tz)})
+	if p90[0].Data != 46 {
+		t.Errorf("expected a 90th percentile of 46 minutes, got %v", p90[0].Data)
+	}
+	sum := Aggregate(trips, Query{Granularity: Quarter, Reducer: Sum(minutes), End: time.Date(2018, time.October, 1, 0, 0, 0, 0, tz)})
+	if sum[0].Data != 150 {
+		t.Errorf("expected 150 minutes, got %v", sum[0].Data)
+	}
+	if empty := TripsPerWeek(nil); len(empty) != 0 || empty.Last() != 0 {
+		t.Errorf("expected an empty series, got %d points", len(empty))
+	}
+}
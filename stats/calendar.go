@@ -0,0 +1,239 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a day when ridership doesn't look like a normal day: a holiday, or
+// something like Bay to Breakers or Bike to Work Day.
+type Event struct {
+	Name string
+	// Date is midnight at the start of the day, in the Bay Area.
+	Date time.Time
+	// Holiday is true for the holidays returned by Holidays, and false for
+	// events from a calendar file.
+	Holiday bool
+}
+
+// dayKey identifies the day t falls on in the Bay Area, like 20180528.
+func dayKey(t time.Time) int {
+	year, month, day := t.In(tz).Date()
+	return year*10000 + int(month)*100 + day
+}
+
+// Computing the holidays for a year is slow enough to be worth caching, since
+// stats check them for every trip.
+var holidayCache = struct {
+	sync.Mutex
+	years map[int]bool
+	days  map[int][]Event
+}{years: make(map[int]bool), days: make(map[int][]Event)}
+
+// holidaysOn returns the holidays observed on the day t falls on.
+func holidaysOn(t time.Time) []Event {
+	tzOnce.Do(populateTZ)
+	holidayCache.Lock()
+	defer holidayCache.Unlock()
+	year := t.In(tz).Year()
+	// New Year's Day can be observed on December 31st of the year before.
+	for _, y := range []int{year, year + 1} {
+		if holidayCache.years[y] {
+			continue
+		}
+		for _, h := range Holidays(y) {
+			key := dayKey(h.Date)
+			holidayCache.days[key] = append(holidayCache.days[key], Event{Name: h.Name, Date: h.Date, Holiday: true})
+		}
+		holidayCache.years[y] = true
+	}
+	return holidayCache.days[dayKey(t)]
+}
+
+// Calendar is the set of days that skew ridership: the holidays returned by
+// Holidays, plus any events loaded from a file with LoadCalendar. A nil
+// Calendar has only the holidays.
+type Calendar struct {
+	events map[int][]Event
+}
+
+// NewCalendar returns a Calendar with the holidays and events.
+func NewCalendar(events []Event) *Calendar {
+	tzOnce.Do(populateTZ)
+	c := &Calendar{events: make(map[int][]Event)}
+	for _, e := range events {
+		key := dayKey(e.Date)
+		c.events[key] = append(c.events[key], e)
+	}
+	return c
+}
+
+// ParseCalendar reads a calendar of events in CSV format, one event per line:
+// a date, an optional last date for events that run more than a day, and a
+// name. Lines that start with a # are ignored.
+//
+//	2018-05-20,Bay to Breakers
+//	2018-08-10,2018-08-12,Outside Lands
+func ParseCalendar(r io.Reader) (*Calendar, error) {
+	tzOnce.Do(populateTZ)
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	events := make([]Event, 0)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != 2 && len(record) != 3 {
+			return nil, fmt.Errorf("calendar: expected 2 or 3 fields, got %d: %q", len(record), strings.Join(record, ","))
+		}
+		start, err := time.ParseInLocation("2006-01-02", record[0], tz)
+		if err != nil {
+			return nil, fmt.Errorf("calendar: bad date: %v", err)
+		}
+		last := start
+		if len(record) == 3 {
+			last, err = time.ParseInLocation("2006-01-02", record[1], tz)
+			if err != nil {
+				return nil, fmt.Errorf("calendar: bad date: %v", err)
+			}
+			if last.Before(start) {
+				return nil, fmt.Errorf("calendar: %s ends before it starts", record[2])
+			}
+		}
+		name := strings.TrimSpace(record[len(record)-1])
+		if name == "" {
+			return nil, fmt.Errorf("calendar: no name for the event on %s", record[0])
+		}
+		for day := start; !day.After(last); day = Day.Next(day) {
+			events = append(events, Event{Name: name, Date: day})
+		}
+	}
+	return NewCalendar(events), nil
+}
+
+// LoadCalendar reads a calendar file; see ParseCalendar for the format.
+func LoadCalendar(filename string) (*Calendar, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCalendar(f)
+}
+
+// On returns the holidays and events on the day t falls on, holidays first.
+func (c *Calendar) On(t time.Time) []Event {
+	holidays := holidaysOn(t)
+	if c == nil || len(c.events) == 0 {
+		return holidays
+	}
+	events := c.events[dayKey(t)]
+	if len(events) == 0 {
+		return holidays
+	}
+	result := make([]Event, 0, len(holidays)+len(events))
+	result = append(result, holidays...)
+	return append(result, events...)
+}
+
+// Between returns the holidays and events on the days from the one start falls
+// on up to end, in order.
+func (c *Calendar) Between(start, end time.Time) []Event {
+	tzOnce.Do(populateTZ)
+	result := make([]Event, 0)
+	for day := Day.Truncate(start, time.Sunday); day.Before(end); day = Day.Next(day) {
+		result = append(result, c.On(day)...)
+	}
+	return result
+}
+
+// The calendar used by every stat that leaves out or flags special days. It's
+// set once at startup, before any stats are computed.
+var calendar *Calendar
+
+// SetCalendar sets the calendar of events that stats leave out of weekday
+// averages and mark on charts, in addition to the holidays. It isn't safe to
+// call while stats are being computed.
+func SetCalendar(c *Calendar) {
+	calendar = c
+}
+
+// IsSpecialDay reports whether t falls on a holiday, or on an event in the
+// calendar passed to SetCalendar.
+func IsSpecialDay(t time.Time) bool {
+	return len(calendar.On(t)) > 0
+}
+
+// SpecialDays returns the holidays and events on the days from the one start
+// falls on up to end, in order.
+func SpecialDays(start, end time.Time) []Event {
+	return calendar.Between(start, end)
+}
+
+// workday reports whether day is a weekday that isn't a special day.
+func workday(day time.Time) bool {
+	day = day.In(tz)
+	return day.Weekday() != time.Saturday && day.Weekday() != time.Sunday && !IsSpecialDay(day)
+}
+
+// Annotation marks a special day in a bucket of a TimeSeries.
+type Annotation struct {
+	// Bucket is the start of the bucket the day is in, like TimeStat.Date.
+	Bucket time.Time
+	Event
+}
+
+// Annotations marshal to JSON for flot charts. "x" is the bucket, and "from"
+// and "to" the start and end of the day, in the same units as a marshaled
+// TimeSeries.
+type Annotations []*Annotation
+
+func (a Annotations) MarshalJSON() ([]byte, error) {
+	type annotation struct {
+		X    float64 `json:"x"`
+		From float64 `json:"from"`
+		To   float64 `json:"to"`
+		Name string  `json:"name"`
+	}
+	// like TimeSeries.MarshalJSON, shift to local time so the chart shows
+	// Bay Area dates.
+	ms := func(t time.Time) float64 {
+		_, offsetSeconds := t.Zone()
+		return float64((t.Unix() + int64(offsetSeconds)) * 1000)
+	}
+	result := make([]annotation, len(a))
+	for i := range a {
+		result[i] = annotation{
+			X:    ms(a[i].Bucket),
+			From: ms(a[i].Date),
+			To:   ms(Day.Next(a[i].Date)),
+			Name: a[i].Name,
+		}
+	}
+	return json.Marshal(result)
+}
+
+// Annotate returns the special days in each bucket of series, which has
+// buckets of size g, in order.
+func Annotate(series TimeSeries, g Granularity) Annotations {
+	tzOnce.Do(populateTZ)
+	result := make(Annotations, 0)
+	for _, stat := range series {
+		for _, e := range SpecialDays(stat.Date, g.Next(stat.Date)) {
+			result = append(result, &Annotation{Bucket: stat.Date, Event: e})
+		}
+	}
+	return result
+}
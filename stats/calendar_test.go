@@ -0,0 +1,138 @@
+package stats
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+const testCalendar = `# comments are ignored
+2018-05-20,Bay to Breakers
+2018-08-10, 2018-08-12, Outside Lands
+`
+
+func TestParseCalendar(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	c, err := ParseCalendar(strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := c.Between(time.Date(2018, time.August, 1, 0, 0, 0, 0, tz), time.Date(2018, time.September, 4, 0, 0, 0, 0, tz))
+	var names []string
+	for _, e := range events {
+		names = append(names, e.Date.Format("Jan 2 ")+e.Name)
+	}
+	want := "Aug 10 Outside Lands, Aug 11 Outside Lands, Aug 12 Outside Lands, Sep 3 Labor Day"
+	if got := strings.Join(names, ", "); got != want {
+		t.Errorf("bad events:\ngot  %s\nwant %s", got, want)
+	}
+	if on := c.On(time.Date(2018, time.May, 20, 14, 0, 0, 0, tz)); len(on) != 1 || on[0].Holiday {
+		t.Errorf("expected Bay to Breakers, got %v", on)
+	}
+	var nilCalendar *Calendar
+	if on := nilCalendar.On(time.Date(2018, time.May, 28, 0, 0, 0, 0, tz)); len(on) != 1 || !on[0].Holiday {
+		t.Errorf("expected the nil calendar to have Memorial Day, got %v", on)
+	}
+
+	for _, bad := range []string{
+		"2018-05-20\n",
+		"2018-05-20,\n",
+		"May 20,Bay to Breakers\n",
+		"2018-08-12,2018-08-10,Outside Lands\n",
+	} {
+		if _, err := ParseCalendar(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+// withCalendar sets the package calendar. Callers should defer
+// SetCalendar(nil) to put it back.
+func withCalendar(t *testing.T, s string) {
+	t.Helper()
+	c, err := ParseCalendar(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetCalendar(c)
+}
+
+func TestAverageWeekdayTripsSkipsSpecialDays(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	at := func(day int) time.Time {
+		return time.Date(2018, time.May, day, 12, 0, 0, 0, tz)
+	}
+	// Sunday May 20 to Saturday May 26: 10 trips every weekday but Tuesday,
+	// which has 100, and Wednesday, which has 40
+	counts := map[int]int{21: 10, 22: 100, 23: 40, 24: 10, 25: 10}
+	trips := make([]*gobike.Trip, 0)
+	for day := 20; day <= 26; day++ {
+		for i := 0; i < counts[day]; i++ {
+			trips = append(trips, &gobike.Trip{StartTime: at(day)})
+		}
+	}
+	if got := AverageWeekdayTrips(trips); got != 20 {
+		t.Errorf("expected (10+40+10)/3 trips, got %v", got)
+	}
+	withCalendar(t, "2018-05-22,Bike to Work Day\n")
+	defer SetCalendar(nil)
+	if got := AverageWeekdayTrips(trips); got != 10 {
+		t.Errorf("expected 10 trips without Bike to Work Day, got %v", got)
+	}
+	events := SpecialDaysLast7Days(trips)
+	if len(events) != 1 || events[0].Name != "Bike to Work Day" {
+		t.Errorf("expected Bike to Work Day, got %v", events)
+	}
+}
+
+func TestAggregateSkipSpecialDays(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	withCalendar(t, testCalendar)
+	defer SetCalendar(nil)
+	trips := []*gobike.Trip{
+		{StartTime: time.Date(2018, time.August, 9, 8, 0, 0, 0, tz)},
+		{StartTime: time.Date(2018, time.August, 10, 8, 0, 0, 0, tz)},
+		{StartTime: time.Date(2018, time.August, 11, 8, 0, 0, 0, tz)},
+	}
+	ts := Aggregate(trips, Query{Granularity: Day, SkipSpecialDays: true})
+	if len(ts) != 3 || ts[0].Data != 1 || ts[1].Data != 0 || ts[2].Data != 0 {
+		t.Errorf("expected only the trip on the 9th, got %v", ts)
+	}
+
+	annotations := Annotate(TripsPer(trips, Week), Week)
+	if len(annotations) != 2 {
+		t.Fatalf("expected 2 days of Outside Lands in the week, got %d", len(annotations))
+	}
+	if a := annotations[0]; a.Name != "Outside Lands" || !a.Bucket.Equal(time.Date(2018, time.August, 5, 0, 0, 0, 0, tz)) {
+		t.Errorf("bad annotation: %s in the week of %v", a.Name, a.Bucket)
+	}
+	data, err := json.Marshal(annotations[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"x":1533427200000,"from":1533859200000,"to":1533945600000,"name":"Outside Lands"}]`
+	if string(data) != want {
+		t.Errorf("bad JSON:\ngot  %s\nwant %s", data, want)
+	}
+}
+
+func TestCompareFlagsEvents(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	withCalendar(t, "2018-05-20,Bay to Breakers\n")
+	defer SetCalendar(nil)
+	// two weeks through Bay to Breakers, on Sunday May 20
+	start := time.Date(2018, time.May, 7, 0, 0, 0, 0, tz)
+	daily := dailySeries(start, 14, func(day time.Time) float64 {
+		if IsSpecialDay(day) {
+			return 500
+		}
+		return 150
+	})
+	c := Rolling(daily, 7)
+	if c.Average != 150 || c.FlagString() != FlagEvent {
+		t.Errorf("expected Bay to Breakers to be left out and flagged, got average %v, flags %q", c.Average, c.FlagString())
+	}
+}
@@ -0,0 +1,236 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// A trip that ends where it started in less than failedTripDuration is
+// probably a rider who couldn't get the bike to work, or changed their mind,
+// not a ride.
+const failedTripDuration = 2 * time.Minute
+
+// A bike that's docked for less than maxRedockTime before the next trip
+// starts from the same place was probably re-docked by the same rider, often
+// to avoid paying for going over the time limit.
+const maxRedockTime = 2 * time.Minute
+
+// TripKind describes a trip by where it ended and how long it took.
+type TripKind int
+
+const (
+	OneWayTrip TripKind = iota
+	// RoundTrip trips end where they started.
+	RoundTrip
+	// FailedTrip trips end where they started, within a couple of minutes.
+	FailedTrip
+)
+
+func (k TripKind) String() string {
+	switch k {
+	case RoundTrip:
+		return "round trip"
+	case FailedTrip:
+		return "failed"
+	default:
+		return "one way"
+	}
+}
+
+// samePlace reports whether a trip that ended at end was docked where the
+// trip start started: at the same station, or for dockless bikes, within a
+// few hundred feet.
+func samePlace(end, start *gobike.Trip) bool {
+	if !docklessEnd(end.EndStationID, end.EndStationName) && !docklessEnd(start.StartStationID, start.StartStationName) {
+		return end.EndStationID == start.StartStationID
+	}
+	if end.EndStationLatitude == 0 && end.EndStationLongitude == 0 ||
+		start.StartStationLatitude == 0 && start.StartStationLongitude == 0 {
+		return false
+	}
+	return moveDistance(end, start) < minDocklessMove
+}
+
+// ClassifyTrip returns the TripKind of t.
+func ClassifyTrip(t *gobike.Trip) TripKind {
+	if !samePlace(t, t) {
+		return OneWayTrip
+	}
+	if t.EndTime.Sub(t.StartTime) < failedTripDuration {
+		return FailedTrip
+	}
+	return RoundTrip
+}
+
+// Chain is a series of trips on the same bike, each starting where the last
+// one ended a moment later: almost certainly one rider re-docking the bike
+// along the way.
+type Chain struct {
+	BikeID int64
+	Trips  []*gobike.Trip
+}
+
+func (c *Chain) Start() time.Time {
+	return c.Trips[0].StartTime
+}
+
+func (c *Chain) End() time.Time {
+	return c.Trips[len(c.Trips)-1].EndTime
+}
+
+// Redocks returns the number of times the bike was re-docked.
+func (c *Chain) Redocks() int {
+	return len(c.Trips) - 1
+}
+
+// Journey returns a single trip from the start of the first trip in the chain
+// to the end of the last one. The rider details are copied from the first.
+func (c *Chain) Journey() *gobike.Trip {
+	first, last := c.Trips[0], c.Trips[len(c.Trips)-1]
+	j := *first
+	j.EndTime = last.EndTime
+	j.Duration = last.EndTime.Sub(first.StartTime)
+	j.EndStationID = last.EndStationID
+	j.EndStationName = last.EndStationName
+	j.EndStationLatitude = last.EndStationLatitude
+	j.EndStationLongitude = last.EndStationLongitude
+	return &j
+}
+
+// chainTrips calls f with every run of trips on the same bike that were
+// re-docked in between, including runs of one trip. Failed trips are left
+// out. trips don't need to be in order.
+func chainTrips(trips []*gobike.Trip, f func(c *Chain)) {
+	sorted := sortByBike(trips)
+	var current *Chain
+	for _, t := range sorted {
+		if ClassifyTrip(t) == FailedTrip {
+			continue
+		}
+		if current != nil && current.BikeID == t.BikeID {
+			prev := current.Trips[len(current.Trips)-1]
+			if gap := t.StartTime.Sub(prev.EndTime); gap >= 0 && gap < maxRedockTime && samePlace(prev, t) {
+				current.Trips = append(current.Trips, t)
+				continue
+			}
+		}
+		if current != nil {
+			f(current)
+		}
+		current = &Chain{BikeID: t.BikeID, Trips: []*gobike.Trip{t}}
+	}
+	if current != nil {
+		f(current)
+	}
+}
+
+// Chains returns every chain of two or more trips, ordered by when they
+// started.
+func Chains(trips []*gobike.Trip) []*Chain {
+	chains := make([]*Chain, 0)
+	chainTrips(trips, func(c *Chain) {
+		if len(c.Trips) > 1 {
+			chains = append(chains, c)
+		}
+	})
+	sort.SliceStable(chains, func(i, j int) bool {
+		return chains[i].Start().Before(chains[j].Start())
+	})
+	return chains
+}
+
+// Journeys returns trips with the failed trips removed and every chain
+// merged into a single trip, ordered by start time. Counting journeys instead
+// of trips doesn't reward riders for re-docking.
+func Journeys(trips []*gobike.Trip) []*gobike.Trip {
+	journeys := make([]*gobike.Trip, 0, len(trips))
+	chainTrips(trips, func(c *Chain) {
+		if len(c.Trips) == 1 {
+			journeys = append(journeys, c.Trips[0])
+			return
+		}
+		journeys = append(journeys, c.Journey())
+	})
+	sort.SliceStable(journeys, func(i, j int) bool {
+		return journeys[i].StartTime.Before(journeys[j].StartTime)
+	})
+	return journeys
+}
+
+// TripPatterns counts the trips in one bucket by what kind they were.
+type TripPatterns struct {
+	Date  time.Time
+	Trips int
+	// Failed and Round count the trips of each TripKind.
+	Failed, Round int
+	// Chains is the number of chains that started in the bucket, and
+	// ChainedTrips the number of trips in them.
+	Chains, ChainedTrips int
+	// Journeys is the number of trips left after removing the failed trips
+	// and merging each chain into one.
+	Journeys int
+}
+
+// TripPatternsPer counts the trips in each bucket by TripKind and chain, from
+// the first bucket with a trip to the last complete one. Weeks start on
+// Sunday, like TripsPerWeek. Chains are counted in the bucket they started
+// in.
+func TripPatternsPer(trips []*gobike.Trip, g Granularity) []*TripPatterns {
+	tzOnce.Do(populateTZ)
+	end := completeBefore(trips, g, time.Sunday)
+	buckets := make(map[int64]*TripPatterns)
+	var earliest time.Time
+	bucket := func(t time.Time) *TripPatterns {
+		start := g.Truncate(t, time.Sunday)
+		if !start.Before(end) {
+			return nil
+		}
+		p, ok := buckets[start.Unix()]
+		if !ok {
+			p = &TripPatterns{Date: start}
+			buckets[start.Unix()] = p
+		}
+		if earliest.IsZero() || start.Before(earliest) {
+			earliest = start
+		}
+		return p
+	}
+	for _, t := range trips {
+		p := bucket(t.StartTime)
+		if p == nil {
+			continue
+		}
+		p.Trips++
+		switch ClassifyTrip(t) {
+		case FailedTrip:
+			p.Failed++
+		case RoundTrip:
+			p.Round++
+		}
+	}
+	chainTrips(trips, func(c *Chain) {
+		p := bucket(c.Start())
+		if p == nil {
+			return
+		}
+		p.Journeys++
+		if len(c.Trips) > 1 {
+			p.Chains++
+			p.ChainedTrips += len(c.Trips)
+		}
+	})
+	result := make([]*TripPatterns, 0)
+	if len(buckets) == 0 {
+		return result
+	}
+	for t := earliest; t.Before(end); t = g.Next(t) {
+		p, ok := buckets[t.Unix()]
+		if !ok {
+			p = &TripPatterns{Date: t}
+		}
+		result = append(result, p)
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestClassifyTrip(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	start := time.Date(2018, time.August, 20, 8, 0, 0, 0, tz)
+	trip := func(from, to string, d time.Duration) *gobike.Trip {
+		return &gobike.Trip{StartTime: start, EndTime: start.Add(d), StartStationID: from, EndStationID: to}
+	}
+	tests := []struct {
+		trip *gobike.Trip
+		want TripKind
+	}{
+		{trip("30", "81", time.Minute), OneWayTrip},
+		{trip("30", "30", time.Minute), FailedTrip},
+		{trip("30", "30", 20*time.Minute), RoundTrip},
+		// dockless trips are compared by where they were parked
+		{&gobike.Trip{
+			StartTime: start, EndTime: start.Add(30 * time.Second),
+			StartStationLatitude: 37.78, StartStationLongitude: -122.41,
+			EndStationLatitude: 37.7801, EndStationLongitude: -122.4101,
+		}, FailedTrip},
+		{&gobike.Trip{
+			StartTime: start, EndTime: start.Add(30 * time.Second),
+			StartStationLatitude: 37.78, StartStationLongitude: -122.41,
+			EndStationLatitude: 37.80, EndStationLongitude: -122.41,
+		}, OneWayTrip},
+		// no location at all
+		{trip("", "", time.Minute), OneWayTrip},
+	}
+	for _, tt := range tests {
+		if got := ClassifyTrip(tt.trip); got != tt.want {
+			t.Errorf("ClassifyTrip(%s -> %s): got %s, want %s", tt.trip.StartStationID, tt.trip.EndStationID, got, tt.want)
+		}
+	}
+}
+
+func TestChains(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	trips := []*gobike.Trip{
+		// bike 1: 30 -> 81, re-docked, 81 -> 90, re-docked after a failed
+		// trip, 90 -> 30
+		testTrip(1, augustAt(20, 8, 0), 28, "30", "81"),
+		testTrip(1, augustAt(20, 8, 29), 29, "81", "90"),
+		testTrip(1, augustAt(20, 8, 58).Add(20*time.Second), 1, "90", "90"),
+		testTrip(1, augustAt(20, 8, 59).Add(40*time.Second), 20, "90", "30"),
+		// later the same day: too long at the dock to be a chain
+		testTrip(1, augustAt(20, 12, 0), 10, "30", "81"),
+		testTrip(1, augustAt(20, 12, 20), 10, "81", "30"),
+		// bike 2: starts somewhere else a minute later, so it was moved
+		testTrip(2, augustAt(20, 9, 0), 10, "30", "81"),
+		testTrip(2, augustAt(20, 9, 11), 10, "90", "30"),
+	}
+	chains := Chains(trips)
+	if len(chains) != 1 {
+		t.Fatalf("expected one chain, got %d", len(chains))
+	}
+	c := chains[0]
+	if c.BikeID != 1 || c.Redocks() != 2 || !c.Start().Equal(augustAt(20, 8, 0)) {
+		t.Errorf("bad chain: bike %d, %d redocks, start %v", c.BikeID, c.Redocks(), c.Start())
+	}
+	j := c.Journey()
+	if j.StartStationID != "30" || j.EndStationID != "30" || j.Duration != 79*time.Minute+40*time.Second || j.UserType != "Customer" {
+		t.Errorf("bad journey: %+v", j)
+	}
+	if ClassifyTrip(j) != RoundTrip {
+		t.Errorf("expected the journey to be a round trip, got %s", ClassifyTrip(j))
+	}
+	if trips[0].EndStationID != "81" {
+		t.Error("Journey modified the first trip")
+	}
+
+	journeys := Journeys(trips)
+	// 8 trips, less a failed trip, with 3 merged into 1
+	if len(journeys) != 5 {
+		t.Fatalf("expected 5 journeys, got %d", len(journeys))
+	}
+	for i := 1; i < len(journeys); i++ {
+		if journeys[i].StartTime.Before(journeys[i-1].StartTime) {
+			t.Fatalf("journeys out of order at %d", i)
+		}
+	}
+}
+
+func TestTripPatternsPer(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	trips := []*gobike.Trip{
+		testTrip(1, augustAt(20, 8, 0), 28, "30", "81"),
+		testTrip(1, augustAt(20, 8, 29), 29, "81", "90"),
+		testTrip(2, augustAt(21, 8, 0), 1, "30", "30"),
+		testTrip(3, augustAt(22, 8, 0), 30, "30", "30"),
+		// Saturday of the next week; the data ends that day, so the week is
+		// complete
+		testTrip(4, augustAt(32, 8, 0), 10, "30", "81"),
+	}
+	patterns := TripPatternsPer(trips, Week)
+	if len(patterns) != 2 {
+		t.Fatalf("expected 2 weeks, got %d", len(patterns))
+	}
+	p := patterns[0]
+	if p.Trips != 4 || p.Failed != 1 || p.Round != 1 || p.Chains != 1 || p.ChainedTrips != 2 || p.Journeys != 2 {
+		t.Errorf("bad first week: %+v", p)
+	}
+	if p := patterns[1]; p.Trips != 1 || p.Journeys != 1 || p.Chains != 0 {
+		t.Errorf("bad second week: %+v", p)
+	}
+}
@@ -0,0 +1,216 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// The weekday rush hours, in the Bay Area.
+var (
+	AMPeak = TimeOfDay{"AM peak", 7, 10}
+	PMPeak = TimeOfDay{"PM peak", 16, 19}
+)
+
+// A flow is a commute if it has trips on at least commuteDayFraction of
+// weekdays in the morning, and back on as many in the evening.
+const commuteDayFraction = 0.5
+
+// Corridor counts the weekday peak trips in each direction between two zones.
+// Index 0 of each count is from A to B, and index 1 from B to A.
+type Corridor struct {
+	A, B *ODZone
+	// AM and PM are the number of trips in each peak.
+	AM, PM [2]int
+	// AMDays and PMDays are the number of weekdays with at least one trip
+	// in each peak.
+	AMDays, PMDays [2]int
+
+	amDays, pmDays [2]map[int64]bool
+}
+
+func imbalance(n [2]int) float64 {
+	if n[0]+n[1] == 0 {
+		return 0
+	}
+	return float64(n[0]-n[1]) / float64(n[0]+n[1])
+}
+
+// AMImbalance returns how lopsided the morning peak is, from 1 if every trip
+// went from A to B to -1 if every trip went from B to A.
+func (c *Corridor) AMImbalance() float64 {
+	return imbalance(c.AM)
+}
+
+// PMImbalance returns how lopsided the evening peak is, from 1 if every trip
+// went from A to B to -1 if every trip went from B to A.
+func (c *Corridor) PMImbalance() float64 {
+	return imbalance(c.PM)
+}
+
+// PeakTrips returns the number of trips in both peaks, in both directions.
+func (c *Corridor) PeakTrips() int {
+	return c.AM[0] + c.AM[1] + c.PM[0] + c.PM[1]
+}
+
+// CommuteFlow is a corridor that riders take one way in the morning and back
+// in the evening.
+type CommuteFlow struct {
+	// From is where riders go from in the morning, and back to in the
+	// evening.
+	From, To *ODZone
+	// AM is the number of trips from From to To in the morning peak, and PM
+	// the number back in the evening peak. AMDays and PMDays are the number
+	// of weekdays with at least one.
+	AM, PM         int
+	AMDays, PMDays int
+}
+
+// Commutes counts weekday peak trips between every pair of zones at a level.
+type Commutes struct {
+	Level ODLevel
+	// Days is the number of weekdays, not counting holidays and events, with
+	// at least one trip.
+	Days int
+	// Skipped is the number of peak trips that started or ended outside
+	// every zone.
+	Skipped int
+
+	corridors map[odPair]*Corridor
+}
+
+// NewCommutes counts the trips in trips that start in the morning or evening
+// peak of a weekday that isn't a holiday or event, between every pair of zones
+// at the given level. Trips that start and end in the same zone are left out.
+// stationMap is used for station names and locations, like NewODMatrix.
+func NewCommutes(trips []*gobike.Trip, stationMap map[string]*gobike.Station, level ODLevel) *Commutes {
+	tzOnce.Do(populateTZ)
+	c := &Commutes{
+		Level:     level,
+		corridors: make(map[odPair]*Corridor),
+	}
+	finder := &zoneFinder{
+		level:      level,
+		stationMap: stationMap,
+		cache:      make(map[string]stationZone),
+		zones:      make(map[string]*ODZone),
+	}
+	// the weekdays with trips
+	days := make(map[int64]bool)
+	for _, t := range trips {
+		start := t.StartTime.In(tz)
+		day := Day.Truncate(start, time.Sunday)
+		if !workday(day) {
+			continue
+		}
+		days[day.Unix()] = true
+		var pm bool
+		switch hour := start.Hour(); {
+		case hour >= AMPeak.Start && hour < AMPeak.End:
+		case hour >= PMPeak.Start && hour < PMPeak.End:
+			pm = true
+		default:
+			continue
+		}
+		from := finder.find(t.StartStationID, t.StartStationName, t.StartStationLatitude, t.StartStationLongitude)
+		to := finder.find(t.EndStationID, t.EndStationName, t.EndStationLatitude, t.EndStationLongitude)
+		if from == nil || to == nil {
+			c.Skipped++
+			continue
+		}
+		if from == to {
+			continue
+		}
+		dir := 0
+		pair := odPair{from.ID, to.ID}
+		if to.ID < from.ID {
+			dir = 1
+			pair = odPair{to.ID, from.ID}
+		}
+		corridor, ok := c.corridors[pair]
+		if !ok {
+			corridor = &Corridor{A: finder.zones[pair.from], B: finder.zones[pair.to]}
+			for i := range corridor.amDays {
+				corridor.amDays[i] = make(map[int64]bool)
+				corridor.pmDays[i] = make(map[int64]bool)
+			}
+			c.corridors[pair] = corridor
+		}
+		if pm {
+			corridor.PM[dir]++
+			corridor.pmDays[dir][day.Unix()] = true
+		} else {
+			corridor.AM[dir]++
+			corridor.amDays[dir][day.Unix()] = true
+		}
+	}
+	c.Days = len(days)
+	for _, corridor := range c.corridors {
+		for i := range corridor.amDays {
+			corridor.AMDays[i] = len(corridor.amDays[i])
+			corridor.PMDays[i] = len(corridor.pmDays[i])
+		}
+	}
+	finder.locate()
+	return c
+}
+
+// Corridors returns every pair of zones with a peak trip between them, most
+// peak trips first.
+func (c *Commutes) Corridors() []*Corridor {
+	corridors := make([]*Corridor, 0, len(c.corridors))
+	for _, corridor := range c.corridors {
+		corridors = append(corridors, corridor)
+	}
+	sort.Slice(corridors, func(i, j int) bool {
+		if corridors[i].PeakTrips() != corridors[j].PeakTrips() {
+			return corridors[i].PeakTrips() > corridors[j].PeakTrips()
+		}
+		if corridors[i].A.ID != corridors[j].A.ID {
+			return corridors[i].A.ID < corridors[j].A.ID
+		}
+		return corridors[i].B.ID < corridors[j].B.ID
+	})
+	return corridors
+}
+
+// Flows returns the corridors that look like commutes: most of the morning
+// trips go one way, most of the evening trips go back, and both happen on at
+// least half of weekdays. The biggest commutes come first, by the smaller of
+// the morning and evening trips.
+func (c *Commutes) Flows() []*CommuteFlow {
+	minDays := commuteDayFraction * float64(c.Days)
+	flows := make([]*CommuteFlow, 0)
+	for _, corridor := range c.Corridors() {
+		for dir := 0; dir < 2; dir++ {
+			back := 1 - dir
+			if corridor.AM[dir] <= corridor.AM[back] || corridor.PM[back] <= corridor.PM[dir] {
+				continue
+			}
+			if float64(corridor.AMDays[dir]) < minDays || float64(corridor.PMDays[back]) < minDays {
+				continue
+			}
+			f := &CommuteFlow{
+				From: corridor.A, To: corridor.B,
+				AM: corridor.AM[dir], PM: corridor.PM[back],
+				AMDays: corridor.AMDays[dir], PMDays: corridor.PMDays[back],
+			}
+			if dir == 1 {
+				f.From, f.To = corridor.B, corridor.A
+			}
+			flows = append(flows, f)
+		}
+	}
+	sort.SliceStable(flows, func(i, j int) bool {
+		return minInt(flows[i].AM, flows[i].PM) > minInt(flows[j].AM, flows[j].PM)
+	})
+	return flows
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
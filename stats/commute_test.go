@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestCommutes(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	stationMap := map[string]*gobike.Station{
+		"1": {ID: 1, Name: "Market St", Latitude: 37.7749, Longitude: -122.4194},
+		"2": {ID: 2, Name: "Mission St", Latitude: 37.7599, Longitude: -122.4148},
+		"3": {ID: 3, Name: "Broadway", Latitude: 37.8044, Longitude: -122.2712},
+	}
+	trip := func(month time.Month, day, hour int, from, to string) *gobike.Trip {
+		return &gobike.Trip{
+			StartTime:      time.Date(2018, month, day, hour, 0, 0, 0, tz),
+			StartStationID: from, EndStationID: to,
+		}
+	}
+	trips := []*gobike.Trip{
+		// Monday to Wednesday: Market St to Broadway and back
+		trip(time.August, 20, 8, "1", "3"),
+		trip(time.August, 20, 17, "3", "1"),
+		trip(time.August, 21, 8, "1", "3"),
+		trip(time.August, 21, 17, "3", "1"),
+		trip(time.August, 22, 8, "1", "3"),
+		trip(time.August, 22, 17, "3", "1"),
+		// Thursday: only the morning
+		trip(time.August, 23, 9, "1", "3"),
+		// Friday: the other way, and off peak
+		trip(time.August, 24, 8, "2", "1"),
+		trip(time.August, 24, 12, "1", "3"),
+		// same station
+		trip(time.August, 24, 8, "1", "1"),
+		// Saturday and Labor Day don't count
+		trip(time.August, 25, 8, "3", "1"),
+		trip(time.August, 25, 8, "3", "1"),
+		trip(time.September, 3, 8, "3", "1"),
+		trip(time.September, 3, 8, "3", "1"),
+	}
+
+	c := NewCommutes(trips, stationMap, StationLevel)
+	if c.Days != 5 {
+		t.Errorf("expected 5 weekdays, got %d", c.Days)
+	}
+	corridors := c.Corridors()
+	if len(corridors) != 2 {
+		t.Fatalf("expected 2 corridors, got %d", len(corridors))
+	}
+	cr := corridors[0]
+	if cr.A.ID != "1" || cr.B.ID != "3" || cr.A.Name != "Market St" {
+		t.Fatalf("expected the busiest corridor first, got %s - %s", cr.A.ID, cr.B.ID)
+	}
+	if cr.AM != [2]int{4, 0} || cr.PM != [2]int{0, 3} || cr.AMDays != [2]int{4, 0} || cr.PMDays != [2]int{0, 3} {
+		t.Errorf("bad counts: AM %v on %v days, PM %v on %v days", cr.AM, cr.AMDays, cr.PM, cr.PMDays)
+	}
+	if cr.AMImbalance() != 1 || cr.PMImbalance() != -1 {
+		t.Errorf("bad imbalance: %v in the morning, %v in the evening", cr.AMImbalance(), cr.PMImbalance())
+	}
+	if cr := corridors[1]; cr.A.ID != "1" || cr.B.ID != "2" || cr.AM != [2]int{0, 1} || cr.AMImbalance() != -1 {
+		t.Errorf("bad second corridor: %s - %s, AM %v", cr.A.ID, cr.B.ID, cr.AM)
+	}
+
+	flows := c.Flows()
+	if len(flows) != 1 {
+		t.Fatalf("expected one commute, got %d", len(flows))
+	}
+	if f := flows[0]; f.From.ID != "1" || f.To.ID != "3" || f.AM != 4 || f.PM != 3 || f.AMDays != 4 || f.PMDays != 3 {
+		t.Errorf("bad commute: %s -> %s, %+v", f.From.ID, f.To.ID, f)
+	}
+
+	c = NewCommutes(trips, stationMap, CityLevel)
+	flows = c.Flows()
+	if len(flows) != 1 || flows[0].From.ID != "sf" || flows[0].To.ID != "oakland" {
+		t.Fatalf("expected a commute from San Francisco to Oakland, got %d flows", len(flows))
+	}
+	if flows[0].From.Latitude == 0 {
+		t.Error("expected zones to be located")
+	}
+}
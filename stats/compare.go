@@ -0,0 +1,227 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reasons a Comparison may be misleading.
+const (
+	// FlagHoliday means one of the periods includes a holiday. Holidays are
+	// left out of the daily averages, so the comparison is still fair, but
+	// there are fewer days behind it.
+	FlagHoliday = "holiday"
+	// FlagEvent means one of the periods includes an event from the calendar
+	// passed to SetCalendar. Like holidays, events are left out of the daily
+	// averages.
+	FlagEvent = "event"
+	// FlagPartial means the data doesn't cover every day of both periods.
+	FlagPartial = "partial"
+	// FlagLowVolume means there were too few trips in one of the periods for
+	// the percent change to mean much.
+	FlagLowVolume = "low volume"
+)
+
+// minComparableTrips is the number of trips each period needs for a
+// comparison not to be flagged as low volume.
+const minComparableTrips = 100
+
+// RollingWindows are the window sizes, in days, that Compare reports.
+var RollingWindows = []int{7, 28, 90}
+
+// Comparison compares a period of a daily series with an earlier period of the
+// same length: either the period just before it, or the same ISO week a year
+// earlier.
+type Comparison struct {
+	Label string
+	// The period being described, [Start, End).
+	Start, End time.Time
+	// Sum is the total over the period.
+	Sum float64
+	// Average is the average per day, leaving out holidays and events
+	// (unless every day was one).
+	Average float64
+
+	PreviousStart, PreviousEnd time.Time
+	PreviousSum                float64
+	PreviousAverage            float64
+
+	// Flags lists the reasons the comparison may be misleading.
+	Flags []string
+}
+
+// LastDay returns the start of the last day in the period.
+func (c *Comparison) LastDay() time.Time {
+	return c.End.AddDate(0, 0, -1)
+}
+
+// PctChange returns the percent change in the daily average from the earlier
+// period. ok is false if the earlier period had no activity.
+func (c *Comparison) PctChange() (pct float64, ok bool) {
+	if c.PreviousAverage == 0 {
+		return 0, false
+	}
+	return 100 * (c.Average - c.PreviousAverage) / c.PreviousAverage, true
+}
+
+// PctChangeString formats PctChange with a sign, like "+4.2%", or returns
+// "n/a".
+func (c *Comparison) PctChangeString() string {
+	pct, ok := c.PctChange()
+	if !ok {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", pct)
+}
+
+// Confident reports whether the comparison can be taken at face value: both
+// periods are fully covered by data and have enough trips.
+func (c *Comparison) Confident() bool {
+	for _, flag := range c.Flags {
+		if flag == FlagPartial || flag == FlagLowVolume {
+			return false
+		}
+	}
+	return true
+}
+
+// FlagString returns the flags separated by commas.
+func (c *Comparison) FlagString() string {
+	return strings.Join(c.Flags, ", ")
+}
+
+func (c *Comparison) addFlag(flag string) {
+	for _, f := range c.Flags {
+		if f == flag {
+			return
+		}
+	}
+	c.Flags = append(c.Flags, flag)
+}
+
+// period sums the series over [start, end) and averages it over the days that
+// aren't holidays or events. covered is false if the series doesn't include
+// every day.
+func period(values map[int64]float64, start, end time.Time) (sum, avg float64, holidays, events int, covered bool) {
+	covered = true
+	var days int
+	var workingSum float64
+	for t := start; t.Before(end); t = Day.Next(t) {
+		v, ok := values[t.Unix()]
+		if !ok {
+			covered = false
+		}
+		sum += v
+		days++
+		if special := calendar.On(t); len(special) > 0 {
+			if special[0].Holiday {
+				holidays++
+			} else {
+				events++
+			}
+			continue
+		}
+		workingSum += v
+	}
+	switch {
+	case days == holidays+events && days > 0:
+		avg = sum / float64(days)
+	case days > 0:
+		avg = workingSum / float64(days-holidays-events)
+	}
+	return sum, avg, holidays, events, covered
+}
+
+func compare(values map[int64]float64, label string, start, end, prevStart, prevEnd time.Time) *Comparison {
+	c := &Comparison{
+		Label:         label,
+		Start:         start,
+		End:           end,
+		PreviousStart: prevStart,
+		PreviousEnd:   prevEnd,
+	}
+	var holidays, prevHolidays, events, prevEvents int
+	var covered, prevCovered bool
+	c.Sum, c.Average, holidays, events, covered = period(values, start, end)
+	c.PreviousSum, c.PreviousAverage, prevHolidays, prevEvents, prevCovered = period(values, prevStart, prevEnd)
+	if holidays > 0 || prevHolidays > 0 {
+		c.addFlag(FlagHoliday)
+	}
+	if events > 0 || prevEvents > 0 {
+		c.addFlag(FlagEvent)
+	}
+	if !covered || !prevCovered {
+		c.addFlag(FlagPartial)
+	}
+	if c.Sum < minComparableTrips || c.PreviousSum < minComparableTrips {
+		c.addFlag(FlagLowVolume)
+	}
+	return c
+}
+
+func dailyValues(daily TimeSeries) map[int64]float64 {
+	values := make(map[int64]float64, len(daily))
+	for _, stat := range daily {
+		values[Day.Truncate(stat.Date, time.Sunday).Unix()] = stat.Data
+	}
+	return values
+}
+
+// Rolling compares the last days days of daily, a series with one point per
+// day like the one returned by TripsPer(trips, Day), with the days days
+// before that. It returns nil if daily is empty.
+func Rolling(daily TimeSeries, days int) *Comparison {
+	if len(daily) == 0 {
+		return nil
+	}
+	end := Day.Next(Day.Truncate(daily[len(daily)-1].Date, time.Sunday))
+	start := end.AddDate(0, 0, -days)
+	prevStart := start.AddDate(0, 0, -days)
+	return compare(dailyValues(daily), fmt.Sprintf("Last %d days", days), start, end, prevStart, start)
+}
+
+// isoWeekStart returns the Monday that starts ISO week week of year.
+func isoWeekStart(year, week int) time.Time {
+	// January 4th is always in week 1.
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, tz)
+	monday := Week.Truncate(jan4, time.Monday)
+	return monday.AddDate(0, 0, 7*(week-1))
+}
+
+// YearOverYear compares the last complete ISO week (Monday through Sunday) of
+// daily with the same ISO week a year earlier. It returns nil if daily has no
+// complete week, or the week doesn't exist in the earlier year.
+func YearOverYear(daily TimeSeries) *Comparison {
+	if len(daily) == 0 {
+		return nil
+	}
+	tzOnce.Do(populateTZ)
+	end := Day.Next(Day.Truncate(daily[len(daily)-1].Date, time.Sunday))
+	start := Week.Truncate(end, time.Monday).AddDate(0, 0, -7)
+	if start.Before(Day.Truncate(daily[0].Date, time.Sunday)) {
+		return nil
+	}
+	year, week := start.ISOWeek()
+	prevStart := isoWeekStart(year-1, week)
+	if y, w := prevStart.ISOWeek(); y != year-1 || w != week {
+		return nil
+	}
+	label := fmt.Sprintf("Week %d of %d vs. %d", week, year, year-1)
+	return compare(dailyValues(daily), label, start, start.AddDate(0, 0, 7), prevStart, prevStart.AddDate(0, 0, 7))
+}
+
+// Compare returns a Rolling comparison for each of RollingWindows, followed
+// by the YearOverYear comparison if there is one.
+func Compare(daily TimeSeries) []*Comparison {
+	comparisons := make([]*Comparison, 0, len(RollingWindows)+1)
+	for _, days := range RollingWindows {
+		if c := Rolling(daily, days); c != nil {
+			comparisons = append(comparisons, c)
+		}
+	}
+	if c := YearOverYear(daily); c != nil {
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
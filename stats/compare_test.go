@@ -0,0 +1,126 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHolidays(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	tests := []struct {
+		date time.Time
+		want bool
+	}{
+		{time.Date(2018, time.May, 28, 8, 0, 0, 0, tz), true},      // Memorial Day
+		{time.Date(2018, time.November, 23, 0, 0, 0, 0, tz), true}, // day after Thanksgiving
+		{time.Date(2017, time.December, 25, 0, 0, 0, 0, tz), true},
+		// July 4th 2020 was a Saturday
+		{time.Date(2020, time.July, 3, 0, 0, 0, 0, tz), true},
+		{time.Date(2020, time.July, 4, 0, 0, 0, 0, tz), false},
+		// New Year's Day 2022 was a Saturday
+		{time.Date(2021, time.December, 31, 0, 0, 0, 0, tz), true},
+		{time.Date(2018, time.May, 21, 0, 0, 0, 0, tz), false},
+	}
+	for _, tt := range tests {
+		if got := IsHoliday(tt.date); got != tt.want {
+			t.Errorf("IsHoliday(%s): got %t, want %t", tt.date.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
+
+// dailySeries returns one point per day from start, with value f(day).
+func dailySeries(start time.Time, days int, f func(time.Time) float64) TimeSeries {
+	series := make(TimeSeries, 0, days)
+	for t := start; len(series) < days; t = Day.Next(t) {
+		series = append(series, &TimeStat{Date: t, Data: f(t)})
+	}
+	return series
+}
+
+func TestRolling(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	start := time.Date(2018, time.April, 1, 0, 0, 0, 0, tz)
+	// 100 trips a day for four weeks, then 150 a day for four weeks, except
+	// Memorial Day
+	switchover := start.AddDate(0, 0, 28)
+	daily := dailySeries(start, 56, func(day time.Time) float64 {
+		if IsHoliday(day) {
+			return 20
+		}
+		if day.Before(switchover) {
+			return 100
+		}
+		return 150
+	})
+	c := Rolling(daily, 28)
+	if c.Label != "Last 28 days" || !c.End.Equal(time.Date(2018, time.May, 27, 0, 0, 0, 0, tz)) {
+		t.Fatalf("bad window: %q ending %v", c.Label, c.End)
+	}
+	if c.Average != 150 || c.PreviousAverage != 100 {
+		t.Errorf("bad averages: %v, %v", c.Average, c.PreviousAverage)
+	}
+	if got := c.PctChangeString(); got != "+50.0%" {
+		t.Errorf("bad percent change: %q", got)
+	}
+	if !c.Confident() || len(c.Flags) != 0 {
+		t.Errorf("expected no flags, got %v", c.Flags)
+	}
+
+	// extend through Memorial Day; the holiday is left out of the average
+	daily = append(daily, dailySeries(Day.Next(daily[len(daily)-1].Date), 2, func(day time.Time) float64 {
+		if IsHoliday(day) {
+			return 20
+		}
+		return 150
+	})...)
+	c = Rolling(daily, 7)
+	if c.Average != 150 || c.Sum != 150*6+20 || c.FlagString() != FlagHoliday {
+		t.Errorf("bad holiday week: average %v, sum %v, flags %q", c.Average, c.Sum, c.FlagString())
+	}
+	if !c.Confident() {
+		t.Error("a holiday shouldn't make a comparison unreliable")
+	}
+	c = Rolling(daily, 90)
+	if c.Confident() || c.FlagString() != "holiday, partial, low volume" {
+		t.Errorf("expected a partial comparison, got flags %q", c.FlagString())
+	}
+	if Rolling(nil, 7) != nil {
+		t.Error("expected no comparison for an empty series")
+	}
+}
+
+func TestYearOverYear(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, tz)
+	// the data ends on a Wednesday
+	end := time.Date(2018, time.August, 15, 0, 0, 0, 0, tz)
+	days := int(end.Sub(start).Hours()/24) + 1
+	daily := dailySeries(start, days, func(day time.Time) float64 {
+		if day.Year() == 2017 {
+			return 4
+		}
+		return 5
+	})
+	c := YearOverYear(daily)
+	if c == nil {
+		t.Fatal("expected a comparison")
+	}
+	if c.Label != "Week 32 of 2018 vs. 2017" {
+		t.Errorf("bad label %q", c.Label)
+	}
+	if !c.Start.Equal(time.Date(2018, time.August, 6, 0, 0, 0, 0, tz)) || !c.PreviousStart.Equal(time.Date(2017, time.August, 7, 0, 0, 0, 0, tz)) {
+		t.Errorf("bad weeks: %v and %v", c.Start, c.PreviousStart)
+	}
+	if c.Sum != 35 || c.PreviousSum != 28 || c.PctChangeString() != "+25.0%" {
+		t.Errorf("bad comparison: %v vs %v (%s)", c.Sum, c.PreviousSum, c.PctChangeString())
+	}
+	if c.Confident() {
+		t.Error("expected fewer than 100 trips a week to be flagged")
+	}
+	if got := Compare(daily); len(got) != len(RollingWindows)+1 {
+		t.Errorf("expected %d comparisons, got %d", len(RollingWindows)+1, len(got))
+	}
+	if YearOverYear(daily[len(daily)-5:]) != nil {
+		t.Error("expected no comparison without a complete week")
+	}
+}
@@ -0,0 +1,183 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// DisabledStreak is a stretch of time a station always had at least one
+// disabled bike, or disabled dock.
+type DisabledStreak struct {
+	StationID  string
+	Start, End time.Time
+	// Max is the most bikes or docks that were disabled at once.
+	Max int
+	// Ongoing is true if the streak was still going at the end of the period
+	// it was computed for.
+	Ongoing bool
+}
+
+func (s *DisabledStreak) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// StationDisabled is how many of a station's bikes and docks were disabled
+// over a period.
+type StationDisabled struct {
+	StationID string
+	// Observed is how long the station's status was known.
+	Observed time.Duration
+	// Bikes and Docks are the average number of disabled bikes and docks
+	// while the station was observed.
+	Bikes, Docks float64
+	// BikeStreaks and DockStreaks are the times the station had a disabled
+	// bike or dock, in order.
+	BikeStreaks, DockStreaks []*DisabledStreak
+}
+
+func longestStreak(streaks []*DisabledStreak) *DisabledStreak {
+	var longest *DisabledStreak
+	for _, s := range streaks {
+		if longest == nil || s.Duration() > longest.Duration() {
+			longest = s
+		}
+	}
+	return longest
+}
+
+// LongestBikeStreak returns the longest time the station had a disabled
+// bike, or nil if it never did.
+func (s *StationDisabled) LongestBikeStreak() *DisabledStreak {
+	return longestStreak(s.BikeStreaks)
+}
+
+// LongestDockStreak returns the longest time the station had a disabled
+// dock, or nil if it never did.
+func (s *StationDisabled) LongestDockStreak() *DisabledStreak {
+	return longestStreak(s.DockStreaks)
+}
+
+// streakBuilder joins consecutive spans with something disabled into
+// streaks. A gap in the statuses ends a streak, since we don't know what
+// happened during it.
+type streakBuilder struct {
+	id      string
+	end     time.Time
+	current *DisabledStreak
+	streaks []*DisabledStreak
+}
+
+func (b *streakBuilder) add(n int, from, to time.Time) {
+	if n == 0 || (b.current != nil && b.current.End.Before(from)) {
+		b.current = nil
+	}
+	if n == 0 {
+		return
+	}
+	if b.current == nil {
+		b.current = &DisabledStreak{StationID: b.id, Start: from}
+		b.streaks = append(b.streaks, b.current)
+	}
+	b.current.End = to
+	b.current.Ongoing = !to.Before(b.end)
+	if n > b.current.Max {
+		b.current.Max = n
+	}
+}
+
+// StationDisabledBetween computes a station's StationDisabled between start
+// and end, from its statuses sorted by time. Each status is assumed to hold
+// until the next one, or for an hour, whichever comes first.
+func StationDisabledBetween(id string, statuses []*gobike.StationStatus, start, end time.Time) *StationDisabled {
+	sd := &StationDisabled{StationID: id}
+	bikes := &streakBuilder{id: id, end: end}
+	docks := &streakBuilder{id: id, end: end}
+	var bikeTime, dockTime float64
+	eachSpan(statuses, start, end, func(ss *gobike.StationStatus, from, to time.Time) {
+		d := to.Sub(from)
+		sd.Observed += d
+		bikeTime += float64(ss.NumBikesDisabled) * float64(d)
+		dockTime += float64(ss.NumDocksDisabled) * float64(d)
+		bikes.add(int(ss.NumBikesDisabled), from, to)
+		docks.add(int(ss.NumDocksDisabled), from, to)
+	})
+	if sd.Observed > 0 {
+		sd.Bikes = bikeTime / float64(sd.Observed)
+		sd.Docks = dockTime / float64(sd.Observed)
+	}
+	sd.BikeStreaks = bikes.streaks
+	sd.DockStreaks = docks.streaks
+	return sd
+}
+
+// StationsDisabled computes the StationDisabled of every station in
+// statuses, a map like the one returned by StatusMap, between start and end.
+// Stations that were never observed are left out, and the rest are sorted by
+// the average number of disabled bikes and docks, most first.
+func StationsDisabled(statuses map[string][]*gobike.StationStatus, start, end time.Time) []*StationDisabled {
+	result := make([]*StationDisabled, 0, len(statuses))
+	for id := range statuses {
+		sd := StationDisabledBetween(id, statuses[id], start, end)
+		if sd.Observed > 0 {
+			result = append(result, sd)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ti, tj := result[i].Bikes+result[i].Docks, result[j].Bikes+result[j].Docks
+		if ti != tj {
+			return ti > tj
+		}
+		return result[i].StationID < result[j].StationID
+	})
+	return result
+}
+
+func sortStreaks(streaks []*DisabledStreak) {
+	sort.Slice(streaks, func(i, j int) bool {
+		if streaks[i].Duration() != streaks[j].Duration() {
+			return streaks[i].Duration() > streaks[j].Duration()
+		}
+		return streaks[i].StationID < streaks[j].StationID
+	})
+}
+
+// LongestDisabledStreaks returns the bike and dock streaks of every station
+// in stations, longest first.
+func LongestDisabledStreaks(stations []*StationDisabled) (bikes, docks []*DisabledStreak) {
+	for _, sd := range stations {
+		bikes = append(bikes, sd.BikeStreaks...)
+		docks = append(docks, sd.DockStreaks...)
+	}
+	sortStreaks(bikes)
+	sortStreaks(docks)
+	return bikes, docks
+}
+
+// DisabledPer returns the average number of disabled bikes, and disabled
+// docks, across the stations in statuses in each bucket, counting time like
+// UsabilityPer. Each station counts with its own average over the time it was
+// observed in the bucket, so gaps in the data don't lower the totals.
+func DisabledPer(statuses map[string][]*gobike.StationStatus, g Granularity, end time.Time) (bikes, docks TimeSeries) {
+	bikes = make(TimeSeries, 0)
+	docks = make(TimeSeries, 0)
+	for _, bucket := range sumStatuses(statuses, g, end, func(ss *gobike.StationStatus) []float64 {
+		return []float64{float64(ss.NumBikesDisabled), float64(ss.NumDocksDisabled)}
+	}) {
+		var b, d float64
+		for _, st := range bucket.stations {
+			b += st.sums[0] / st.observed
+			d += st.sums[1] / st.observed
+		}
+		bikes = append(bikes, &TimeStat{Date: bucket.start, Data: b})
+		docks = append(docks, &TimeStat{Date: bucket.start, Data: d})
+	}
+	return bikes, docks
+}
+
+// DisabledPerWeek returns the average number of disabled bikes and docks in
+// each week that ends by end. Weeks start on Sunday, like TripsPerWeek.
+func DisabledPerWeek(statuses map[string][]*gobike.StationStatus, end time.Time) (bikes, docks TimeSeries) {
+	return DisabledPer(statuses, Week, end)
+}
@@ -0,0 +1,102 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestStationDisabled(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	disabled := func(t time.Time, bikes, docks int16) *gobike.StationStatus {
+		ss := testStatus("1", t, 0, 0)
+		ss.NumBikesDisabled, ss.NumDocksDisabled = bikes, docks
+		return ss
+	}
+	statuses := []*gobike.StationStatus{
+		disabled(augustAt(20, 8, 0), 2, 0),
+		disabled(augustAt(20, 8, 30), 1, 1),
+		disabled(augustAt(20, 9, 0), 0, 1),
+		// the status at 9 expires at 10, so this starts a new dock streak
+		disabled(augustAt(20, 11, 0), 0, 1),
+		disabled(augustAt(20, 11, 30), 0, 0),
+	}
+	end := augustAt(20, 12, 0)
+	sd := StationDisabledBetween("1", statuses, augustAt(20, 0, 0), end)
+	// 8:00 to 10:00, then 11:00 to 12:00
+	if sd.Observed != 3*time.Hour {
+		t.Errorf("expected 3 hours observed, got %v", sd.Observed)
+	}
+	// (2*30 + 1*30) bike-minutes and (30 + 60 + 30) dock-minutes over 180
+	if sd.Bikes != 0.5 || sd.Docks != 2.0/3 {
+		t.Errorf("expected 0.5 bikes and 0.67 docks, got %v and %v", sd.Bikes, sd.Docks)
+	}
+	if len(sd.BikeStreaks) != 1 {
+		t.Fatalf("expected one bike streak, got %d", len(sd.BikeStreaks))
+	}
+	if s := sd.BikeStreaks[0]; !s.Start.Equal(augustAt(20, 8, 0)) || s.Duration() != time.Hour || s.Max != 2 || s.Ongoing {
+		t.Errorf("bad bike streak: %+v", s)
+	}
+	if len(sd.DockStreaks) != 2 {
+		t.Fatalf("expected the gap to split the dock streaks, got %d", len(sd.DockStreaks))
+	}
+	if s := sd.LongestDockStreak(); s != sd.DockStreaks[0] || s.Duration() != 90*time.Minute {
+		t.Errorf("bad longest dock streak: %+v", s)
+	}
+
+	// cut off in the middle of the second dock streak
+	sd = StationDisabledBetween("1", statuses, augustAt(20, 0, 0), augustAt(20, 11, 15))
+	if s := sd.DockStreaks[1]; !s.Ongoing || s.Duration() != 15*time.Minute {
+		t.Errorf("expected an ongoing streak, got %+v", s)
+	}
+	if sd := StationDisabledBetween("1", statuses, augustAt(21, 0, 0), augustAt(22, 0, 0)); sd.Observed != 0 || sd.LongestBikeStreak() != nil {
+		t.Errorf("expected nothing observed the next day, got %+v", sd)
+	}
+}
+
+func TestDisabledPerWeek(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	statuses := map[string][]*gobike.StationStatus{
+		"1": {{ID: "1", LastReported: augustAt(20, 8, 0), NumBikesDisabled: 2}},
+		// only observed for a bit, but disabled the whole time
+		"2": {{ID: "2", LastReported: augustAt(21, 8, 0), NumBikesDisabled: 1, NumDocksDisabled: 3}},
+	}
+	bikes, docks := DisabledPerWeek(statuses, augustAt(26, 0, 0))
+	if len(bikes) != 1 || len(docks) != 1 {
+		t.Fatalf("expected one week, got %d and %d", len(bikes), len(docks))
+	}
+	if bikes[0].Data != 3 || docks[0].Data != 3 {
+		t.Errorf("expected 3 bikes and 3 docks, got %v and %v", bikes[0].Data, docks[0].Data)
+	}
+	if bikes, _ := DisabledPerWeek(statuses, augustAt(25, 0, 0)); len(bikes) != 0 {
+		t.Errorf("expected the partial week to be left out, got %d points", len(bikes))
+	}
+}
+
+func TestStationsDisabledFixture(t *testing.T) {
+	statuses, err := gobike.LoadCapacityDir(filepath.Join("testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := time.Date(2018, time.August, 27, 0, 0, 0, 0, time.UTC)
+	stations := StationsDisabled(StatusMap(statuses), end.Add(-7*24*time.Hour), end)
+	if len(stations) == 0 {
+		t.Fatal("no stations")
+	}
+	for i := 1; i < len(stations); i++ {
+		if stations[i].Bikes+stations[i].Docks > stations[i-1].Bikes+stations[i-1].Docks {
+			t.Fatalf("stations not sorted at %d", i)
+		}
+	}
+	bikes, _ := LongestDisabledStreaks(stations)
+	if len(bikes) == 0 {
+		t.Fatal("expected some disabled bikes in the fixture")
+	}
+	for i := 1; i < len(bikes); i++ {
+		if bikes[i].Duration() > bikes[i-1].Duration() {
+			t.Fatalf("streaks not sorted at %d", i)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// HasEBike reports whether a rider could rent an e-bike from the station.
+func HasEBike(ss *gobike.StationStatus) bool {
+	return ss.IsInstalled && ss.IsRenting && ss.NumEBikesAvailable > 0
+}
+
+// EBikeMorning is the part of weekdays that e-bike depletion is measured
+// over: riders take the e-bikes on the way to work, and they're rarely
+// brought back before the evening.
+var EBikeMorning = TimeOfDay{"Morning", 6, 10}
+
+// StationEBikes is how many e-bikes a station had over a period, and how
+// quickly they ran out on weekday mornings.
+type StationEBikes struct {
+	StationID string
+	// Observed is how long the station's status was known, and WithEBike is
+	// how much of that it had an e-bike to rent.
+	Observed, WithEBike time.Duration
+	// EBikes and Bikes are the average number of e-bikes, and of all bikes,
+	// available while the station was observed.
+	EBikes, Bikes float64
+	// Mornings is the number of weekday mornings the station had an e-bike
+	// at the start of EBikeMorning, and Depletions is how long after the
+	// start it ran out of them, for each morning it did before the end.
+	Mornings   int
+	Depletions []time.Duration
+}
+
+// Share returns the percentage of the station's available bikes that were
+// e-bikes, or 0 if it never had any bikes.
+func (s *StationEBikes) Share() float64 {
+	if s.Bikes == 0 {
+		return 0
+	}
+	return 100 * s.EBikes / s.Bikes
+}
+
+// AvailablePct returns the percentage of the time the station had an e-bike
+// to rent.
+func (s *StationEBikes) AvailablePct() float64 {
+	if s.Observed == 0 {
+		return 0
+	}
+	return 100 * float64(s.WithEBike) / float64(s.Observed)
+}
+
+// DepletedPct returns the percentage of Mornings the station ran out of
+// e-bikes.
+func (s *StationEBikes) DepletedPct() float64 {
+	if s.Mornings == 0 {
+		return 0
+	}
+	return 100 * float64(len(s.Depletions)) / float64(s.Mornings)
+}
+
+// MedianDepletion returns the median time it took the station to run out of
+// e-bikes, on the mornings it did, or 0 if it never did.
+func (s *StationEBikes) MedianDepletion() time.Duration {
+	return medianDuration(s.Depletions)
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(durations))
+	for i := range durations {
+		sorted[i] = float64(durations[i])
+	}
+	sort.Float64s(sorted)
+	return time.Duration(percentile(sorted, 50))
+}
+
+// depletion reports how long after start the station ran out of e-bikes.
+// had is false if it didn't have one at start, and ok is false if it didn't
+// run out before end.
+func depletion(statuses []*gobike.StationStatus, start, end time.Time) (d time.Duration, had, ok bool) {
+	ss, known := stateAt(statuses, start)
+	if !known || !HasEBike(ss) {
+		return 0, false, false
+	}
+	i := sort.Search(len(statuses), func(i int) bool {
+		return statuses[i].LastReported.After(start)
+	})
+	for ; i < len(statuses) && statuses[i].LastReported.Before(end); i++ {
+		if !HasEBike(statuses[i]) {
+			return statuses[i].LastReported.Sub(start), true, true
+		}
+	}
+	return 0, true, false
+}
+
+// StationEBikesBetween computes a station's StationEBikes between start and
+// end, from its statuses sorted by time. Each status is assumed to hold until
+// the next one, or for an hour, whichever comes first. Only weekday mornings
+// that aren't holidays or events count toward Mornings.
+func StationEBikesBetween(id string, statuses []*gobike.StationStatus, start, end time.Time) *StationEBikes {
+	tzOnce.Do(populateTZ)
+	se := &StationEBikes{StationID: id}
+	var ebikeTime, bikeTime float64
+	eachSpan(statuses, start, end, func(ss *gobike.StationStatus, from, to time.Time) {
+		d := to.Sub(from)
+		se.Observed += d
+		if HasEBike(ss) {
+			se.WithEBike += d
+		}
+		ebikeTime += float64(ss.NumEBikesAvailable) * float64(d)
+		bikeTime += float64(ss.NumBikesAvailable) * float64(d)
+	})
+	if se.Observed > 0 {
+		se.EBikes = ebikeTime / float64(se.Observed)
+		se.Bikes = bikeTime / float64(se.Observed)
+	}
+	for day := Day.Truncate(start, time.Sunday); day.Before(end); day = Day.Next(day) {
+		if !workday(day) {
+			continue
+		}
+		mornStart := time.Date(day.Year(), day.Month(), day.Day(), EBikeMorning.Start, 0, 0, 0, tz)
+		mornEnd := time.Date(day.Year(), day.Month(), day.Day(), EBikeMorning.End, 0, 0, 0, tz)
+		if mornStart.Before(start) || mornEnd.After(end) {
+			continue
+		}
+		d, had, ok := depletion(statuses, mornStart, mornEnd)
+		if !had {
+			continue
+		}
+		se.Mornings++
+		if ok {
+			se.Depletions = append(se.Depletions, d)
+		}
+	}
+	return se
+}
+
+// StationsEBikes computes the StationEBikes of every station in statuses, a
+// map like the one returned by StatusMap, between start and end. Stations
+// that were never observed are left out, and the rest are sorted by ID.
+func StationsEBikes(statuses map[string][]*gobike.StationStatus, start, end time.Time) []*StationEBikes {
+	result := make([]*StationEBikes, 0, len(statuses))
+	for id := range statuses {
+		se := StationEBikesBetween(id, statuses[id], start, end)
+		if se.Observed > 0 {
+			result = append(result, se)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StationID < result[j].StationID
+	})
+	return result
+}
+
+// SummarizeEBikes adds up the mornings of every station in stations.
+// depleted is the percentage of mornings a station ran out of e-bikes, and
+// median is how long it took on the median morning it did.
+func SummarizeEBikes(stations []*StationEBikes) (mornings int, depleted float64, median time.Duration) {
+	var all []time.Duration
+	for _, se := range stations {
+		mornings += se.Mornings
+		all = append(all, se.Depletions...)
+	}
+	if mornings > 0 {
+		depleted = 100 * float64(len(all)) / float64(mornings)
+	}
+	return mornings, depleted, medianDuration(all)
+}
+
+// EBikesPer returns, for each bucket, the percentage of available bikes at
+// the stations in statuses that were e-bikes, and the percentage of
+// station-minutes a station had an e-bike to rent, counting time like
+// UsabilityPer.
+func EBikesPer(statuses map[string][]*gobike.StationStatus, g Granularity, end time.Time) (share, available TimeSeries) {
+	share = make(TimeSeries, 0)
+	available = make(TimeSeries, 0)
+	for _, b := range sumStatuses(statuses, g, end, func(ss *gobike.StationStatus) []float64 {
+		return []float64{boolValue(HasEBike(ss)), float64(ss.NumEBikesAvailable), float64(ss.NumBikesAvailable)}
+	}) {
+		observed, withEBike := b.total(0)
+		if observed == 0 {
+			continue
+		}
+		_, ebikes := b.total(1)
+		_, bikes := b.total(2)
+		var s float64
+		if bikes > 0 {
+			s = 100 * ebikes / bikes
+		}
+		share = append(share, &TimeStat{Date: b.start, Data: s})
+		available = append(available, &TimeStat{Date: b.start, Data: 100 * withEBike / observed})
+	}
+	return share, available
+}
+
+// EBikesPerWeek returns the e-bike share of available bikes, and the share of
+// the time stations had an e-bike, in each week that ends by end. Weeks start
+// on Sunday, like TripsPerWeek.
+func EBikesPerWeek(statuses map[string][]*gobike.StationStatus, end time.Time) (share, available TimeSeries) {
+	return EBikesPer(statuses, Week, end)
+}
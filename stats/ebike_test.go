@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestStationEBikes(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	withEBikes := func(t time.Time, bikes, ebikes int16) *gobike.StationStatus {
+		ss := testStatus("1", t, bikes, 10)
+		ss.NumEBikesAvailable = ebikes
+		return ss
+	}
+	statuses := []*gobike.StationStatus{
+		// Monday: two e-bikes at 6, gone by 7:30
+		withEBikes(augustAt(20, 5, 30), 4, 2),
+		withEBikes(augustAt(20, 7, 0), 3, 1),
+		withEBikes(augustAt(20, 7, 30), 2, 0),
+		// Tuesday: one e-bike all morning
+		withEBikes(augustAt(21, 5, 30), 4, 1),
+		withEBikes(augustAt(21, 6, 30), 4, 1),
+		withEBikes(augustAt(21, 7, 30), 4, 1),
+		withEBikes(augustAt(21, 8, 30), 4, 1),
+		withEBikes(augustAt(21, 9, 30), 4, 1),
+		// Wednesday: no e-bikes at 6, so the morning doesn't count
+		withEBikes(augustAt(22, 5, 30), 4, 0),
+		withEBikes(augustAt(22, 6, 30), 4, 1),
+		// Saturday: weekends don't count either
+		withEBikes(augustAt(25, 5, 30), 4, 1),
+		withEBikes(augustAt(25, 6, 30), 4, 0),
+	}
+	se := StationEBikesBetween("1", statuses, augustAt(19, 0, 0), augustAt(26, 0, 0))
+	if se.Mornings != 2 {
+		t.Errorf("expected 2 mornings, got %d", se.Mornings)
+	}
+	if len(se.Depletions) != 1 || se.Depletions[0] != 90*time.Minute {
+		t.Errorf("expected to run out after 90 minutes on Monday, got %v", se.Depletions)
+	}
+	if se.DepletedPct() != 50 || se.MedianDepletion() != 90*time.Minute {
+		t.Errorf("bad summary: %v%% after %v", se.DepletedPct(), se.MedianDepletion())
+	}
+	if share := se.Share(); share <= 0 || share >= 100 {
+		t.Errorf("bad e-bike share: %v", share)
+	}
+	if se.WithEBike > se.Observed {
+		t.Errorf("had an e-bike %v out of %v observed", se.WithEBike, se.Observed)
+	}
+
+	// Monday's morning is cut off, so it doesn't count
+	se = StationEBikesBetween("1", statuses, augustAt(20, 7, 0), augustAt(26, 0, 0))
+	if se.Mornings != 1 || len(se.Depletions) != 0 {
+		t.Errorf("expected only Tuesday, got %d mornings and %v", se.Mornings, se.Depletions)
+	}
+	mornings, depleted, median := SummarizeEBikes([]*StationEBikes{
+		StationEBikesBetween("1", statuses, augustAt(19, 0, 0), augustAt(26, 0, 0)),
+		se,
+	})
+	if mornings != 3 || depleted != 100.0/3 || median != 90*time.Minute {
+		t.Errorf("bad summary: %d mornings, %v%% depleted, median %v", mornings, depleted, median)
+	}
+}
+
+func TestEBikesPerWeek(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	statuses := map[string][]*gobike.StationStatus{
+		"1": {{ID: "1", LastReported: augustAt(20, 8, 0), NumBikesAvailable: 4, NumEBikesAvailable: 1, IsInstalled: true, IsRenting: true}},
+		"2": {{ID: "2", LastReported: augustAt(20, 8, 0), NumBikesAvailable: 4, IsInstalled: true, IsRenting: true}},
+	}
+	share, available := EBikesPerWeek(statuses, augustAt(26, 0, 0))
+	if len(share) != 1 || len(available) != 1 {
+		t.Fatalf("expected one week, got %d and %d", len(share), len(available))
+	}
+	if share[0].Data != 12.5 || available[0].Data != 50 {
+		t.Errorf("expected 12.5%% e-bikes available 50%% of the time, got %v and %v", share[0].Data, available[0].Data)
+	}
+}
+
+func TestStationsEBikesFixture(t *testing.T) {
+	statuses, err := gobike.LoadCapacityDir(filepath.Join("testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := time.Date(2018, time.August, 27, 0, 0, 0, 0, time.UTC)
+	stations := StationsEBikes(StatusMap(statuses), end.Add(-7*24*time.Hour), end)
+	if len(stations) == 0 {
+		t.Fatal("no stations")
+	}
+	var withEBikes int
+	for _, se := range stations {
+		if se.EBikes > se.Bikes {
+			t.Errorf("station %s: more e-bikes than bikes", se.StationID)
+		}
+		if se.WithEBike > 0 {
+			withEBikes++
+		}
+		// the fixture only covers a weekend
+		if se.Mornings != 0 {
+			t.Errorf("station %s: expected no weekday mornings, got %d", se.StationID, se.Mornings)
+		}
+	}
+	if withEBikes == 0 {
+		t.Error("expected some stations to have e-bikes")
+	}
+}
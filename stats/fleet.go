@@ -0,0 +1,215 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+const (
+	// maintenanceGap is the longest a bike in service usually goes without a
+	// trip. A longer gap suggests it was being repaired, or was stolen.
+	maintenanceGap = 7 * 24 * time.Hour
+	// missingAfter is how long a bike has to go without a trip before we
+	// assume it's been pulled from service.
+	missingAfter = 30 * 24 * time.Hour
+	// farReappearance is the distance, in miles, a bike has to move between
+	// trips for it to be suspicious; rebalancing vans rarely take bikes this
+	// far.
+	farReappearance = 3.0
+)
+
+// BikeStatus is whether a bike still seems to be in service at the end of the
+// data.
+type BikeStatus int
+
+const (
+	// BikeActive means the bike was ridden in the last maintenanceGap.
+	BikeActive BikeStatus = iota
+	// BikeIdle means the bike wasn't ridden in the last maintenanceGap, but
+	// was in the last missingAfter.
+	BikeIdle
+	// BikeMissing means the bike hasn't been ridden in missingAfter, and has
+	// probably been pulled from service.
+	BikeMissing
+)
+
+func (s BikeStatus) String() string {
+	switch s {
+	case BikeIdle:
+		return "idle"
+	case BikeMissing:
+		return "missing"
+	default:
+		return "active"
+	}
+}
+
+// Gap is a time a bike went longer than maintenanceGap without a trip.
+type Gap struct {
+	// The bike's last trip before the gap ended at After, at FromStationID,
+	// and its next trip started at Before, at ToStationID.
+	After, Before time.Time
+	FromStationID string
+	ToStationID   string
+}
+
+func (g *Gap) Duration() time.Duration {
+	return g.Before.Sub(g.After)
+}
+
+// BikeHistory is everything the trip data says about one bike.
+type BikeHistory struct {
+	BikeID int64
+	// FirstSeen is the start of the bike's first trip and LastSeen is the end
+	// of its last one.
+	FirstSeen, LastSeen time.Time
+	Trips               int
+	// Miles is the straight line distance of the bike's trips. Trips without
+	// a location aren't counted.
+	Miles float64
+	// LastStationID is where the bike's last trip ended; it may be empty if
+	// the bike was left away from a station.
+	LastStationID string
+	// LongestIdle is the longest time the bike went between trips.
+	LongestIdle time.Duration
+	// Gaps are the times the bike went longer than a week without a trip.
+	Gaps []*Gap
+	// Reappearances are the times the bike started a trip far from where its
+	// last trip ended. Trips to and from the depot aren't counted.
+	Reappearances []*Move
+	// Status is whether the bike is still in service, as of the last trip in
+	// the data.
+	Status BikeStatus
+}
+
+// Flagged reports whether anything about the bike's history is worth a
+// closer look: a long gap, a far reappearance, or not being seen lately.
+func (b *BikeHistory) Flagged() bool {
+	return len(b.Gaps) > 0 || len(b.Reappearances) > 0 || b.Status != BikeActive
+}
+
+func tripMiles(t *gobike.Trip) float64 {
+	if t.StartStationLatitude == 0 && t.StartStationLongitude == 0 ||
+		t.EndStationLatitude == 0 && t.EndStationLongitude == 0 {
+		return 0
+	}
+	return t.Distance()
+}
+
+func newBikeHistory(trips []*gobike.Trip, dataEnd time.Time) *BikeHistory {
+	first, last := trips[0], trips[len(trips)-1]
+	b := &BikeHistory{
+		BikeID:        first.BikeID,
+		FirstSeen:     first.StartTime,
+		LastSeen:      last.EndTime,
+		Trips:         len(trips),
+		LastStationID: last.EndStationID,
+	}
+	if docklessEnd(last.EndStationID, last.EndStationName) {
+		b.LastStationID = ""
+	}
+	for i, t := range trips {
+		b.Miles += tripMiles(t)
+		if t.EndTime.After(b.LastSeen) {
+			b.LastSeen = t.EndTime
+		}
+		if i == 0 {
+			continue
+		}
+		prev := trips[i-1]
+		idle := t.StartTime.Sub(prev.EndTime)
+		if idle > b.LongestIdle {
+			b.LongestIdle = idle
+		}
+		if idle > maintenanceGap {
+			b.Gaps = append(b.Gaps, &Gap{
+				After:         prev.EndTime,
+				Before:        t.StartTime,
+				FromStationID: prev.EndStationID,
+				ToStationID:   t.StartStationID,
+			})
+		}
+		if m := inferMove(prev, t); m != nil && m.Kind != DepotMove && m.Distance >= farReappearance {
+			b.Reappearances = append(b.Reappearances, m)
+		}
+	}
+	switch since := dataEnd.Sub(b.LastSeen); {
+	case since > missingAfter:
+		b.Status = BikeMissing
+	case since > maintenanceGap:
+		b.Status = BikeIdle
+	}
+	return b
+}
+
+// BikeHistories reconstructs the history of every bike in trips, ordered by
+// bike ID. Whether a bike is still in service is judged as of the start of
+// the last trip in the data.
+func BikeHistories(trips []*gobike.Trip) []*BikeHistory {
+	var dataEnd time.Time
+	for _, t := range trips {
+		if t.StartTime.After(dataEnd) {
+			dataEnd = t.StartTime
+		}
+	}
+	sorted := sortByBike(trips)
+	histories := make([]*BikeHistory, 0)
+	start := 0
+	for i := 1; i <= len(sorted); i++ {
+		if i < len(sorted) && sorted[i].BikeID == sorted[start].BikeID {
+			continue
+		}
+		histories = append(histories, newBikeHistory(sorted[start:i], dataEnd))
+		start = i
+	}
+	return histories
+}
+
+// FleetSummary describes every bike in the trip data.
+type FleetSummary struct {
+	Bikes                  int
+	Active, Idle, Missing  int
+	Trips                  int
+	Miles                  float64
+	MedianTripsPerBike     float64
+	MedianMilesPerBike     float64
+	BikesWithGaps          int
+	BikesWithReappearances int
+}
+
+// SummarizeFleet adds up histories, as returned by BikeHistories.
+func SummarizeFleet(histories []*BikeHistory) *FleetSummary {
+	s := &FleetSummary{Bikes: len(histories)}
+	if len(histories) == 0 {
+		return s
+	}
+	trips := make([]float64, len(histories))
+	miles := make([]float64, len(histories))
+	for i, b := range histories {
+		switch b.Status {
+		case BikeActive:
+			s.Active++
+		case BikeIdle:
+			s.Idle++
+		case BikeMissing:
+			s.Missing++
+		}
+		s.Trips += b.Trips
+		s.Miles += b.Miles
+		if len(b.Gaps) > 0 {
+			s.BikesWithGaps++
+		}
+		if len(b.Reappearances) > 0 {
+			s.BikesWithReappearances++
+		}
+		trips[i] = float64(b.Trips)
+		miles[i] = b.Miles
+	}
+	sort.Float64s(trips)
+	sort.Float64s(miles)
+	s.MedianTripsPerBike = percentile(trips, 50)
+	s.MedianMilesPerBike = percentile(miles, 50)
+	return s
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestBikeHistories(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	at := func(month time.Month, day int) time.Time {
+		return time.Date(2018, month, day, 8, 0, 0, 0, tz)
+	}
+	downtown := [2]float64{37.7749, -122.4194}
+	nearby := [2]float64{37.7849, -122.4094}
+	sanJose := [2]float64{37.3382, -121.8863}
+	trip := func(bike int64, start time.Time, fromID string, from [2]float64, toID string, to [2]float64) *gobike.Trip {
+		return &gobike.Trip{
+			BikeID: bike, StartTime: start, EndTime: start.Add(15 * time.Minute),
+			StartStationID: fromID, StartStationLatitude: from[0], StartStationLongitude: from[1],
+			EndStationID: toID, EndStationLatitude: to[0], EndStationLongitude: to[1],
+		}
+	}
+	trips := []*gobike.Trip{
+		// bike 1 is ridden every few days
+		trip(1, at(time.August, 1), "1", downtown, "2", nearby),
+		trip(1, at(time.August, 3), "2", nearby, "1", downtown),
+		trip(1, at(time.August, 31), "1", downtown, "2", nearby),
+		// bike 2 disappears for a while, then turns up in San Jose
+		trip(2, at(time.August, 1), "1", downtown, "2", nearby),
+		trip(2, at(time.August, 20), "300", sanJose, "300", sanJose),
+		// bike 3 hasn't been seen since July
+		trip(3, at(time.July, 15), "1", downtown, "1", downtown),
+	}
+	histories := BikeHistories(trips)
+	if len(histories) != 3 {
+		t.Fatalf("expected 3 bikes, got %d", len(histories))
+	}
+	one, two, three := histories[0], histories[1], histories[2]
+	if one.BikeID != 1 || one.Trips != 3 || !one.FirstSeen.Equal(at(time.August, 1)) || one.LastStationID != "2" {
+		t.Errorf("bad history for bike 1: %+v", one)
+	}
+	if one.Miles < 2.5 || one.Miles > 2.8 {
+		t.Errorf("expected about 2.6 miles, got %v", one.Miles)
+	}
+	if len(one.Gaps) != 1 || one.Gaps[0].Duration() < 27*24*time.Hour || one.Status != BikeActive {
+		t.Errorf("expected one long gap for bike 1, got %d", len(one.Gaps))
+	}
+	if one.LongestIdle != one.Gaps[0].Duration() {
+		t.Errorf("expected the gap to be the longest idle time, got %v", one.LongestIdle)
+	}
+	if len(two.Reappearances) != 1 || two.Reappearances[0].ToStationID != "300" || two.Status != BikeIdle {
+		t.Errorf("expected bike 2 to reappear in San Jose and be idle, got %d, %s", len(two.Reappearances), two.Status)
+	}
+	if three.Status != BikeMissing || !three.Flagged() {
+		t.Errorf("expected bike 3 to be missing, got %s", three.Status)
+	}
+
+	summary := SummarizeFleet(histories)
+	if summary.Bikes != 3 || summary.Active != 1 || summary.Idle != 1 || summary.Missing != 1 {
+		t.Errorf("bad status counts: %+v", summary)
+	}
+	if summary.Trips != 6 || summary.MedianTripsPerBike != 2 {
+		t.Errorf("bad trip counts: %+v", summary)
+	}
+	if summary.BikesWithGaps != 2 || summary.BikesWithReappearances != 1 {
+		t.Errorf("bad flag counts: %+v", summary)
+	}
+}
@@ -0,0 +1,248 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// TimeOfDay is part of a day, from the Start hour up to the End hour, in the
+// Bay Area.
+type TimeOfDay struct {
+	Name       string
+	Start, End int
+}
+
+// TimesOfDay split the day into the periods when bikes tend to flow the same
+// way, like toward downtown in the morning and away from it in the evening.
+var TimesOfDay = []TimeOfDay{
+	{"Overnight", 0, 6},
+	{"Morning", 6, 10},
+	{"Midday", 10, 15},
+	{"Evening", 15, 19},
+	{"Night", 19, 24},
+}
+
+// FlowClass describes which way bikes usually flow at a station.
+type FlowClass int
+
+const (
+	Balanced FlowClass = iota
+	// Source stations lose bikes: more trips start there than end there.
+	Source
+	// Sink stations gain bikes: more trips end there than start there.
+	Sink
+)
+
+func (c FlowClass) String() string {
+	switch c {
+	case Source:
+		return "source"
+	case Sink:
+		return "sink"
+	default:
+		return "balanced"
+	}
+}
+
+// A station is a chronic source or sink during a time of day if it loses (or
+// gains) at least minChronicFlow bikes per day on average, and loses (or
+// gains) bikes on at least chronicDayFraction of the days.
+const (
+	minChronicFlow     = 2
+	chronicDayFraction = 2.0 / 3
+)
+
+// StationFlow is the flow of bikes in and out of a station on weekdays, by hour
+// of the day. Weekends, holidays and events are left out, since bikes flow in
+// different directions on them.
+type StationFlow struct {
+	Station *gobike.Station
+	// Days is the number of weekdays covered by the trips.
+	Days int
+	// Trips that ended and started at the station, by hour of the day.
+	Arrivals   [24]int
+	Departures [24]int
+
+	// ObservedDays is the number of Days covered by the station's capacity
+	// logs.
+	ObservedDays int
+	// Observed is the change in the number of bikes docked at the station on
+	// ObservedDays, by hour of the day.
+	Observed [24]int
+	// observedTrips is arrivals minus departures on ObservedDays.
+	observedTrips [24]int
+
+	// Classes describes the station during each of TimesOfDay.
+	Classes []FlowClass
+}
+
+// NetFlow returns the average number of bikes per day the station gains from
+// trips (arrivals minus departures) during hour.
+func (f *StationFlow) NetFlow(hour int) float64 {
+	if f.Days == 0 {
+		return 0
+	}
+	return float64(f.Arrivals[hour]-f.Departures[hour]) / float64(f.Days)
+}
+
+// Rebalanced returns the average number of bikes per day added to (or, if
+// negative, taken from) the station during hour by something other than a
+// trip: usually a rebalancing van, a bike being repaired, or a trip that isn't
+// in the data yet. It returns 0 if there are no capacity logs for the station.
+func (f *StationFlow) Rebalanced(hour int) float64 {
+	if f.ObservedDays == 0 {
+		return 0
+	}
+	return float64(f.Observed[hour]-f.observedTrips[hour]) / float64(f.ObservedDays)
+}
+
+// NetFlowDuring returns the average number of bikes per day the station gains
+// from trips during tod.
+func (f *StationFlow) NetFlowDuring(tod TimeOfDay) float64 {
+	var sum float64
+	for h := tod.Start; h < tod.End; h++ {
+		sum += f.NetFlow(h)
+	}
+	return sum
+}
+
+// RebalancedDuring returns the average number of bikes per day added to the
+// station by something other than a trip during tod.
+func (f *StationFlow) RebalancedDuring(tod TimeOfDay) float64 {
+	var sum float64
+	for h := tod.Start; h < tod.End; h++ {
+		sum += f.Rebalanced(h)
+	}
+	return sum
+}
+
+// Imbalance returns the number of bikes per day that would have to be moved
+// to or from the station to keep it level through every time of day.
+func (f *StationFlow) Imbalance() float64 {
+	var sum float64
+	for _, tod := range TimesOfDay {
+		n := f.NetFlowDuring(tod)
+		if n < 0 {
+			n = -n
+		}
+		sum += n
+	}
+	return sum
+}
+
+// Chronic reports whether the station is a source or a sink during any time
+// of day.
+func (f *StationFlow) Chronic() bool {
+	for _, c := range f.Classes {
+		if c != Balanced {
+			return true
+		}
+	}
+	return false
+}
+
+func classify(flow map[int64]*[24]int, days map[int64]bool, tod TimeOfDay) FlowClass {
+	if len(days) == 0 {
+		return Balanced
+	}
+	var sum, losing, gaining int
+	for day := range days {
+		hours, ok := flow[day]
+		if !ok {
+			continue
+		}
+		net := 0
+		for h := tod.Start; h < tod.End; h++ {
+			net += hours[h]
+		}
+		sum += net
+		switch {
+		case net < 0:
+			losing++
+		case net > 0:
+			gaining++
+		}
+	}
+	avg := float64(sum) / float64(len(days))
+	enough := chronicDayFraction * float64(len(days))
+	switch {
+	case avg <= -minChronicFlow && float64(losing) >= enough:
+		return Source
+	case avg >= minChronicFlow && float64(gaining) >= enough:
+		return Sink
+	}
+	return Balanced
+}
+
+// docked returns the number of bikes in the docks at a station, whether or not
+// they can be rented.
+func docked(ss *gobike.StationStatus) int {
+	return int(ss.NumBikesAvailable) + int(ss.NumBikesDisabled)
+}
+
+// observe adds the change in docked bikes between each pair of statuses to the
+// hour of the later one, on days in days. statuses should be sorted, as they
+// are by StatusMap.
+func (f *StationFlow) observe(statuses []*gobike.StationStatus, flow map[int64]*[24]int, days map[int64]bool) {
+	observedDays := make(map[int64]bool)
+	for j := 1; j < len(statuses); j++ {
+		t := statuses[j].LastReported.In(tz)
+		day := Day.Truncate(t, time.Sunday).Unix()
+		if !days[day] {
+			continue
+		}
+		observedDays[day] = true
+		f.Observed[t.Hour()] += docked(statuses[j]) - docked(statuses[j-1])
+	}
+	f.ObservedDays = len(observedDays)
+	for day := range observedDays {
+		if hours, ok := flow[day]; ok {
+			for h := range hours {
+				f.observedTrips[h] += hours[h]
+			}
+		}
+	}
+}
+
+// StationFlows returns the weekday flow of bikes in and out of every station
+// with trips, most imbalanced first. statuses, a map like the one returned by
+// StatusMap, is used to compare the flow from trips with the change in the
+// number of bikes at each station; it may be nil.
+func StationFlows(stationMap map[string]*gobike.Station, trips []*gobike.Trip, statuses map[string][]*gobike.StationStatus) []*StationFlow {
+	tzOnce.Do(populateTZ)
+	// the weekdays with trips
+	days := make(map[int64]bool)
+	agg := aggregateStations(stationMap, trips, func(t *gobike.Trip) bool {
+		day := Day.Truncate(t.StartTime, time.Sunday)
+		if !workday(day) {
+			return false
+		}
+		days[day.Unix()] = true
+		return true
+	})
+	flows := make([]*StationFlow, 0, len(agg))
+	for id, a := range agg {
+		f := &StationFlow{
+			Station:    a.Station,
+			Days:       len(days),
+			Arrivals:   a.Arrivals,
+			Departures: a.Departures,
+			Classes:    make([]FlowClass, len(TimesOfDay)),
+		}
+		for i, tod := range TimesOfDay {
+			f.Classes[i] = classify(a.Flow, days, tod)
+		}
+		f.observe(statuses[id], a.Flow, days)
+		flows = append(flows, f)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		ii, ji := flows[i].Imbalance(), flows[j].Imbalance()
+		if ii != ji {
+			return ii > ji
+		}
+		return flows[i].Station.ID < flows[j].Station.ID
+	})
+	return flows
+}
@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestStationFlows(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	stationMap := map[string]*gobike.Station{
+		"1": {ID: 1, Name: "Caltrain"},
+		"2": {ID: 2, Name: "Financial District"},
+	}
+	trips := make([]*gobike.Trip, 0)
+	// Monday the 6th through Friday the 10th
+	for day := 6; day <= 10; day++ {
+		for i := 0; i < 3; i++ {
+			trips = append(trips, &gobike.Trip{
+				StartTime: augustAt(day, 8, 0), EndTime: augustAt(day, 8, 15),
+				StartStationID: "1", EndStationID: "2",
+			})
+		}
+	}
+	// weekends don't count
+	for i := 0; i < 10; i++ {
+		trips = append(trips, &gobike.Trip{
+			StartTime: augustAt(11, 8, 0), EndTime: augustAt(11, 8, 15),
+			StartStationID: "2", EndStationID: "1",
+		})
+	}
+	statuses := StatusMap([]*gobike.StationStatus{
+		{ID: "1", NumBikesAvailable: 5, LastReported: augustAt(6, 7, 0)},
+		{ID: "1", NumBikesAvailable: 2, LastReported: augustAt(6, 8, 30)},
+		// a van drops off four bikes
+		{ID: "1", NumBikesAvailable: 5, NumBikesDisabled: 1, LastReported: augustAt(6, 11, 0)},
+	})
+	flows := StationFlows(stationMap, trips, statuses)
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 stations, got %d", len(flows))
+	}
+	source, sink := flows[0], flows[1]
+	if source.Station.ID != 1 || sink.Station.ID != 2 {
+		t.Fatalf("bad order: %d, %d", source.Station.ID, sink.Station.ID)
+	}
+	if source.Days != 5 {
+		t.Errorf("expected 5 weekdays, got %d", source.Days)
+	}
+	if n := source.NetFlow(8); n != -3 {
+		t.Errorf("expected a net flow of -3 bikes at 8am, got %v", n)
+	}
+	if n := sink.NetFlowDuring(TimesOfDay[1]); n != 3 {
+		t.Errorf("expected a morning net flow of 3 bikes, got %v", n)
+	}
+	if source.Imbalance() != 3 {
+		t.Errorf("expected an imbalance of 3, got %v", source.Imbalance())
+	}
+	for i, tod := range TimesOfDay {
+		wantSource, wantSink := Balanced, Balanced
+		if tod.Name == "Morning" {
+			wantSource, wantSink = Source, Sink
+		}
+		if source.Classes[i] != wantSource || sink.Classes[i] != wantSink {
+			t.Errorf("%s: got %s and %s, want %s and %s", tod.Name, source.Classes[i], sink.Classes[i], wantSource, wantSink)
+		}
+	}
+	if !source.Chronic() {
+		t.Error("expected station 1 to be chronic")
+	}
+
+	if source.ObservedDays != 1 || sink.ObservedDays != 0 {
+		t.Errorf("expected 1 and 0 observed days, got %d and %d", source.ObservedDays, sink.ObservedDays)
+	}
+	if source.Observed[8] != -3 || source.Rebalanced(8) != 0 {
+		t.Errorf("expected trips to explain the 8am change, got %d observed, %v rebalanced", source.Observed[8], source.Rebalanced(8))
+	}
+	if n := source.RebalancedDuring(TimesOfDay[2]); n != 4 {
+		t.Errorf("expected 4 bikes rebalanced at midday, got %v", n)
+	}
+	if n := sink.Rebalanced(8); n != 0 {
+		t.Errorf("expected no rebalancing without capacity logs, got %v", n)
+	}
+}
+
+func TestClassifyOccasional(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	days := map[int64]bool{1: true, 2: true, 3: true}
+	// one very busy day isn't chronic
+	flow := map[int64]*[24]int{1: {8: -30}}
+	if c := classify(flow, days, TimesOfDay[1]); c != Balanced {
+		t.Errorf("expected balanced, got %s", c)
+	}
+	flow[2] = &[24]int{8: -1}
+	if c := classify(flow, days, TimesOfDay[1]); c != Source {
+		t.Errorf("expected source, got %s", c)
+	}
+}
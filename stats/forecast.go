@@ -0,0 +1,260 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// ForecastHorizons are how far ahead Forecaster predicts by default.
+var ForecastHorizons = []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour}
+
+// stateAt returns the status a station was in at t, from statuses sorted by
+// time. ok is false if the station hadn't reported in maxStatusAge.
+func stateAt(statuses []*gobike.StationStatus, t time.Time) (ss *gobike.StationStatus, ok bool) {
+	i := sort.Search(len(statuses), func(i int) bool {
+		return statuses[i].LastReported.After(t)
+	})
+	if i == 0 {
+		return nil, false
+	}
+	ss = statuses[i-1]
+	if t.Sub(ss.LastReported) > maxStatusAge {
+		return nil, false
+	}
+	return ss, true
+}
+
+// seasonalProfile is the average change in bikes at a station over each slot
+// of the week.
+type seasonalProfile struct {
+	sum   [SlotsPerWeek]float64
+	count [SlotsPerWeek]int
+}
+
+// Forecaster predicts how many bikes and docks a station will have a short
+// time from now. The prediction is a blend of a seasonal baseline, the
+// average change in bikes at the station at the same time of the week, and
+// the station's recent trend, which stands in for the trips happening now
+// (trip data is only published once a month). Call UseFlows to start the
+// seasonal baseline on weekdays from the station's past trips.
+type Forecaster struct {
+	// TrendWeight is how much the recent trend counts, from 0 (only the
+	// seasonal baseline) to 1 (only the trend).
+	TrendWeight float64
+	// TrendWindow is how far back to look for the recent trend.
+	TrendWindow time.Duration
+	// FlowWeight is how many weeks of capacity history the net flow of trips
+	// passed to UseFlows counts as in the seasonal baseline.
+	FlowWeight float64
+
+	profiles map[string]*seasonalProfile
+	flows    map[string]*StationFlow
+}
+
+// NewForecaster learns the seasonal baseline for every station from its
+// statuses before end. statuses is a map like the one returned by StatusMap.
+func NewForecaster(statuses map[string][]*gobike.StationStatus, end time.Time) *Forecaster {
+	f := &Forecaster{
+		TrendWeight: 0.5,
+		TrendWindow: 30 * time.Minute,
+		FlowWeight:  1,
+		profiles:    make(map[string]*seasonalProfile, len(statuses)),
+	}
+	for id := range statuses {
+		if p := trainProfile(statuses[id], end); p != nil {
+			f.profiles[id] = p
+		}
+	}
+	return f
+}
+
+// UseFlows uses the hourly net flow of trips at each station, from
+// StationFlows, as a prior for the seasonal baseline on weekdays. It matters
+// most for stations and times of the week with little capacity history. The
+// flows should only come from trips before the end passed to NewForecaster.
+func (f *Forecaster) UseFlows(flows []*StationFlow) {
+	f.flows = make(map[string]*StationFlow, len(flows))
+	for _, flow := range flows {
+		f.flows[strconv.Itoa(flow.Station.ID)] = flow
+	}
+}
+
+// delta returns the average change in bikes at the station id during the
+// slot t falls in.
+func (f *Forecaster) delta(id string, t time.Time) float64 {
+	slot := Slot(t)
+	var sum, count float64
+	if p := f.profiles[id]; p != nil {
+		sum, count = p.sum[slot], float64(p.count[slot])
+	}
+	if flow, ok := f.flows[id]; ok && f.FlowWeight > 0 && flow.Days > 0 && workday(t) {
+		prior := flow.NetFlow(t.In(tz).Hour()) / float64(time.Hour/SlotDuration)
+		sum += f.FlowWeight * prior
+		count += f.FlowWeight
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+func trainProfile(statuses []*gobike.StationStatus, end time.Time) *seasonalProfile {
+	if len(statuses) == 0 {
+		return nil
+	}
+	p := new(seasonalProfile)
+	start := statuses[0].LastReported.Truncate(SlotDuration).Add(SlotDuration)
+	for t := start; !t.Add(SlotDuration).After(end); t = t.Add(SlotDuration) {
+		from, ok := stateAt(statuses, t)
+		if !ok {
+			continue
+		}
+		to, ok := stateAt(statuses, t.Add(SlotDuration))
+		if !ok {
+			continue
+		}
+		slot := Slot(t)
+		p.sum[slot] += float64(to.NumBikesAvailable - from.NumBikesAvailable)
+		p.count[slot]++
+	}
+	return p
+}
+
+// Forecast is a prediction of a station's bikes and docks.
+type Forecast struct {
+	StationID string
+	// At is the time the prediction was made, and Horizon is how far ahead
+	// of it the prediction is for.
+	At      time.Time
+	Horizon time.Duration
+	// Current is the station's status at At.
+	Current *gobike.StationStatus
+	Bikes   float64
+	Docks   float64
+}
+
+// Empty reports whether the station is predicted to have no bikes to rent.
+func (f *Forecast) Empty() bool {
+	return f.Bikes < 0.5
+}
+
+// Full reports whether the station is predicted to have no docks free.
+func (f *Forecast) Full() bool {
+	return f.Docks < 0.5
+}
+
+// Predict forecasts the station's bikes and docks horizon after now, from
+// its statuses up to now, sorted by time. ok is false if the station hasn't
+// reported recently.
+func (f *Forecaster) Predict(id string, statuses []*gobike.StationStatus, now time.Time, horizon time.Duration) (fc *Forecast, ok bool) {
+	current, ok := stateAt(statuses, now)
+	if !ok {
+		return nil, false
+	}
+	bikes := float64(current.NumBikesAvailable)
+	capacity := bikes + float64(current.NumDocksAvailable)
+
+	var seasonal float64
+	for t := now; t.Before(now.Add(horizon)); t = t.Add(SlotDuration) {
+		seasonal += f.delta(id, t)
+	}
+	var trend float64
+	if past, ok := stateAt(statuses, now.Add(-f.TrendWindow)); ok && f.TrendWindow > 0 {
+		rate := (bikes - float64(past.NumBikesAvailable)) / float64(f.TrendWindow)
+		trend = rate * float64(horizon)
+	}
+	predicted := bikes + (1-f.TrendWeight)*seasonal + f.TrendWeight*trend
+	predicted = math.Max(0, math.Min(capacity, predicted))
+	return &Forecast{
+		StationID: id,
+		At:        now,
+		Horizon:   horizon,
+		Current:   current,
+		Bikes:     predicted,
+		Docks:     capacity - predicted,
+	}, true
+}
+
+// BacktestResult is how well a Forecaster predicted the bikes at every
+// station, for one horizon.
+type BacktestResult struct {
+	Horizon time.Duration
+	// Predictions is the number of predictions that could be checked.
+	Predictions int
+	// MAE and RMSE are the mean absolute error and root mean squared error
+	// of the predicted bikes.
+	MAE, RMSE float64
+	// PersistenceMAE is the mean absolute error of assuming nothing changes,
+	// which the forecast should beat.
+	PersistenceMAE float64
+	// PredictedEmpty is the number of predictions of an empty station, and
+	// ActualEmpty is the number of times the station was empty.
+	PredictedEmpty, ActualEmpty int
+	// EmptyPrecision is the share of PredictedEmpty that were empty, and
+	// EmptyRecall is the share of ActualEmpty that were predicted. Each is 0
+	// if the count it's a share of is 0.
+	EmptyPrecision, EmptyRecall float64
+}
+
+// Backtest replays statuses, a map like the one returned by StatusMap. Every
+// step between start and end it uses f to predict each station's bikes at
+// each of horizons, and compares them with what happened. f should only have
+// learned from statuses before start.
+func Backtest(f *Forecaster, statuses map[string][]*gobike.StationStatus, start, end time.Time, step time.Duration, horizons []time.Duration) []*BacktestResult {
+	ids := make([]string, 0, len(statuses))
+	for id := range statuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	results := make([]*BacktestResult, len(horizons))
+	for i, horizon := range horizons {
+		r := &BacktestResult{Horizon: horizon}
+		var absSum, sqSum, persistenceSum float64
+		var bothEmpty int
+		for now := start; !now.Add(horizon).After(end); now = now.Add(step) {
+			for _, id := range ids {
+				fc, ok := f.Predict(id, statuses[id], now, horizon)
+				if !ok {
+					continue
+				}
+				actual, ok := stateAt(statuses[id], now.Add(horizon))
+				if !ok {
+					continue
+				}
+				diff := fc.Bikes - float64(actual.NumBikesAvailable)
+				absSum += math.Abs(diff)
+				sqSum += diff * diff
+				persistenceSum += math.Abs(float64(fc.Current.NumBikesAvailable - actual.NumBikesAvailable))
+				empty := actual.Empty()
+				if fc.Empty() {
+					r.PredictedEmpty++
+				}
+				if empty {
+					r.ActualEmpty++
+				}
+				if fc.Empty() && empty {
+					bothEmpty++
+				}
+				r.Predictions++
+			}
+		}
+		if r.PredictedEmpty > 0 {
+			r.EmptyPrecision = float64(bothEmpty) / float64(r.PredictedEmpty)
+		}
+		if r.ActualEmpty > 0 {
+			r.EmptyRecall = float64(bothEmpty) / float64(r.ActualEmpty)
+		}
+		if r.Predictions > 0 {
+			n := float64(r.Predictions)
+			r.MAE = absSum / n
+			r.RMSE = math.Sqrt(sqSum / n)
+			r.PersistenceMAE = persistenceSum / n
+		}
+		results[i] = r
+	}
+	return results
+}
@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// commuterStation returns a station with 10 bikes and 10 docks that loses a
+// bike every 15 minutes from 8 to 9am every day, and gets them back by van at
+// noon.
+func commuterStation(days int) []*gobike.StationStatus {
+	start := time.Date(2018, time.August, 1, 0, 0, 0, 0, tz)
+	statuses := make([]*gobike.StationStatus, 0)
+	for t := start; t.Before(start.AddDate(0, 0, days)); t = t.Add(5 * time.Minute) {
+		bikes := 10
+		local := t.In(tz)
+		minutes := local.Hour()*60 + local.Minute()
+		switch {
+		case minutes >= 8*60 && minutes < 9*60:
+			bikes -= (minutes-8*60)/15 + 1
+		case minutes >= 9*60 && minutes < 12*60:
+			bikes = 6
+		}
+		statuses = append(statuses, &gobike.StationStatus{
+			ID: "1", LastReported: t, NumBikesAvailable: int16(bikes), NumDocksAvailable: int16(20 - bikes),
+			IsInstalled: true, IsRenting: true, IsReturning: true,
+		})
+	}
+	return statuses
+}
+
+func TestForecaster(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	statuses := map[string][]*gobike.StationStatus{"1": commuterStation(15)}
+	trainEnd := time.Date(2018, time.August, 15, 0, 0, 0, 0, tz)
+	f := NewForecaster(statuses, trainEnd)
+	// just before the morning rush on the 15th
+	now := time.Date(2018, time.August, 15, 7, 59, 0, 0, tz)
+	fc, ok := f.Predict("1", statuses["1"], now, time.Hour)
+	if !ok {
+		t.Fatal("expected a forecast")
+	}
+	// the trend is flat, so the forecast is halfway between staying at 10 and
+	// the seasonal 6.
+	if fc.Bikes != 8 || fc.Docks != 12 {
+		t.Errorf("expected 8 bikes and 12 docks, got %v and %v", fc.Bikes, fc.Docks)
+	}
+	f.TrendWeight = 0
+	fc, _ = f.Predict("1", statuses["1"], now, time.Hour)
+	if fc.Bikes != 6 {
+		t.Errorf("expected the seasonal baseline to predict 6 bikes, got %v", fc.Bikes)
+	}
+	// halfway through the rush, the trend predicts the station runs dry
+	f.TrendWeight = 1
+	fc, _ = f.Predict("1", statuses["1"], now.Add(30*time.Minute), 2*time.Hour)
+	if !fc.Empty() || fc.Full() {
+		t.Errorf("expected an empty station, got %v bikes", fc.Bikes)
+	}
+	if _, ok := f.Predict("1", statuses["1"], time.Date(2018, time.August, 20, 0, 0, 0, 0, tz), time.Hour); ok {
+		t.Error("expected no forecast long after the last status")
+	}
+}
+
+func TestForecasterFlows(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	statuses := map[string][]*gobike.StationStatus{"1": commuterStation(15)}
+	// no capacity history to learn from
+	start := statuses["1"][0].LastReported
+	f := NewForecaster(statuses, start)
+	f.TrendWeight = 0
+	flow := &StationFlow{Station: &gobike.Station{ID: 1}, Days: 2}
+	// on weekdays the station loses 4 bikes from 8 to 9am to trips
+	flow.Departures[8] = 8
+	f.UseFlows([]*StationFlow{flow})
+	// a Wednesday
+	now := time.Date(2018, time.August, 15, 7, 59, 0, 0, tz)
+	fc, _ := f.Predict("1", statuses["1"], now, 75*time.Minute)
+	if fc.Bikes != 6 {
+		t.Errorf("expected the trip flow to predict 6 bikes, got %v", fc.Bikes)
+	}
+	// a Saturday
+	fc, _ = f.Predict("1", statuses["1"], now.AddDate(0, 0, -4), 75*time.Minute)
+	if fc.Bikes != 10 {
+		t.Errorf("expected no change on a Saturday, got %v bikes", fc.Bikes)
+	}
+
+	// with capacity history, the flow counts as one more week of it
+	f = NewForecaster(statuses, time.Date(2018, time.August, 15, 0, 0, 0, 0, tz))
+	f.TrendWeight = 0
+	flow.Departures[8] = 0
+	f.UseFlows([]*StationFlow{flow})
+	fc, _ = f.Predict("1", statuses["1"], now, 75*time.Minute)
+	// two weeks saw the station lose 4 bikes, and the flow says it loses none
+	if math.Abs(fc.Bikes-(10-4*2.0/3)) > 1e-9 {
+		t.Errorf("expected %v bikes, got %v", 10-4*2.0/3, fc.Bikes)
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	statuses := map[string][]*gobike.StationStatus{"1": commuterStation(15)}
+	trainEnd := time.Date(2018, time.August, 14, 0, 0, 0, 0, tz)
+	f := NewForecaster(statuses, trainEnd)
+	results := Backtest(f, statuses, trainEnd, trainEnd.AddDate(0, 0, 1), 15*time.Minute, ForecastHorizons)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Predictions == 0 {
+			t.Fatalf("%v: no predictions", r.Horizon)
+		}
+		if r.MAE >= r.PersistenceMAE {
+			t.Errorf("%v: expected to beat persistence, got MAE %.2f vs %.2f", r.Horizon, r.MAE, r.PersistenceMAE)
+		}
+		if r.RMSE < r.MAE {
+			t.Errorf("%v: RMSE %.2f is less than MAE %.2f", r.Horizon, r.RMSE, r.MAE)
+		}
+		// the station never runs out
+		if r.ActualEmpty != 0 || r.EmptyRecall != 0 {
+			t.Errorf("%v: expected no empty stations, got %d and recall %v", r.Horizon, r.ActualEmpty, r.EmptyRecall)
+		}
+	}
+	if results[0].Predictions != 4*24 {
+		t.Errorf("expected a prediction every 15 minutes, got %d", results[0].Predictions)
+	}
+}
+
+func TestBacktestFixture(t *testing.T) {
+	statuses, err := gobike.LoadCapacityDir(filepath.Join("testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byStation := StatusMap(statuses)
+	trainEnd := time.Date(2018, time.August, 26, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2018, time.August, 26, 23, 0, 0, 0, time.UTC)
+	results := Backtest(NewForecaster(byStation, trainEnd), byStation, trainEnd, end, 30*time.Minute, ForecastHorizons)
+	for _, r := range results {
+		if r.Predictions == 0 || math.IsNaN(r.MAE) || r.MAE < 0 {
+			t.Errorf("%v: bad result %+v", r.Horizon, r)
+		}
+	}
+}
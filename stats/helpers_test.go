@@ -0,0 +1,33 @@
+package stats
+
+import (
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// augustAt returns a time on the given day of August 2018 in the Bay Area.
+// Days past 31 run into September.
+func augustAt(day, hour, minute int) time.Time {
+	tzOnce.Do(populateTZ)
+	return time.Date(2018, time.August, day, hour, minute, 0, 0, tz)
+}
+
+// testStatus returns a status for an installed station that is renting and
+// returning, reported at t with bikes and docks available.
+func testStatus(id string, t time.Time, bikes, docks int16) *gobike.StationStatus {
+	return &gobike.StationStatus{
+		ID: id, LastReported: t, NumBikesAvailable: bikes, NumDocksAvailable: docks,
+		IsInstalled: true, IsRenting: true, IsReturning: true,
+	}
+}
+
+// testTrip returns a trip on bike that starts at start and lasts minutes.
+func testTrip(bike int64, start time.Time, minutes int, from, to string) *gobike.Trip {
+	return &gobike.Trip{
+		BikeID: bike, StartTime: start, EndTime: start.Add(time.Duration(minutes) * time.Minute),
+		StartStationID: from, StartStationName: "station " + from,
+		EndStationID: to, EndStationName: "station " + to,
+		UserType: "Customer",
+	}
+}
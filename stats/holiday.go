@@ -0,0 +1,59 @@
+package stats
+
+import "time"
+
+// Holiday is a public holiday, when ridership looks more like a weekend than
+// a weekday.
+type Holiday struct {
+	Name string
+	// Date is midnight at the start of the day the holiday is observed, in
+	// the Bay Area.
+	Date time.Time
+}
+
+// nthWeekday returns the nth (1-based) weekday of a month. If n is -1, it
+// returns the last one.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	if n == -1 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, tz)
+		offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+		return time.Date(year, month+1, -offset, 0, 0, 0, 0, tz)
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, tz)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return time.Date(year, month, 1+offset+7*(n-1), 0, 0, 0, 0, tz)
+}
+
+// observed moves a holiday that falls on a weekend to the nearest weekday.
+func observed(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// Holidays returns the holidays observed by most Bay Area employers in year,
+// in order.
+func Holidays(year int) []Holiday {
+	tzOnce.Do(populateTZ)
+	thanksgiving := nthWeekday(year, time.November, time.Thursday, 4)
+	return []Holiday{
+		{"New Year's Day", observed(time.Date(year, time.January, 1, 0, 0, 0, 0, tz))},
+		{"Martin Luther King Jr. Day", nthWeekday(year, time.January, time.Monday, 3)},
+		{"Presidents' Day", nthWeekday(year, time.February, time.Monday, 3)},
+		{"Memorial Day", nthWeekday(year, time.May, time.Monday, -1)},
+		{"Independence Day", observed(time.Date(year, time.July, 4, 0, 0, 0, 0, tz))},
+		{"Labor Day", nthWeekday(year, time.September, time.Monday, 1)},
+		{"Thanksgiving", thanksgiving},
+		{"Day after Thanksgiving", thanksgiving.AddDate(0, 0, 1)},
+		{"Christmas Day", observed(time.Date(year, time.December, 25, 0, 0, 0, 0, tz))},
+	}
+}
+
+// IsHoliday reports whether t falls on a holiday returned by Holidays.
+func IsHoliday(t time.Time) bool {
+	return len(holidaysOn(t)) > 0
+}
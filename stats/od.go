@@ -0,0 +1,380 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/geo"
+)
+
+// ODLevel is the kind of zone an ODMatrix counts trips between.
+type ODLevel int
+
+const (
+	StationLevel ODLevel = iota
+	CityLevel
+	// DistrictLevel counts trips between San Francisco supervisor districts.
+	// Trips that start or end outside San Francisco are skipped.
+	DistrictLevel
+)
+
+var odLevelNames = [...]string{"station", "city", "district"}
+
+func (l ODLevel) String() string {
+	if l < 0 || int(l) >= len(odLevelNames) {
+		return fmt.Sprintf("ODLevel(%d)", int(l))
+	}
+	return odLevelNames[l]
+}
+
+func (l ODLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// ParseODLevel parses the name of an ODLevel, like "city".
+func ParseODLevel(s string) (ODLevel, error) {
+	for i, name := range odLevelNames {
+		if s == name {
+			return ODLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown level %q (use station, city or district)", s)
+}
+
+// ODFilter chooses the trips that go in an ODMatrix. The zero value matches
+// every trip.
+type ODFilter struct {
+	// If set, only count trips that start at or after Start, and before End.
+	Start, End time.Time
+	// If set, only count trips by this type of user, e.g. "Subscriber" or
+	// "Customer".
+	UserType string
+	// If set, only count trips that start in one of these hours of the day
+	// (0-23, in the Bay Area).
+	Hours []int
+}
+
+func (f *ODFilter) matches(t *gobike.Trip) bool {
+	if !f.Start.IsZero() && t.StartTime.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && !t.StartTime.Before(f.End) {
+		return false
+	}
+	if f.UserType != "" && t.UserType != f.UserType {
+		return false
+	}
+	if len(f.Hours) > 0 {
+		hour := t.StartTime.In(tz).Hour()
+		for _, h := range f.Hours {
+			if h == hour {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ODZone is a place trips start or end: a station, a city, or a district.
+// Latitude and Longitude are the average location of the stations in the zone
+// that trips used, weighted by trips.
+type ODZone struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+
+	latSum, lonSum float64
+	points         int
+}
+
+// add counts a trip end at lat, long toward the zone's location. It's a no-op
+// on a nil zone.
+func (zone *ODZone) add(lat, long float64) {
+	if zone == nil {
+		return
+	}
+	zone.latSum += lat
+	zone.lonSum += long
+	zone.points++
+}
+
+// ODFlow is the number of trips from one zone to another.
+type ODFlow struct {
+	From  *ODZone
+	To    *ODZone
+	Trips int
+}
+
+type odPair struct {
+	from, to string
+}
+
+// ODMatrix counts trips between every pair of zones.
+type ODMatrix struct {
+	Level ODLevel
+	// Skipped is the number of trips that matched the filter but started or
+	// ended outside every zone.
+	Skipped int
+
+	zones  map[string]*ODZone
+	counts map[odPair]int
+	total  int
+}
+
+// zoneFinder maps a trip end to a zone. Looking up the city or district for a
+// point is slow, so results are cached by station ID.
+type zoneFinder struct {
+	level      ODLevel
+	stationMap map[string]*gobike.Station
+	cache      map[string]stationZone
+	zones      map[string]*ODZone
+}
+
+// stationZone is the zone a station is in, and the station's location.
+type stationZone struct {
+	zone      *ODZone
+	lat, long float64
+}
+
+func (z *zoneFinder) zone(id, name string) *ODZone {
+	if zone, ok := z.zones[id]; ok {
+		return zone
+	}
+	zone := &ODZone{ID: id, Name: name}
+	z.zones[id] = zone
+	return zone
+}
+
+func (z *zoneFinder) polygonZone(lat, long float64) *ODZone {
+	switch z.level {
+	case CityLevel:
+		for _, city := range geo.Cities {
+			if city.ContainsPoint(lat, long) {
+				return z.zone(city.Slug, city.Name)
+			}
+		}
+	case DistrictLevel:
+		for i, district := range geo.SFDistricts {
+			if district.ContainsPoint(lat, long) {
+				return z.zone("D"+strconv.Itoa(i+1), "District "+strconv.Itoa(i+1))
+			}
+		}
+	}
+	return nil
+}
+
+// find returns the zone for a trip end, or nil if it's not in one.
+func (z *zoneFinder) find(stationID, stationName string, lat, long float64) *ODZone {
+	if stationID != "" {
+		if sz, ok := z.cache[stationID]; ok {
+			sz.zone.add(sz.lat, sz.long)
+			return sz.zone
+		}
+	}
+	var zone *ODZone
+	station, ok := z.stationMap[stationID]
+	if ok {
+		lat, long = station.Latitude, station.Longitude
+		stationName = station.Name
+	}
+	if ok && z.level == CityLevel && station.City != nil {
+		zone = z.zone(station.City.Slug, station.City.Name)
+	} else if z.level == StationLevel {
+		// dockless trips don't have a station
+		if stationID != "" && !gobike.InternalStation(stationID) {
+			zone = z.zone(stationID, stationName)
+		}
+	} else if lat != 0 || long != 0 {
+		zone = z.polygonZone(lat, long)
+	}
+	if stationID != "" {
+		z.cache[stationID] = stationZone{zone: zone, lat: lat, long: long}
+	}
+	zone.add(lat, long)
+	return zone
+}
+
+// locate sets the location of every zone to the average location of the trip
+// ends found in it.
+func (z *zoneFinder) locate() {
+	for _, zone := range z.zones {
+		if zone.points > 0 {
+			zone.Latitude = zone.latSum / float64(zone.points)
+			zone.Longitude = zone.lonSum / float64(zone.points)
+		}
+	}
+}
+
+// NewODMatrix counts the trips that match filter between every pair of zones
+// at the given level. stationMap is used for station names and locations; if
+// a station isn't in it, the name and location from the trip are used.
+func NewODMatrix(trips []*gobike.Trip, stationMap map[string]*gobike.Station, level ODLevel, filter *ODFilter) *ODMatrix {
+	tzOnce.Do(populateTZ)
+	if filter == nil {
+		filter = new(ODFilter)
+	}
+	m := &ODMatrix{
+		Level:  level,
+		zones:  make(map[string]*ODZone),
+		counts: make(map[odPair]int),
+	}
+	finder := &zoneFinder{
+		level:      level,
+		stationMap: stationMap,
+		cache:      make(map[string]stationZone),
+		zones:      m.zones,
+	}
+	for _, t := range trips {
+		if !filter.matches(t) {
+			continue
+		}
+		from := finder.find(t.StartStationID, t.StartStationName, t.StartStationLatitude, t.StartStationLongitude)
+		to := finder.find(t.EndStationID, t.EndStationName, t.EndStationLatitude, t.EndStationLongitude)
+		if from == nil || to == nil {
+			m.Skipped++
+			continue
+		}
+		m.counts[odPair{from.ID, to.ID}]++
+		m.total++
+	}
+	finder.locate()
+	return m
+}
+
+// Trips returns the number of trips from one zone to another, by zone ID.
+func (m *ODMatrix) Trips(from, to string) int {
+	return m.counts[odPair{from, to}]
+}
+
+// Total returns the number of trips in the matrix.
+func (m *ODMatrix) Total() int {
+	return m.total
+}
+
+// Zones returns every zone a trip started or ended in, sorted by ID.
+func (m *ODMatrix) Zones() []*ODZone {
+	zones := make([]*ODZone, 0, len(m.zones))
+	for _, zone := range m.zones {
+		zones = append(zones, zone)
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].ID < zones[j].ID
+	})
+	return zones
+}
+
+// Flows returns every pair of zones with at least one trip between them, most
+// trips first.
+func (m *ODMatrix) Flows() []*ODFlow {
+	flows := make([]*ODFlow, 0, len(m.counts))
+	for pair, count := range m.counts {
+		flows = append(flows, &ODFlow{From: m.zones[pair.from], To: m.zones[pair.to], Trips: count})
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].Trips != flows[j].Trips {
+			return flows[i].Trips > flows[j].Trips
+		}
+		if flows[i].From.ID != flows[j].From.ID {
+			return flows[i].From.ID < flows[j].From.ID
+		}
+		return flows[i].To.ID < flows[j].To.ID
+	})
+	return flows
+}
+
+// WriteCSV writes one row per flow, with a header row:
+//
+//	from_id,from_name,to_id,to_name,trips
+func (m *ODMatrix) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"from_id", "from_name", "to_id", "to_name", "trips"}); err != nil {
+		return err
+	}
+	for _, f := range m.Flows() {
+		if err := cw.Write([]string{f.From.ID, f.From.Name, f.To.ID, f.To.Name, strconv.Itoa(f.Trips)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type odFlowJSON struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Trips int    `json:"trips"`
+}
+
+type odMatrixJSON struct {
+	Level   ODLevel       `json:"level"`
+	Total   int           `json:"total"`
+	Skipped int           `json:"skipped"`
+	Zones   []*ODZone     `json:"zones"`
+	Flows   []*odFlowJSON `json:"flows"`
+}
+
+func (m *ODMatrix) MarshalJSON() ([]byte, error) {
+	flows := m.Flows()
+	mj := &odMatrixJSON{
+		Level:   m.Level,
+		Total:   m.total,
+		Skipped: m.Skipped,
+		Zones:   m.Zones(),
+		Flows:   make([]*odFlowJSON, len(flows)),
+	}
+	for i, f := range flows {
+		mj.Flows[i] = &odFlowJSON{From: f.From.ID, To: f.To.ID, Trips: f.Trips}
+	}
+	return json.Marshal(mj)
+}
+
+type flowGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type flowFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   flowGeometry           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type flowCollection struct {
+	Type     string         `json:"type"`
+	Features []*flowFeature `json:"features"`
+}
+
+// WriteGeoJSON writes the flows as a GeoJSON FeatureCollection, with a
+// LineString from the origin to the destination of each flow. Trips that start
+// and end in the same zone are a Point.
+func (m *ODMatrix) WriteGeoJSON(w io.Writer) error {
+	flows := m.Flows()
+	fc := &flowCollection{Type: "FeatureCollection", Features: make([]*flowFeature, len(flows))}
+	for i, f := range flows {
+		from := []float64{f.From.Longitude, f.From.Latitude}
+		geom := flowGeometry{Type: "Point", Coordinates: from}
+		if f.From != f.To {
+			geom = flowGeometry{Type: "LineString", Coordinates: [][]float64{from, {f.To.Longitude, f.To.Latitude}}}
+		}
+		fc.Features[i] = &flowFeature{
+			Type:     "Feature",
+			Geometry: geom,
+			Properties: map[string]interface{}{
+				"from":      f.From.ID,
+				"from_name": f.From.Name,
+				"to":        f.To.ID,
+				"to_name":   f.To.Name,
+				"trips":     f.Trips,
+			},
+		}
+	}
+	return json.NewEncoder(w).Encode(fc)
+}
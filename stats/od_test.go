@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestODMatrix(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	stationMap := map[string]*gobike.Station{
+		"1": {ID: 1, Name: "Market St", Latitude: 37.7749, Longitude: -122.4194},
+		"2": {ID: 2, Name: "Mission St", Latitude: 37.7599, Longitude: -122.4148},
+		"3": {ID: 3, Name: "Broadway", Latitude: 37.8044, Longitude: -122.2712},
+	}
+	trip := func(from, to string, hour int, userType string) *gobike.Trip {
+		return &gobike.Trip{StartTime: augustAt(6, hour, 0), StartStationID: from, EndStationID: to, UserType: userType}
+	}
+	trips := []*gobike.Trip{
+		trip("1", "2", 8, "Subscriber"),
+		trip("1", "2", 8, "Subscriber"),
+		trip("1", "2", 17, "Customer"),
+		trip("2", "1", 9, "Subscriber"),
+		trip("3", "3", 12, "Customer"),
+		trip("1", "3", 8, "Subscriber"),
+		// dockless trip with no location
+		trip("", "1", 8, "Subscriber"),
+	}
+
+	m := NewODMatrix(trips, stationMap, StationLevel, nil)
+	if m.Total() != 6 || m.Skipped != 1 {
+		t.Errorf("expected 6 trips and 1 skipped, got %d and %d", m.Total(), m.Skipped)
+	}
+	if n := m.Trips("1", "2"); n != 3 {
+		t.Errorf("expected 3 trips from 1 to 2, got %d", n)
+	}
+	flows := m.Flows()
+	if flows[0].From.ID != "1" || flows[0].To.ID != "2" || flows[0].From.Name != "Market St" {
+		t.Errorf("expected the biggest flow first, got %s -> %s", flows[0].From.ID, flows[0].To.ID)
+	}
+
+	m = NewODMatrix(trips, stationMap, StationLevel, &ODFilter{UserType: "Subscriber", Hours: []int{8}})
+	if m.Total() != 3 || m.Trips("1", "2") != 2 {
+		t.Errorf("expected 3 morning subscriber trips, got %d", m.Total())
+	}
+	m = NewODMatrix(trips, stationMap, StationLevel, &ODFilter{Start: augustAt(6, 9, 0), End: augustAt(6, 17, 0)})
+	if m.Total() != 2 {
+		t.Errorf("expected 2 trips between 9am and 5pm, got %d", m.Total())
+	}
+
+	m = NewODMatrix(trips, stationMap, CityLevel, nil)
+	if n := m.Trips("sf", "sf"); n != 4 {
+		t.Errorf("expected 4 trips within San Francisco, got %d", n)
+	}
+	if n := m.Trips("sf", "oakland"); n != 1 {
+		t.Errorf("expected 1 trip from San Francisco to Oakland, got %d", n)
+	}
+	zones := m.Zones()
+	if len(zones) != 2 || zones[0].ID != "oakland" || zones[0].Latitude != 37.8044 {
+		t.Errorf("bad zones: %v", zones)
+	}
+	// six trips start or end at Market St, and four at Mission St
+	if lat := (6*37.7749 + 4*37.7599) / 10; math.Abs(zones[1].Latitude-lat) > 1e-9 {
+		t.Errorf("expected San Francisco to be weighted by trips, got latitude %v, want %v", zones[1].Latitude, lat)
+	}
+
+	m = NewODMatrix(trips, stationMap, DistrictLevel, nil)
+	if m.Total() != 4 || m.Skipped != 3 {
+		t.Errorf("expected 4 trips within districts and 3 skipped, got %d and %d", m.Total(), m.Skipped)
+	}
+	for _, zone := range m.Zones() {
+		if !strings.HasPrefix(zone.ID, "D") {
+			t.Errorf("bad district ID %q", zone.ID)
+		}
+	}
+}
+
+func TestODMatrixExport(t *testing.T) {
+	stationMap := map[string]*gobike.Station{
+		"1": {ID: 1, Name: "Market St", Latitude: 37.7749, Longitude: -122.4194},
+		"2": {ID: 2, Name: "Mission St", Latitude: 37.7599, Longitude: -122.4148},
+	}
+	trips := []*gobike.Trip{
+		{StartStationID: "1", EndStationID: "2"},
+		{StartStationID: "1", EndStationID: "2"},
+		{StartStationID: "2", EndStationID: "2"},
+	}
+	m := NewODMatrix(trips, stationMap, StationLevel, nil)
+	buf := new(bytes.Buffer)
+	if err := m.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "from_id,from_name,to_id,to_name,trips\n1,Market St,2,Mission St,2\n2,Mission St,2,Mission St,1\n"
+	if buf.String() != want {
+		t.Errorf("bad CSV:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mj struct {
+		Level string
+		Total int
+		Zones []*ODZone
+		Flows []struct {
+			From, To string
+			Trips    int
+		}
+	}
+	if err := json.Unmarshal(data, &mj); err != nil {
+		t.Fatal(err)
+	}
+	if mj.Level != "station" || mj.Total != 3 || len(mj.Zones) != 2 || len(mj.Flows) != 2 || mj.Flows[0].Trips != 2 {
+		t.Errorf("bad JSON: %s", data)
+	}
+
+	buf.Reset()
+	if err := m.WriteGeoJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 2 {
+		t.Fatalf("bad GeoJSON: %s", buf.String())
+	}
+	if fc.Features[0].Geometry.Type != "LineString" || fc.Features[1].Geometry.Type != "Point" {
+		t.Errorf("bad geometry types: %s", buf.String())
+	}
+	if string(fc.Features[0].Geometry.Coordinates) != "[[-122.4194,37.7749],[-122.4148,37.7599]]" {
+		t.Errorf("bad coordinates: %s", fc.Features[0].Geometry.Coordinates)
+	}
+}
+
+func TestParseODLevel(t *testing.T) {
+	for _, l := range []ODLevel{StationLevel, CityLevel, DistrictLevel} {
+		got, err := ParseODLevel(l.String())
+		if err != nil || got != l {
+			t.Errorf("ParseODLevel(%q): got %v, %v", l.String(), got, err)
+		}
+	}
+	if _, err := ParseODLevel("county"); err == nil {
+		t.Error("expected an error parsing a bad level")
+	}
+}
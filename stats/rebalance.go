@@ -0,0 +1,276 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// MoveKind is a guess at how a bike was moved between two trips.
+type MoveKind int
+
+const (
+	// TruckMove is a bike taken from one station to another one too far away
+	// to walk it, usually in a van.
+	TruckMove MoveKind = iota
+	// ValetMove is a bike moved a short distance, usually by valet staff
+	// clearing a full station into a corral, or filling a station nearby.
+	ValetMove
+	// DepotMove is a bike taken to or from the depot, usually for repairs.
+	DepotMove
+	// DocklessMove is a bike collected from, or left, away from a station.
+	DocklessMove
+)
+
+var moveKindNames = [...]string{"truck", "valet", "depot", "dockless"}
+
+func (k MoveKind) String() string {
+	if k < 0 || int(k) >= len(moveKindNames) {
+		return "unknown"
+	}
+	return moveKindNames[k]
+}
+
+const (
+	// valetDistance is the farthest, in miles, staff will walk a bike.
+	valetDistance = 0.25
+	// minDocklessMove is the distance, in miles, a dockless bike has to move
+	// between trips to count as moved, since GPS locations wander.
+	minDocklessMove = 0.05
+)
+
+// Move is a bike that started a trip somewhere other than where its previous
+// trip ended, so someone moved it in between.
+type Move struct {
+	BikeID int64
+	Kind   MoveKind
+	// The station the bike was taken from and the station it was taken to.
+	// Either may be empty for a dockless bike.
+	FromStationID string
+	ToStationID   string
+	// Distance is the straight line distance the bike was moved, in miles.
+	Distance float64
+	// The bike was moved some time between After, the end of its previous
+	// trip, and Before, the start of its next one.
+	After  time.Time
+	Before time.Time
+}
+
+func moveDistance(prev, next *gobike.Trip) float64 {
+	if prev.EndStationLatitude == 0 && prev.EndStationLongitude == 0 ||
+		next.StartStationLatitude == 0 && next.StartStationLongitude == 0 {
+		return 0
+	}
+	between := gobike.Trip{
+		StartStationLatitude:  prev.EndStationLatitude,
+		StartStationLongitude: prev.EndStationLongitude,
+		EndStationLatitude:    next.StartStationLatitude,
+		EndStationLongitude:   next.StartStationLongitude,
+	}
+	return between.Distance()
+}
+
+func docklessEnd(id, name string) bool {
+	return id == "" || name == "NULL"
+}
+
+// inferMove returns the move between two consecutive trips on the same bike,
+// or nil if the bike didn't move.
+func inferMove(prev, next *gobike.Trip) *Move {
+	m := &Move{
+		BikeID:        next.BikeID,
+		FromStationID: prev.EndStationID,
+		ToStationID:   next.StartStationID,
+		Distance:      moveDistance(prev, next),
+		After:         prev.EndTime,
+		Before:        next.StartTime,
+	}
+	fromDockless := docklessEnd(prev.EndStationID, prev.EndStationName)
+	toDockless := docklessEnd(next.StartStationID, next.StartStationName)
+	switch {
+	case fromDockless || toDockless:
+		if fromDockless && toDockless && m.Distance < minDocklessMove {
+			return nil
+		}
+		if fromDockless {
+			m.FromStationID = ""
+		}
+		if toDockless {
+			m.ToStationID = ""
+		}
+		m.Kind = DocklessMove
+	case prev.EndStationID == next.StartStationID:
+		return nil
+	case gobike.InternalStation(prev.EndStationID) || gobike.InternalStation(next.StartStationID):
+		m.Kind = DepotMove
+	case m.Distance < valetDistance:
+		m.Kind = ValetMove
+	default:
+		m.Kind = TruckMove
+	}
+	return m
+}
+
+// sortByBike returns a copy of trips ordered by bike, then by start time.
+func sortByBike(trips []*gobike.Trip) []*gobike.Trip {
+	sorted := make([]*gobike.Trip, len(trips))
+	copy(sorted, trips)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].BikeID != sorted[j].BikeID {
+			return sorted[i].BikeID < sorted[j].BikeID
+		}
+		return sorted[i].StartTime.Before(sorted[j].StartTime)
+	})
+	return sorted
+}
+
+// inferMoves calls f with each trip that started somewhere other than where
+// the bike's previous trip ended, and the move before it. trips don't need to
+// be in order.
+func inferMoves(trips []*gobike.Trip, f func(t *gobike.Trip, m *Move)) {
+	sorted := sortByBike(trips)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].BikeID != sorted[i-1].BikeID {
+			continue
+		}
+		if m := inferMove(sorted[i-1], sorted[i]); m != nil {
+			f(sorted[i], m)
+		}
+	}
+}
+
+// InferMoves returns every time a bike started a trip somewhere other than
+// where its previous trip ended, ordered by Before.
+func InferMoves(trips []*gobike.Trip) []*Move {
+	moves := make([]*Move, 0)
+	inferMoves(trips, func(_ *gobike.Trip, m *Move) {
+		moves = append(moves, m)
+	})
+	sort.SliceStable(moves, func(i, j int) bool {
+		return moves[i].Before.Before(moves[j].Before)
+	})
+	return moves
+}
+
+// StationMoves counts the bikes moved away from and to a station.
+type StationMoves struct {
+	StationID string
+	Removed   int
+	Added     int
+	// ByKind counts Removed plus Added by MoveKind.
+	ByKind [len(moveKindNames)]int
+}
+
+// Net returns the number of bikes added to the station, less the number
+// removed.
+func (s *StationMoves) Net() int {
+	return s.Added - s.Removed
+}
+
+// MovesPerStation counts the moves to and from each station, most moves first.
+// Moves to or from a dockless location aren't counted against a station.
+func MovesPerStation(moves []*Move) []*StationMoves {
+	byID := make(map[string]*StationMoves)
+	get := func(id string) *StationMoves {
+		s, ok := byID[id]
+		if !ok {
+			s = &StationMoves{StationID: id}
+			byID[id] = s
+		}
+		return s
+	}
+	for _, m := range moves {
+		if m.FromStationID != "" {
+			s := get(m.FromStationID)
+			s.Removed++
+			s.ByKind[m.Kind]++
+		}
+		if m.ToStationID != "" {
+			s := get(m.ToStationID)
+			s.Added++
+			s.ByKind[m.Kind]++
+		}
+	}
+	result := make([]*StationMoves, 0, len(byID))
+	for _, s := range byID {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ti, tj := result[i].Added+result[i].Removed, result[j].Added+result[j].Removed
+		if ti != tj {
+			return ti > tj
+		}
+		return result[i].StationID < result[j].StationID
+	})
+	return result
+}
+
+// MoveRoute counts the moves from one station to another.
+type MoveRoute struct {
+	FromStationID string
+	ToStationID   string
+	Count         int
+}
+
+// MovesPerDirection counts the moves between each pair of stations, most
+// moves first. Dockless moves are counted with an empty station ID.
+func MovesPerDirection(moves []*Move) []*MoveRoute {
+	counts := make(map[odPair]int)
+	for _, m := range moves {
+		counts[odPair{m.FromStationID, m.ToStationID}]++
+	}
+	routes := make([]*MoveRoute, 0, len(counts))
+	for pair, count := range counts {
+		routes = append(routes, &MoveRoute{FromStationID: pair.from, ToStationID: pair.to, Count: count})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Count != routes[j].Count {
+			return routes[i].Count > routes[j].Count
+		}
+		if routes[i].FromStationID != routes[j].FromStationID {
+			return routes[i].FromStationID < routes[j].FromStationID
+		}
+		return routes[i].ToStationID < routes[j].ToStationID
+	})
+	return routes
+}
+
+// MovesPerDay returns the number of moves of the given kinds that finished on
+// each day, or moves of every kind if kinds is empty. A move is counted on the
+// day the bike's next trip started.
+func MovesPerDay(moves []*Move, kinds ...MoveKind) TimeSeries {
+	tzOnce.Do(populateTZ)
+	counts := make(map[int64]float64)
+	var first, last time.Time
+	for _, m := range moves {
+		if len(kinds) > 0 {
+			found := false
+			for _, k := range kinds {
+				if m.Kind == k {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		day := Day.Truncate(m.Before, time.Sunday)
+		counts[day.Unix()]++
+		if first.IsZero() || day.Before(first) {
+			first = day
+		}
+		if day.After(last) {
+			last = day
+		}
+	}
+	series := make(TimeSeries, 0)
+	if first.IsZero() {
+		return series
+	}
+	for day := first; !day.After(last); day = Day.Next(day) {
+		series = append(series, &TimeStat{Date: day, Data: counts[day.Unix()]})
+	}
+	return series
+}
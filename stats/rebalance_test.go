@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestInferMoves(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	type place struct {
+		id       string
+		lat, lon float64
+	}
+	caltrain := place{"30", 37.7766, -122.3955}
+	townsend := place{"81", 37.7774, -122.3930}
+	lakeMerritt := place{"200", 37.8004, -122.2664}
+	depot := place{gobike.DepotStationID, 37.7700, -122.4000}
+	street := place{"", 37.7800, -122.4100}
+	trip := func(bike int64, day, hour int, from, to place) *gobike.Trip {
+		return &gobike.Trip{
+			BikeID: bike, StartTime: augustAt(day, hour, 0), EndTime: augustAt(day, hour, 0).Add(10 * time.Minute),
+			StartStationID: from.id, StartStationLatitude: from.lat, StartStationLongitude: from.lon,
+			EndStationID: to.id, EndStationLatitude: to.lat, EndStationLongitude: to.lon,
+		}
+	}
+	// out of order, to check that moves don't depend on the order trips are
+	// loaded in.
+	trips := []*gobike.Trip{
+		trip(1, 7, 8, lakeMerritt, caltrain),
+		trip(1, 6, 8, caltrain, townsend),
+		trip(1, 6, 17, caltrain, townsend),
+		trip(2, 6, 9, caltrain, depot),
+		trip(2, 8, 9, caltrain, street),
+		trip(2, 8, 12, street, street),
+		trip(2, 9, 12, lakeMerritt, caltrain),
+	}
+	moves := InferMoves(trips)
+	want := []struct {
+		bike     int64
+		kind     MoveKind
+		from, to string
+	}{
+		{1, ValetMove, "81", "30"},
+		{1, TruckMove, "81", "200"},
+		{2, DepotMove, gobike.DepotStationID, "30"},
+		{2, DocklessMove, "", "200"},
+	}
+	if len(moves) != len(want) {
+		t.Fatalf("expected %d moves, got %d", len(want), len(moves))
+	}
+	for i, w := range want {
+		m := moves[i]
+		if m.BikeID != w.bike || m.Kind != w.kind || m.FromStationID != w.from || m.ToStationID != w.to {
+			t.Errorf("move %d: got bike %d %s %q -> %q, want bike %d %s %q -> %q", i, m.BikeID, m.Kind, m.FromStationID, m.ToStationID, w.bike, w.kind, w.from, w.to)
+		}
+	}
+	if !moves[0].After.Equal(augustAt(6, 8, 0).Add(10*time.Minute)) || !moves[0].Before.Equal(augustAt(6, 17, 0)) {
+		t.Errorf("bad window: %v to %v", moves[0].After, moves[0].Before)
+	}
+
+	stations := MovesPerStation(moves)
+	// ties are ordered by station ID
+	if len(stations) != 4 || stations[0].StationID != "200" || stations[3].StationID != gobike.DepotStationID {
+		t.Fatalf("bad station order: %v", stations)
+	}
+	caltrainMoves := stations[1]
+	if caltrainMoves.StationID != "30" || caltrainMoves.Added != 2 || caltrainMoves.Removed != 0 || caltrainMoves.Net() != 2 {
+		t.Errorf("bad station moves: %+v", caltrainMoves)
+	}
+	if n := caltrainMoves.ByKind[DepotMove]; n != 1 {
+		t.Errorf("expected 1 depot move at station 30, got %d", n)
+	}
+	routes := MovesPerDirection(moves)
+	if len(routes) != 4 || routes[0].Count != 1 {
+		t.Errorf("expected 4 routes with one move each, got %d", len(routes))
+	}
+
+	daily := MovesPerDay(moves)
+	if len(daily) != 4 || daily[0].Data != 1 || daily[2].Data != 1 || daily[3].Data != 1 {
+		t.Errorf("bad daily moves: %v", daily)
+	}
+	trucks := MovesPerDay(moves, TruckMove, ValetMove)
+	if len(trucks) != 2 || trucks[0].Data != 1 || trucks[1].Data != 1 {
+		t.Errorf("bad daily truck and valet moves: %v", trucks)
+	}
+	if weekly := MovesPer(trips, Day); weekly.Last() != 1 {
+		t.Errorf("expected 1 move on the last day, got %v", weekly.Last())
+	}
+}
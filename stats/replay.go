@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// Replay reconstructs the state of the system at every step between start
+// and end (inclusive) and calls f with it. byStation should be the output of
+// StatusMap. The state passed to f is the latest status for every station
+// that has reported at or before t, sorted by station ID; f should not modify
+// it.
+//
+// If f returns an error, Replay stops and returns it. Replay returns an error
+// without calling f if step isn't positive.
+func Replay(byStation map[string][]*gobike.StationStatus, start, end time.Time, step time.Duration, f func(t time.Time, state []*gobike.StationStatus) error) error {
+	if step <= 0 {
+		return fmt.Errorf("stats: Replay step must be positive, got %v", step)
+	}
+	ids := make([]string, 0, len(byStation))
+	for id := range byStation {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	// places[i] is the number of statuses for ids[i] at or before t.
+	places := make([]int, len(ids))
+	for t := start; !t.After(end); t = t.Add(step) {
+		state := make([]*gobike.StationStatus, 0, len(ids))
+		for i, id := range ids {
+			statuses := byStation[id]
+			for places[i] < len(statuses) && !statuses[places[i]].LastReported.After(t) {
+				places[i]++
+			}
+			if places[i] > 0 {
+				state = append(state, statuses[places[i]-1])
+			}
+		}
+		if err := f(t, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StatusChange describes how a station changed between two steps of a replay.
+// Old is nil if the station appeared, and New is nil if it disappeared.
+type StatusChange struct {
+	ID  string
+	Old *gobike.StationStatus
+	New *gobike.StationStatus
+}
+
+// sameState reports whether a and b have the same counts and flags. A station
+// that reports again without anything changing is not a change.
+func sameState(a, b *gobike.StationStatus) bool {
+	return a.NumBikesAvailable == b.NumBikesAvailable &&
+		a.NumEBikesAvailable == b.NumEBikesAvailable &&
+		a.NumBikesDisabled == b.NumBikesDisabled &&
+		a.NumDocksAvailable == b.NumDocksAvailable &&
+		a.NumDocksDisabled == b.NumDocksDisabled &&
+		a.IsInstalled == b.IsInstalled &&
+		a.IsRenting == b.IsRenting &&
+		a.IsReturning == b.IsReturning
+}
+
+// DiffStatuses returns the stations that changed between old and new, two
+// states passed to a Replay callback, sorted by station ID.
+func DiffStatuses(old, new []*gobike.StationStatus) []*StatusChange {
+	before := make(map[string]*gobike.StationStatus, len(old))
+	for _, ss := range old {
+		before[ss.ID] = ss
+	}
+	changes := make([]*StatusChange, 0)
+	for _, ss := range new {
+		prev, ok := before[ss.ID]
+		delete(before, ss.ID)
+		if ok && sameState(prev, ss) {
+			continue
+		}
+		changes = append(changes, &StatusChange{ID: ss.ID, Old: prev, New: ss})
+	}
+	for id, prev := range before {
+		changes = append(changes, &StatusChange{ID: id, Old: prev})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].ID < changes[j].ID
+	})
+	return changes
+}
@@ -0,0 +1,63 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2018, time.August, 26, 8, 0, 0, 0, time.UTC)
+	statuses := []*gobike.StationStatus{
+		{ID: "3", NumBikesAvailable: 5, LastReported: start.Add(-time.Hour)},
+		{ID: "3", NumBikesAvailable: 4, LastReported: start.Add(90 * time.Second)},
+		{ID: "4", NumBikesAvailable: 1, LastReported: start.Add(2 * time.Minute)},
+		{ID: "3", NumBikesAvailable: 4, LastReported: start.Add(150 * time.Second)},
+	}
+	var diffs [][]*StatusChange
+	var prev []*gobike.StationStatus
+	err := Replay(StatusMap(statuses), start, start.Add(3*time.Minute), time.Minute, func(now time.Time, state []*gobike.StationStatus) error {
+		if now.Equal(start) {
+			if len(state) != 1 || state[0].NumBikesAvailable != 5 {
+				return fmt.Errorf("bad state at start: %v", state)
+			}
+		}
+		diffs = append(diffs, DiffStatuses(prev, state))
+		prev = state
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 4 {
+		t.Fatalf("expected 4 steps, got %d", len(diffs))
+	}
+	if len(diffs[0]) != 1 || diffs[0][0].Old != nil || diffs[0][0].ID != "3" {
+		t.Errorf("expected station 3 to appear at the first step, got %v", diffs[0])
+	}
+	if len(diffs[1]) != 0 {
+		t.Errorf("expected no changes at 08:01, got %d", len(diffs[1]))
+	}
+	if len(diffs[2]) != 2 || diffs[2][0].New.NumBikesAvailable != 4 || diffs[2][1].ID != "4" {
+		t.Errorf("bad changes at 08:02: %v", diffs[2])
+	}
+	// station 3 reported again without changing
+	if len(diffs[3]) != 0 {
+		t.Errorf("expected no changes at 08:03, got %d", len(diffs[3]))
+	}
+	gone := DiffStatuses(prev, prev[:1])
+	if len(gone) != 1 || gone[0].ID != "4" || gone[0].New != nil {
+		t.Errorf("expected station 4 to disappear, got %v", gone)
+	}
+
+	called := false
+	err = Replay(StatusMap(statuses), start, start.Add(3*time.Minute), 0, func(time.Time, []*gobike.StationStatus) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("expected an error and no steps for a zero step, got %v", err)
+	}
+}
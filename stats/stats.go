@@ -38,274 +38,117 @@ func (t TimeSeries) MarshalJSON() ([]byte, error) {
 	return json.Marshal(a)
 }
 
+// Last returns the value of the most recent point in the series, or 0 if the
+// series is empty.
+func (t TimeSeries) Last() float64 {
+	if len(t) == 0 {
+		return 0
+	}
+	return t[len(t)-1].Data
+}
+
 type TimeStat struct {
 	Date time.Time
 	Data float64
 }
 
+func bikeID(t *gobike.Trip) string {
+	return strconv.FormatInt(t.BikeID, 10)
+}
+
+// TripsPer returns the number of trips in each bucket.
+func TripsPer(trips []*gobike.Trip, g Granularity) TimeSeries {
+	return Aggregate(trips, Query{Granularity: g})
+}
+
 func TripsPerWeek(trips []*gobike.Trip) TimeSeries {
-	weekBeforeEnd := sevenDaysBeforeDataEnd(trips)
-	lastSunday := time.Date(weekBeforeEnd.Year(), weekBeforeEnd.Month(), weekBeforeEnd.Day()+(7-int(weekBeforeEnd.Weekday())), 0, 0, 0, 0, tz)
-	mp := make(map[string]int)
-	earliest := time.Date(3000, time.January, 1, 0, 0, 0, 0, tz)
-	for i := 0; i < len(trips); i++ {
-		start := trips[i].StartTime
-		wday := start.Weekday()
-		sunday := time.Date(start.Year(), start.Month(), start.Day()-int(wday), 0, 0, 0, 0, tz)
-		if sunday.Equal(lastSunday) || sunday.After(lastSunday) {
-			continue
-		}
-		if _, ok := mp[sunday.Format("2006-01-02")]; ok {
-			mp[sunday.Format("2006-01-02")] += 1
-		} else {
-			mp[sunday.Format("2006-01-02")] = 1
-		}
-		if sunday.Before(earliest) {
-			earliest = sunday
-		}
-	}
-	seen := 0
-	result := make([]*TimeStat, 0)
-	for i := earliest; ; i = time.Date(i.Year(), i.Month(), i.Day()+7, 0, 0, 0, 0, tz) {
-		count, ok := mp[i.Format("2006-01-02")]
-		if ok {
-			seen++
-		}
-		result = append(result, &TimeStat{Date: i, Data: float64(count)})
-		if seen >= len(mp) {
-			break
-		}
+	return TripsPer(trips, Week)
+}
+
+// movedBike returns a filter that reports whether a trip started somewhere
+// other than where the bike's previous trip ended, which means someone moved
+// the bike in between.
+func movedBike(trips []*gobike.Trip) func(*gobike.Trip) bool {
+	moved := make(map[*gobike.Trip]bool)
+	inferMoves(trips, func(t *gobike.Trip, _ *Move) {
+		moved[t] = true
+	})
+	return func(t *gobike.Trip) bool {
+		return moved[t]
 	}
-	return result
+}
+
+// MovesPer returns the number of times a bike was moved between trips in each
+// bucket.
+func MovesPer(trips []*gobike.Trip, g Granularity) TimeSeries {
+	return Aggregate(trips, Query{Granularity: g, Filter: movedBike(trips)})
 }
 
 func MovesPerWeek(trips []*gobike.Trip) TimeSeries {
-	weekBeforeEnd := sevenDaysBeforeDataEnd(trips)
-	lastSunday := time.Date(weekBeforeEnd.Year(), weekBeforeEnd.Month(), weekBeforeEnd.Day()+(7-int(weekBeforeEnd.Weekday())), 0, 0, 0, 0, tz)
-	mp := make(map[string]int)
-	stationEnd := make(map[int64]string)
-	earliest := time.Date(3000, time.January, 1, 0, 0, 0, 0, tz)
-	for i := 0; i < len(trips); i++ {
-		lastTripEnd, ok := stationEnd[trips[i].BikeID]
-		// cached old trip end - set new one now to avoid branching
-		stationEnd[trips[i].BikeID] = trips[i].EndStationID
-		if !ok {
-			continue
-		}
-		if trips[i].StartStationID == lastTripEnd {
-			continue
-		}
-		start := trips[i].StartTime
-		wday := start.Weekday()
-		sunday := time.Date(start.Year(), start.Month(), start.Day()-int(wday), 0, 0, 0, 0, tz)
-		if sunday.Equal(lastSunday) || sunday.After(lastSunday) {
-			continue
-		}
-		if _, ok := mp[sunday.Format("2006-01-02")]; ok {
-			mp[sunday.Format("2006-01-02")] += 1
-		} else {
-			mp[sunday.Format("2006-01-02")] = 1
-		}
-		if sunday.Before(earliest) {
-			earliest = sunday
-		}
-	}
-	seen := 0
-	result := make([]*TimeStat, 0)
-	for i := earliest; ; i = time.Date(i.Year(), i.Month(), i.Day()+7, 0, 0, 0, 0, tz) {
-		count, ok := mp[i.Format("2006-01-02")]
-		if ok {
-			seen++
-		}
-		result = append(result, &TimeStat{Date: i, Data: float64(count)})
-		if seen >= len(mp) {
-			break
-		}
-	}
-	return result
+	return MovesPer(trips, Week)
 }
 
-func Revenue(trips []*gobike.Trip) TimeSeries {
-	now := sevenDaysBeforeDataEnd(trips).Add(7 * 24 * time.Hour)
-	mp := make(map[int]int)
-	for i := range trips {
-		dur := now.Sub(trips[i].StartTime)
-		bucket := int(math.Floor(float64(dur) / float64(30*24*time.Hour)))
-		mp[bucket] = mp[bucket] + trips[i].RevenueCents()
-	}
-	result := make([]*TimeStat, len(mp))
-	// this is not a great approach but stick with it until it breaks.
-	for i := range mp {
-		result[len(mp)-i-1] = &TimeStat{
-			Date: now.Add(-1 * 30 * 24 * time.Hour * time.Duration(i)),
-			Data: math.Round(float64(mp[i])/100000) * 100000, // don't assume precision
-		}
-	}
-	return result
+// BikeShareForAllTripsPer returns the number of Bike Share for All trips in
+// each bucket.
+func BikeShareForAllTripsPer(trips []*gobike.Trip, g Granularity) TimeSeries {
+	return Aggregate(trips, Query{
+		Granularity: g,
+		Filter:      func(t *gobike.Trip) bool { return t.BikeShareForAllTrip },
+	})
 }
 
 func BikeShareForAllTripsPerWeek(trips []*gobike.Trip) TimeSeries {
-	weekBeforeEnd := sevenDaysBeforeDataEnd(trips)
-	lastSunday := time.Date(weekBeforeEnd.Year(), weekBeforeEnd.Month(), weekBeforeEnd.Day()+(7-int(weekBeforeEnd.Weekday())), 0, 0, 0, 0, tz)
-	mp := make(map[string]int)
-	earliest := time.Date(3000, time.January, 1, 0, 0, 0, 0, tz)
-	for i := 0; i < len(trips); i++ {
-		if !trips[i].BikeShareForAllTrip {
-			continue
-		}
-		start := trips[i].StartTime
-		wday := start.Weekday()
-		sunday := time.Date(start.Year(), start.Month(), start.Day()-int(wday), 0, 0, 0, 0, tz)
-		if sunday.Equal(lastSunday) || sunday.After(lastSunday) {
-			continue
-		}
-		_, ok := mp[sunday.Format("2006-01-02")]
-		if ok {
-			mp[sunday.Format("2006-01-02")] += 1
-		} else {
-			mp[sunday.Format("2006-01-02")] = 1
-		}
-		if sunday.Before(earliest) {
-			earliest = sunday
-		}
-	}
-	seen := 0
-	result := make([]*TimeStat, 0)
-	for i := earliest; ; i = time.Date(i.Year(), i.Month(), i.Day()+7, 0, 0, 0, 0, tz) {
-		count, ok := mp[i.Format("2006-01-02")]
-		if ok {
-			seen++
-		}
-		result = append(result, &TimeStat{Date: i, Data: float64(count)})
-		if seen >= len(mp) {
-			break
-		}
-	}
-	return result
+	return BikeShareForAllTripsPer(trips, Week)
 }
 
-func UniqueStationsPerWeek(trips []*gobike.Trip) TimeSeries {
-	weekBeforeEnd := sevenDaysBeforeDataEnd(trips)
-	lastSunday := time.Date(weekBeforeEnd.Year(), weekBeforeEnd.Month(), weekBeforeEnd.Day()+(7-int(weekBeforeEnd.Weekday())), 0, 0, 0, 0, tz)
-	mp := make(map[string]map[string]bool)
-	earliest := time.Date(3000, time.January, 1, 0, 0, 0, 0, tz)
-	for i := 0; i < len(trips); i++ {
-		start := trips[i].StartTime
-		wday := start.Weekday()
-		sunday := time.Date(start.Year(), start.Month(), start.Day()-int(wday), 0, 0, 0, 0, tz)
-		if sunday.Equal(lastSunday) || sunday.After(lastSunday) {
-			continue
-		}
-		sundayfmt := sunday.Format("2006-01-02")
-		_, ok := mp[sundayfmt]
-		if !ok {
-			mp[sundayfmt] = make(map[string]bool)
-		}
+// UniqueStationsPer returns the number of stations trips started from in each
+// bucket.
+func UniqueStationsPer(trips []*gobike.Trip, g Granularity) TimeSeries {
+	return Aggregate(trips, Query{
+		Granularity: g,
 		// only count start station since end station might be in a different
 		// city
-		mp[sundayfmt][trips[i].StartStationID] = true
-		if sunday.Before(earliest) {
-			earliest = sunday
-		}
-	}
-	seen := 0
-	result := make([]*TimeStat, 0)
-	for i := earliest; ; i = time.Date(i.Year(), i.Month(), i.Day()+7, 0, 0, 0, 0, tz) {
-		weekMap, ok := mp[i.Format("2006-01-02")]
-		if ok {
-			seen++
-		}
-		result = append(result, &TimeStat{Date: i, Data: float64(len(weekMap))})
-		if seen >= len(mp) {
-			break
-		}
-	}
-	return result
+		Reducer: Distinct(func(t *gobike.Trip) string { return t.StartStationID }),
+	})
+}
+
+func UniqueStationsPerWeek(trips []*gobike.Trip) TimeSeries {
+	return UniqueStationsPer(trips, Week)
+}
+
+// UniqueBikesPer returns the number of bikes used in each bucket.
+func UniqueBikesPer(trips []*gobike.Trip, g Granularity) TimeSeries {
+	return Aggregate(trips, Query{Granularity: g, Reducer: Distinct(bikeID)})
 }
 
 func UniqueBikesPerWeek(trips []*gobike.Trip) TimeSeries {
-	weekBeforeEnd := sevenDaysBeforeDataEnd(trips)
-	lastSunday := time.Date(weekBeforeEnd.Year(), weekBeforeEnd.Month(), weekBeforeEnd.Day()+(7-int(weekBeforeEnd.Weekday())), 0, 0, 0, 0, tz)
-	mp := make(map[string]map[int64]bool)
-	earliest := time.Date(3000, time.January, 1, 0, 0, 0, 0, tz)
-	for i := 0; i < len(trips); i++ {
-		start := trips[i].StartTime
-		wday := start.Weekday()
-		sunday := time.Date(start.Year(), start.Month(), start.Day()-int(wday), 0, 0, 0, 0, tz)
-		if sunday.Equal(lastSunday) || sunday.After(lastSunday) {
-			continue
-		}
-		sundayfmt := sunday.Format("2006-01-02")
-		_, ok := mp[sundayfmt]
-		if !ok {
-			mp[sundayfmt] = make(map[int64]bool)
-		}
-		mp[sundayfmt][trips[i].BikeID] = true
-		if sunday.Before(earliest) {
-			earliest = sunday
-		}
-	}
-	seen := 0
-	result := make([]*TimeStat, 0)
-	for i := earliest; ; i = time.Date(i.Year(), i.Month(), i.Day()+7, 0, 0, 0, 0, tz) {
-		weekMap, ok := mp[i.Format("2006-01-02")]
-		if ok {
-			seen++
-		}
-		result = append(result, &TimeStat{Date: i, Data: float64(len(weekMap))})
-		if seen >= len(mp) {
-			break
-		}
-	}
-	return result
+	return UniqueBikesPer(trips, Week)
+}
+
+// TripsPerBikePer returns the average number of trips per bike used in each
+// bucket.
+func TripsPerBikePer(trips []*gobike.Trip, g Granularity) TimeSeries {
+	return Aggregate(trips, Query{Granularity: g, Reducer: Ratio(Count(), Distinct(bikeID))})
 }
 
 func TripsPerBikePerWeek(trips []*gobike.Trip) TimeSeries {
-	weekBeforeEnd := sevenDaysBeforeDataEnd(trips)
-	lastSunday := time.Date(weekBeforeEnd.Year(), weekBeforeEnd.Month(), weekBeforeEnd.Day()+(7-int(weekBeforeEnd.Weekday())), 0, 0, 0, 0, tz)
-	lastSundayFmt := lastSunday.Format("2006-01-02")
-	mp := make(map[string]map[int64]int)
-	earliest := time.Date(3000, time.January, 1, 0, 0, 0, 0, tz)
-	for i := 0; i < len(trips); i++ {
-		start := trips[i].StartTime
-		wday := start.Weekday()
-		sunday := time.Date(start.Year(), start.Month(), start.Day()-int(wday), 0, 0, 0, 0, tz)
-		sundayfmt := sunday.Format("2006-01-02")
-		if sundayfmt == lastSundayFmt {
-			continue // partial last week
-		}
-		_, ok := mp[sundayfmt]
-		if !ok {
-			mp[sundayfmt] = make(map[int64]int)
-		}
-		_, hasBike := mp[sundayfmt][trips[i].BikeID]
-		if hasBike {
-			mp[sundayfmt][trips[i].BikeID]++
-		} else {
-			mp[sundayfmt][trips[i].BikeID] = 1
-		}
-		if sunday.Before(earliest) {
-			earliest = sunday
-		}
+	return TripsPerBikePer(trips, Week)
+}
+
+func Revenue(trips []*gobike.Trip) TimeSeries {
+	now := sevenDaysBeforeDataEnd(trips).Add(7 * 24 * time.Hour)
+	mp := make(map[int]int)
+	for i := range trips {
+		dur := now.Sub(trips[i].StartTime)
+		bucket := int(math.Floor(float64(dur) / float64(30*24*time.Hour)))
+		mp[bucket] = mp[bucket] + trips[i].RevenueCents()
 	}
-	seen := 0
-	result := make([]*TimeStat, 0)
-	for i := earliest; ; i = time.Date(i.Year(), i.Month(), i.Day()+7, 0, 0, 0, 0, tz) {
-		weekMap, ok := mp[i.Format("2006-01-02")]
-		if ok {
-			seen++
-		}
-		numTrips := float64(0)
-		for j := range weekMap {
-			numTrips += float64(weekMap[j])
-		}
-		var avg float64
-		if numTrips+float64(len(weekMap)) > 0.0005 {
-			avg = numTrips / float64(len(weekMap))
-		}
-		result = append(result, &TimeStat{Date: i, Data: avg})
-		if seen >= len(mp) {
-			break
+	result := make([]*TimeStat, len(mp))
+	// this is not a great approach but stick with it until it breaks.
+	for i := range mp {
+		result[len(mp)-i-1] = &TimeStat{
+			Date: now.Add(-1 * 30 * 24 * time.Hour * time.Duration(i)),
+			Data: math.Round(float64(mp[i])/100000) * 100000, // don't assume precision
 		}
 	}
 	return result
@@ -365,9 +208,33 @@ type stationAggregate struct {
 	// Maps of station ID's to counts.
 	From map[string]int
 	To   map[string]int
+
+	// Trips that ended and started at the station, by hour of the day.
+	Arrivals   [24]int
+	Departures [24]int
+	// Net flow (arrivals minus departures) by day and hour of the day. Days
+	// are keyed by the Unix time of midnight in the Bay Area.
+	Flow map[int64]*[24]int
+}
+
+func (a *stationAggregate) addFlow(t time.Time, n int) {
+	t = t.In(tz)
+	day := Day.Truncate(t, time.Sunday).Unix()
+	if a.Flow == nil {
+		a.Flow = make(map[int64]*[24]int)
+	}
+	hours, ok := a.Flow[day]
+	if !ok {
+		hours = new([24]int)
+		a.Flow[day] = hours
+	}
+	hours[t.Hour()] += n
 }
 
-func stationCounter(stationMap map[string]*gobike.Station, trips []*gobike.Trip, f func(t *gobike.Trip) bool) []*StationCount {
+// aggregateStations counts the trips between docked stations that match f, by
+// station ID. Trips to or from a station that isn't in stationMap are skipped.
+func aggregateStations(stationMap map[string]*gobike.Station, trips []*gobike.Trip, f func(t *gobike.Trip) bool) map[string]*stationAggregate {
+	tzOnce.Do(populateTZ)
 	agg := make(map[string]*stationAggregate)
 	for i := range trips {
 		if trips[i].Dockless() {
@@ -416,6 +283,10 @@ func stationCounter(stationMap map[string]*gobike.Station, trips []*gobike.Trip,
 		} else {
 			agg[toStationID].From[stationID] = 1
 		}
+		agg[stationID].Departures[trips[i].StartTime.In(tz).Hour()]++
+		agg[stationID].addFlow(trips[i].StartTime, -1)
+		agg[toStationID].Arrivals[trips[i].EndTime.In(tz).Hour()]++
+		agg[toStationID].addFlow(trips[i].EndTime, 1)
 
 		agg[stationID].AllRides[trips[i].StartTime.Weekday()]++
 		if !trips[i].BikeShareForAllTrip {
@@ -423,6 +294,13 @@ func stationCounter(stationMap map[string]*gobike.Station, trips []*gobike.Trip,
 		}
 		agg[stationID].BS4ARides[trips[i].StartTime.Weekday()]++
 	}
+	return agg
+}
+
+// stationCounter counts the trips f returns true for at each station. The
+// weekdays in skip are left out of WeekdayRidership.
+func stationCounter(stationMap map[string]*gobike.Station, trips []*gobike.Trip, skip [7]bool, f func(t *gobike.Trip) bool) []*StationCount {
+	agg := aggregateStations(stationMap, trips, f)
 	stationCounts := make([]*StationCount, 0)
 	for id := range agg {
 		mpbucket := agg[id].AllRides
@@ -454,11 +332,14 @@ func stationCounter(stationMap map[string]*gobike.Station, trips []*gobike.Trip,
 		//if fromStation.Station == nil {
 		//panic(fmt.Sprintf("nil fromStation: " + agg[id].Station.Name))
 		//}
-		sort.Ints(mpbucket[time.Monday : time.Friday+1])
+		var rides [7]float64
+		for j := range mpbucket {
+			rides[j] = float64(mpbucket[j])
+		}
 		stationCounts = append(stationCounts, &StationCount{
 			Station:          agg[id].Station,
 			Count:            mpcount,
-			WeekdayRidership: (float64(mpbucket[time.Tuesday]) + float64(mpbucket[time.Wednesday]) + float64(mpbucket[time.Thursday])) / 3,
+			WeekdayRidership: typicalWeekday(rides, skip),
 			BS4ACount:        bmpcount,
 			ToStation:        toStation,
 			FromStation:      fromStation,
@@ -469,7 +350,8 @@ func stationCounter(stationMap map[string]*gobike.Station, trips []*gobike.Trip,
 
 func PopularStationsLast7Days(stationMap map[string]*gobike.Station, trips []*gobike.Trip, statuses map[string][]*gobike.StationStatus, numStations int) []*StationCount {
 	weekAgo := sevenDaysBeforeDataEnd(trips)
-	stationCounts := stationCounter(stationMap, trips, func(trip *gobike.Trip) bool {
+	skip := specialWeekdays(weekAgo)
+	stationCounts := stationCounter(stationMap, trips, skip, func(trip *gobike.Trip) bool {
 		return !trip.StartTime.Before(weekAgo)
 	})
 	sort.Slice(stationCounts, func(i, j int) bool {
@@ -487,44 +369,64 @@ func PopularStationsLast7Days(stationMap map[string]*gobike.Station, trips []*go
 	} else {
 		counts = stationCounts[:numStations]
 	}
-	now := time.Now()
-	weekAgoNow := now.Add(-1 * 7 * 24 * time.Hour)
+	// use the same week as the trips, so the ridership and the hours empty or
+	// full describe the same days.
+	weekEnd := time.Date(weekAgo.Year(), weekAgo.Month(), weekAgo.Day()+7, 0, 0, 0, 0, tz)
 	for i := range counts {
 		id := strconv.Itoa(counts[i].Station.ID)
 		stationStatuses := statuses[id]
-		var empty, full [7]time.Duration
+		var empty, full [7]float64
 		for j := 0; j < len(stationStatuses); j++ {
 			status := stationStatuses[j]
-			if status.LastReported.Before(weekAgoNow) {
+			if status.LastReported.Before(weekAgo) || !status.LastReported.Before(weekEnd) {
 				continue
 			}
 			weekday := status.LastReported.Weekday()
-			if status.NumBikesAvailable == 0 {
+			if status.Empty() {
 				if j < len(stationStatuses)-1 {
 					dur := stationStatuses[j+1].LastReported.Sub(status.LastReported)
-					empty[weekday] = empty[weekday] + dur
+					empty[weekday] += float64(dur)
 				}
 			}
-			if status.NumDocksAvailable == 0 {
+			if status.Full() {
 				if j < len(stationStatuses)-1 {
 					dur := stationStatuses[j+1].LastReported.Sub(status.LastReported)
-					full[weekday] = full[weekday] + dur
+					full[weekday] += float64(dur)
 				}
 			}
 		}
-		sort.Slice(empty[time.Monday:time.Friday+1], func(i, j int) bool {
-			return empty[i] < empty[j]
-		})
-		sort.Slice(full[time.Monday:time.Friday+1], func(i, j int) bool {
-			return full[i] < full[j]
-		})
-		// drop highest and lowest
-		counts[i].WeekdayHoursEmpty = (float64(empty[time.Tuesday]) + float64(empty[time.Wednesday]) + float64(empty[time.Thursday])) / float64(3*time.Hour)
-		counts[i].WeekdayHoursFull = (float64(full[time.Tuesday]) + float64(full[time.Wednesday]) + float64(full[time.Thursday])) / float64(3*time.Hour)
+		counts[i].WeekdayHoursEmpty = typicalWeekday(empty, skip) / float64(time.Hour)
+		counts[i].WeekdayHoursFull = typicalWeekday(full, skip) / float64(time.Hour)
 	}
 	return counts
 }
 
+// TripsAsOf returns the trips that started at or before asOf. Every stat that
+// looks at "last week" or the latest bucket works from the trips it is given,
+// so computing it from TripsAsOf(trips, t) gives the value it had at t.
+func TripsAsOf(trips []*gobike.Trip, asOf time.Time) []*gobike.Trip {
+	result := make([]*gobike.Trip, 0, len(trips))
+	for i := range trips {
+		if !trips[i].StartTime.After(asOf) {
+			result = append(result, trips[i])
+		}
+	}
+	return result
+}
+
+// StatusesAsOf returns the statuses reported at or before asOf.
+func StatusesAsOf(statuses []*gobike.StationStatus, asOf time.Time) []*gobike.StationStatus {
+	result := make([]*gobike.StationStatus, 0, len(statuses))
+	for i := range statuses {
+		if !statuses[i].LastReported.After(asOf) {
+			result = append(result, statuses[i])
+		}
+	}
+	return result
+}
+
+// sevenDaysBeforeDataEnd returns midnight at the start of the last seven days
+// of trip data.
 func sevenDaysBeforeDataEnd(trips []*gobike.Trip) time.Time {
 	tzOnce.Do(populateTZ)
 	latestDay := time.Date(1000, time.January, 1, 0, 0, 0, 0, tz)
@@ -538,9 +440,54 @@ func sevenDaysBeforeDataEnd(trips []*gobike.Trip) time.Time {
 	return time.Date(latestDay.Year(), latestDay.Month(), latestDay.Day()-6, 0, 0, 0, 0, tz)
 }
 
+// SpecialDaysLast7Days returns the holidays and events in the last seven days
+// of trip data, which the weekday averages leave out.
+func SpecialDaysLast7Days(trips []*gobike.Trip) []Event {
+	weekAgo := sevenDaysBeforeDataEnd(trips)
+	return SpecialDays(weekAgo, weekAgo.AddDate(0, 0, 7))
+}
+
+// specialWeekdays reports which days of the week in the seven days from start
+// are holidays or events.
+func specialWeekdays(start time.Time) [7]bool {
+	var skip [7]bool
+	end := start.AddDate(0, 0, 7)
+	for day := start; day.Before(end); day = Day.Next(day) {
+		if IsSpecialDay(day) {
+			skip[day.Weekday()] = true
+		}
+	}
+	return skip
+}
+
+// typicalWeekday averages the values for Monday through Friday, leaving out
+// the days in skip, and then the highest and lowest of the rest so one odd day
+// doesn't skew it. If every weekday is in skip, none are left out.
+func typicalWeekday(values [7]float64, skip [7]bool) float64 {
+	days := make([]float64, 0, 5)
+	for d := time.Monday; d <= time.Friday; d++ {
+		if !skip[d] {
+			days = append(days, values[d])
+		}
+	}
+	if len(days) == 0 {
+		days = append(days, values[time.Monday:time.Friday+1]...)
+	}
+	sort.Float64s(days)
+	// drop highest and lowest
+	if len(days) >= 3 {
+		days = days[1 : len(days)-1]
+	}
+	var sum float64
+	for _, v := range days {
+		sum += v
+	}
+	return sum / float64(len(days))
+}
+
 func PopularBS4AStationsLast7Days(stationMap map[string]*gobike.Station, trips []*gobike.Trip, numStations int) []*StationCount {
 	weekAgo := sevenDaysBeforeDataEnd(trips)
-	stationCounts := stationCounter(stationMap, trips, func(trip *gobike.Trip) bool {
+	stationCounts := stationCounter(stationMap, trips, specialWeekdays(weekAgo), func(trip *gobike.Trip) bool {
 		return !trip.StartTime.Before(weekAgo)
 	})
 	sort.Slice(stationCounts, func(i, j int) bool {
@@ -576,19 +523,19 @@ func TripsLastWeekPerDistrict(trips []*gobike.Trip) [11]int {
 	return counts
 }
 
+// AverageWeekdayTrips returns the number of trips on a typical weekday in the
+// last seven days of trip data, leaving out holidays and events.
 func AverageWeekdayTrips(trips []*gobike.Trip) float64 {
 	// bucket trips by weekday
 	weekAgo := sevenDaysBeforeDataEnd(trips)
-	var buckets [7]int
+	var buckets [7]float64
 	for i := range trips {
 		if trips[i].StartTime.Before(weekAgo) {
 			continue
 		}
 		buckets[trips[i].StartTime.Weekday()]++
 	}
-	sort.Ints(buckets[time.Monday : time.Friday+1])
-	// drop highest and lowest
-	return (float64(buckets[time.Tuesday]) + float64(buckets[time.Wednesday]) + float64(buckets[time.Thursday])) / 3
+	return typicalWeekday(buckets, specialWeekdays(weekAgo))
 }
 
 func DistanceBucketsLastWeek(trips []*gobike.Trip, interval float64, numBuckets int) ([]int, float64) {
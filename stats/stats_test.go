@@ -1,8 +1,7 @@
 package stats
 
 import (
-	"context"
-	"log"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -32,10 +31,7 @@ func empty(city *geo.City, stationMap map[string]*gobike.Station) func(ss *gobik
 		if city != nil && station.City != city {
 			return false
 		}
-		if !ss.IsInstalled || !ss.IsRenting {
-			return false
-		}
-		return ss.NumBikesAvailable == 0
+		return ss.Empty()
 	}
 }
 
@@ -48,13 +44,11 @@ func BenchmarkStatusFilterOverTime(b *testing.B) {
 	if err != nil {
 		b.Fatal(err)
 	}
-	c := client.NewClient()
-	c.Stations.CacheTTL = 24 * 14 * time.Hour
-	resp, err := c.Stations.All(context.Background())
+	stations, err := client.LoadStations(filepath.Join("..", "gbfstest", "testdata", "station_information.json"))
 	if err != nil {
-		log.Fatal(err)
+		b.Fatal(err)
 	}
-	stationMap := gobike.StationMap(resp.Stations)
+	stationMap := gobike.StationMap(stations)
 	byStation := StatusMap(statuses)
 	start := time.Date(2018, time.August, 23, 0, 0, 0, 0, tz)
 	b.ResetTimer()
@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// eachBucket splits from to to at the bucket boundaries of g, with weeks
+// starting on Sunday, and calls f with the start of each bucket and how much
+// of it the time covers.
+func eachBucket(g Granularity, from, to time.Time, f func(start time.Time, d time.Duration)) {
+	for t := from; t.Before(to); {
+		start := g.Truncate(t, time.Sunday)
+		next := g.Next(start)
+		if next.After(to) {
+			next = to
+		}
+		f(start, next.Sub(t))
+		t = next
+	}
+}
+
+// stationTotals is how long a station was observed in a bucket, and the sums
+// of some values of its statuses, each weighted by how long the status held.
+type stationTotals struct {
+	id       string
+	observed float64
+	sums     []float64
+}
+
+// statusBucket holds the totals of every station observed in one bucket, in
+// order of station ID.
+type statusBucket struct {
+	start    time.Time
+	stations []*stationTotals
+}
+
+// sumStatuses splits the time covered by each station's statuses into the
+// buckets of g, and adds up the values f returns for each status, weighted by
+// how long it held. f must always return the same number of values. Only the
+// buckets that end by end and have statuses are returned, in order.
+func sumStatuses(statuses map[string][]*gobike.StationStatus, g Granularity, end time.Time, f func(ss *gobike.StationStatus) []float64) []*statusBucket {
+	tzOnce.Do(populateTZ)
+	// visit the stations in a fixed order so the totals don't change from
+	// run to run
+	ids := make([]string, 0, len(statuses))
+	for id := range statuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	buckets := make(map[int64]*statusBucket)
+	var earliest time.Time
+	for _, id := range ids {
+		eachSpan(statuses[id], time.Time{}, end, func(ss *gobike.StationStatus, from, to time.Time) {
+			values := f(ss)
+			eachBucket(g, from, to, func(start time.Time, d time.Duration) {
+				b, ok := buckets[start.Unix()]
+				if !ok {
+					b = &statusBucket{start: start}
+					buckets[start.Unix()] = b
+				}
+				// stations are visited in order, so this one is either
+				// the last in the bucket or new to it
+				n := len(b.stations)
+				if n == 0 || b.stations[n-1].id != id {
+					b.stations = append(b.stations, &stationTotals{id: id, sums: make([]float64, len(values))})
+					n++
+				}
+				st := b.stations[n-1]
+				st.observed += float64(d)
+				for i := range values {
+					st.sums[i] += values[i] * float64(d)
+				}
+				if earliest.IsZero() || start.Before(earliest) {
+					earliest = start
+				}
+			})
+		})
+	}
+	result := make([]*statusBucket, 0)
+	if len(buckets) == 0 {
+		return result
+	}
+	last := g.Truncate(end, time.Sunday)
+	for t := earliest; t.Before(last); t = g.Next(t) {
+		if b, ok := buckets[t.Unix()]; ok {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// total returns the time observed and the sum of the i'th value across every
+// station in the bucket.
+func (b *statusBucket) total(i int) (observed, sum float64) {
+	for _, st := range b.stations {
+		observed += st.observed
+		sum += st.sums[i]
+	}
+	return observed, sum
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// UsabilityPer returns the percentage of station-minutes in each bucket that
+// stations were Usable, from statuses, a map like the one returned by
+// StatusMap. Each status is assumed to hold until the next one, or for an
+// hour, whichever comes first; time no status covers doesn't count either
+// way. Only buckets that end by end are included, so the last point is the
+// last full bucket, and buckets without any statuses are skipped.
+func UsabilityPer(statuses map[string][]*gobike.StationStatus, g Granularity, end time.Time) TimeSeries {
+	result := make(TimeSeries, 0)
+	for _, b := range sumStatuses(statuses, g, end, func(ss *gobike.StationStatus) []float64 {
+		return []float64{boolValue(ss.Usable())}
+	}) {
+		observed, usable := b.total(0)
+		if observed == 0 {
+			continue
+		}
+		result = append(result, &TimeStat{Date: b.start, Data: 100 * usable / observed})
+	}
+	return result
+}
+
+// UsabilityPerWeek returns the percentage of station-minutes stations were
+// Usable in each week that ends by end. Weeks start on Sunday, like
+// TripsPerWeek.
+func UsabilityPerWeek(statuses map[string][]*gobike.StationStatus, end time.Time) TimeSeries {
+	return UsabilityPer(statuses, Week, end)
+}
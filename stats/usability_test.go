@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestStationPredicates(t *testing.T) {
+	ss := &gobike.StationStatus{IsInstalled: true, IsRenting: true, IsReturning: true, NumBikesAvailable: 0, NumDocksAvailable: 5}
+	if !ss.Empty() || ss.Full() || ss.Usable() {
+		t.Errorf("expected an empty station: %+v", ss)
+	}
+	ss.IsRenting = false
+	if ss.Empty() {
+		t.Error("a station that isn't renting is out of service, not empty")
+	}
+	ss = &gobike.StationStatus{IsInstalled: true, IsRenting: true, IsReturning: false, NumBikesAvailable: 3, NumDocksAvailable: 5}
+	if ss.Full() || ss.HasDock() || ss.Usable() {
+		t.Errorf("expected no docks to return a bike to: %+v", ss)
+	}
+}
+
+func TestUsabilityPerWeek(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	statuses := map[string][]*gobike.StationStatus{
+		// usable for half an hour, then empty until the status expires an hour
+		// later, in the week starting Sunday the 19th
+		"1": {
+			testStatus("1", augustAt(20, 8, 0), 3, 7),
+			testStatus("1", augustAt(20, 8, 30), 0, 10),
+			// spans midnight Saturday, so half of it is in the next week
+			testStatus("1", augustAt(25, 23, 30), 3, 7),
+		},
+		// not taking returns for an hour
+		"2": {
+			testStatus("2", augustAt(21, 12, 0), 3, 7),
+		},
+	}
+	statuses["2"][0].IsReturning = false
+	series := UsabilityPerWeek(statuses, augustAt(26, 0, 0))
+	if len(series) != 1 {
+		t.Fatalf("expected one full week, got %d", len(series))
+	}
+	if !series[0].Date.Equal(augustAt(19, 0, 0)) {
+		t.Errorf("expected the week to start on Sunday the 19th, got %v", series[0].Date)
+	}
+	// 30+30 usable minutes out of 30+60+30+60
+	if math.Abs(series[0].Data-100.0/3) > 1e-9 {
+		t.Errorf("expected a third of the time usable, got %v", series[0].Data)
+	}
+	series = UsabilityPerWeek(statuses, augustAt(27, 0, 0))
+	if len(series) != 1 {
+		t.Errorf("expected the partial week to be left out, got %d points", len(series))
+	}
+	series = UsabilityPerWeek(statuses, augustAt(26+7, 0, 0))
+	if len(series) != 2 || series[1].Data != 100 {
+		t.Errorf("expected the next week to be all usable, got %d points", len(series))
+	}
+}
@@ -0,0 +1,398 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// RainyDay is the precipitation, in inches, that makes a day rainy. Lighter
+// drizzle doesn't keep many riders home.
+const RainyDay = 0.1
+
+// A rainy or dry average needs at least minWeatherDays days behind it to be
+// used to adjust ridership.
+const minWeatherDays = 3
+
+// DailyWeather is the weather on one day.
+type DailyWeather struct {
+	// Date is midnight at the start of the day, in the Bay Area.
+	Date time.Time
+	// Precipitation is the total rain for the day, in inches. A trace counts
+	// as zero.
+	Precipitation float64
+	// High and Low are the highest and lowest temperatures, in degrees
+	// Fahrenheit, if HasTemperature is true.
+	High, Low      float64
+	HasTemperature bool
+}
+
+// Rainy reports whether at least RainyDay inches of rain fell.
+func (d *DailyWeather) Rainy() bool {
+	return d.Precipitation >= RainyDay
+}
+
+func (d *DailyWeather) addTemperature(temp float64) {
+	if !d.HasTemperature || temp > d.High {
+		d.High = temp
+	}
+	if !d.HasTemperature || temp < d.Low {
+		d.Low = temp
+	}
+	d.HasTemperature = true
+}
+
+// Weather is the daily weather at one weather station. A nil Weather has no
+// days.
+type Weather struct {
+	// Station and Name identify the weather station, like "USW00023272" and
+	// "SAN FRANCISCO DOWNTOWN, CA US".
+	Station, Name string
+
+	days map[int]*DailyWeather
+}
+
+// On returns the weather on the day t falls on, or nil if there isn't any.
+func (w *Weather) On(t time.Time) *DailyWeather {
+	if w == nil {
+		return nil
+	}
+	tzOnce.Do(populateTZ)
+	return w.days[dayKey(t)]
+}
+
+// Days returns every day with weather, in order.
+func (w *Weather) Days() []*DailyWeather {
+	if w == nil {
+		return nil
+	}
+	days := make([]*DailyWeather, 0, len(w.days))
+	for _, d := range w.days {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Date.Before(days[j].Date)
+	})
+	return days
+}
+
+// day returns the weather on the date t falls on in its own location, adding
+// it if it's missing.
+func (w *Weather) day(t time.Time) *DailyWeather {
+	year, month, day := t.Date()
+	key := year*10000 + int(month)*100 + day
+	d, ok := w.days[key]
+	if !ok {
+		d = &DailyWeather{Date: time.Date(year, month, day, 0, 0, 0, 0, tz)}
+		w.days[key] = d
+	}
+	return d
+}
+
+// parseWeatherValue parses a number from a NOAA file. Trace amounts ("T") are
+// zero, and the flags NOAA appends to some values, like "0.02s" for a suspect
+// value, are ignored. ok is false if the value is missing.
+func parseWeatherValue(s string) (v float64, ok bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "M" {
+		return 0, false, nil
+	}
+	if s == "T" {
+		return 0, true, nil
+	}
+	s = strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ*")
+	v, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("weather: bad value %q", s)
+	}
+	return v, true, nil
+}
+
+// Local Climatological Data times are in local standard time all year, and
+// days run from midnight to midnight standard time, so during daylight saving
+// time a report at 23:53 belongs to the day before the one it falls on in the
+// Bay Area.
+var pacificStandardTime = time.FixedZone("PST", -8*60*60)
+
+// ParseWeather reads weather in one of the CSV formats NOAA's Climate Data
+// Online exports, in standard (not metric) units:
+//
+//   - Daily Summaries, with a row per day and PRCP, TMAX and TMIN columns.
+//   - Local Climatological Data, with a row per report and
+//     HourlyPrecipitation and HourlyDryBulbTemperature columns. Only the
+//     routine hourly reports (FM-15) are used, so rain isn't counted twice.
+//
+// Only the first weather station in the file is used.
+func ParseWeather(r io.Reader) (*Weather, error) {
+	tzOnce.Do(populateTZ)
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	var hourly bool
+	var precipCol, highCol, lowCol string
+	switch {
+	case hasColumn(col, "PRCP"):
+		precipCol, highCol, lowCol = "PRCP", "TMAX", "TMIN"
+	case hasColumn(col, "HourlyPrecipitation"):
+		hourly = true
+		precipCol, highCol = "HourlyPrecipitation", "HourlyDryBulbTemperature"
+	default:
+		return nil, fmt.Errorf("weather: no PRCP or HourlyPrecipitation column")
+	}
+	if !hasColumn(col, "DATE") {
+		return nil, fmt.Errorf("weather: no DATE column")
+	}
+	field := func(record []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+	w := &Weather{days: make(map[int]*DailyWeather)}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		station := field(record, "STATION")
+		if w.Station == "" {
+			w.Station, w.Name = station, field(record, "NAME")
+		} else if station != w.Station {
+			continue
+		}
+		var t time.Time
+		if hourly {
+			if rt := strings.TrimSpace(field(record, "REPORT_TYPE")); rt != "" && rt != "FM-15" {
+				continue
+			}
+			t, err = time.ParseInLocation("2006-01-02T15:04:05", field(record, "DATE"), pacificStandardTime)
+		} else {
+			t, err = time.ParseInLocation("2006-01-02", field(record, "DATE"), tz)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("weather: bad date: %v", err)
+		}
+		d := w.day(t)
+		precip, ok, err := parseWeatherValue(field(record, precipCol))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			d.Precipitation += precip
+		}
+		for _, name := range []string{highCol, lowCol} {
+			if name == "" {
+				continue
+			}
+			temp, ok, err := parseWeatherValue(field(record, name))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				d.addTemperature(temp)
+			}
+		}
+	}
+	return w, nil
+}
+
+func hasColumn(col map[string]int, name string) bool {
+	_, ok := col[name]
+	return ok
+}
+
+// LoadWeather reads a NOAA weather file; see ParseWeather for the formats.
+func LoadWeather(filename string) (*Weather, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w, err := ParseWeather(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not load file %q: %v", filename, err)
+	}
+	return w, nil
+}
+
+// LoadWeatherDir reads every .csv file in directory with LoadWeather. The
+// result is keyed by the file name without the extension, which should be a
+// city slug like "sf", or "bayarea".
+func LoadWeatherDir(directory string) (map[string]*Weather, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	weather := make(map[string]*Weather)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".csv") {
+			continue
+		}
+		w, err := LoadWeather(filepath.Join(directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		weather[strings.TrimSuffix(file.Name(), ".csv")] = w
+	}
+	return weather, nil
+}
+
+// WeatherStat is a point in a daily TimeSeries with the weather that day.
+// Weather is nil if there isn't any.
+type WeatherStat struct {
+	TimeStat
+	Weather *DailyWeather
+}
+
+// JoinWeather returns the points in daily with the weather on each day.
+func JoinWeather(daily TimeSeries, w *Weather) []*WeatherStat {
+	result := make([]*WeatherStat, len(daily))
+	for i := range daily {
+		result[i] = &WeatherStat{TimeStat: *daily[i], Weather: w.On(daily[i].Date)}
+	}
+	return result
+}
+
+// RainSplit compares the average value of a daily series on rainy and dry
+// days.
+type RainSplit struct {
+	RainyDays, DryDays int
+	RainySum, DrySum   float64
+}
+
+func (s *RainSplit) add(v float64, rainy bool) {
+	if rainy {
+		s.RainyDays++
+		s.RainySum += v
+	} else {
+		s.DryDays++
+		s.DrySum += v
+	}
+}
+
+// RainyAverage returns the average on rainy days, or 0 if there weren't any.
+func (s *RainSplit) RainyAverage() float64 {
+	if s.RainyDays == 0 {
+		return 0
+	}
+	return s.RainySum / float64(s.RainyDays)
+}
+
+// DryAverage returns the average on dry days, or 0 if there weren't any.
+func (s *RainSplit) DryAverage() float64 {
+	if s.DryDays == 0 {
+		return 0
+	}
+	return s.DrySum / float64(s.DryDays)
+}
+
+// RainEffect returns the percent change from the dry day average to the rainy
+// day average, usually negative. ok is false if there were too few rainy or
+// dry days to tell.
+func (s *RainSplit) RainEffect() (pct float64, ok bool) {
+	if s.RainyDays < minWeatherDays || s.DryDays < minWeatherDays || s.DrySum == 0 {
+		return 0, false
+	}
+	return 100 * (s.RainyAverage() - s.DryAverage()) / s.DryAverage(), true
+}
+
+// RainEffectString formats RainEffect like "-35.2%", or returns "" if there
+// were too few days to tell.
+func (s *RainSplit) RainEffectString() string {
+	pct, ok := s.RainEffect()
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%+.1f%%", pct)
+}
+
+// WeatherBreakdown splits a daily series into rainy and dry days. Weekdays and
+// weekends are split separately, since they have different riders; holidays,
+// events and days without weather are left out.
+type WeatherBreakdown struct {
+	Weekday, Weekend RainSplit
+}
+
+// RainBreakdown splits the days in daily by the weather on them.
+func RainBreakdown(daily TimeSeries, w *Weather) *WeatherBreakdown {
+	tzOnce.Do(populateTZ)
+	b := new(WeatherBreakdown)
+	for _, stat := range JoinWeather(daily, w) {
+		split := b.split(stat.Date)
+		if stat.Weather == nil || split == nil {
+			continue
+		}
+		split.add(stat.Data, stat.Weather.Rainy())
+	}
+	return b
+}
+
+// split returns the RainSplit for the day t is on, or nil if it's a holiday or
+// event.
+func (b *WeatherBreakdown) split(t time.Time) *RainSplit {
+	if IsSpecialDay(t) {
+		return nil
+	}
+	switch t.In(tz).Weekday() {
+	case time.Saturday, time.Sunday:
+		return &b.Weekend
+	default:
+		return &b.Weekday
+	}
+}
+
+// WeatherAdjusted returns daily with each rainy day scaled up by how much rain
+// cuts ridership on that kind of day, so a wet week doesn't look like a drop
+// in demand. Dry days, holidays, events, days without weather, and kinds of
+// day without enough rainy and dry days to compare are left alone.
+func WeatherAdjusted(daily TimeSeries, w *Weather) TimeSeries {
+	b := RainBreakdown(daily, w)
+	result := make(TimeSeries, len(daily))
+	for i, stat := range JoinWeather(daily, w) {
+		result[i] = &TimeStat{Date: stat.Date, Data: stat.Data}
+		split := b.split(stat.Date)
+		if stat.Weather == nil || split == nil || !stat.Weather.Rainy() {
+			continue
+		}
+		if pct, ok := split.RainEffect(); ok && pct > -100 {
+			result[i].Data = stat.Data / (1 + pct/100)
+		}
+	}
+	return result
+}
+
+// WeatherAdjustedTripsPer returns the number of trips in each bucket, like
+// TripsPer, with the trips on each day adjusted for the weather by
+// WeatherAdjusted. g should be a Day or longer.
+func WeatherAdjustedTripsPer(trips []*gobike.Trip, g Granularity, w *Weather) TimeSeries {
+	adjusted := WeatherAdjusted(TripsPer(trips, Day), w)
+	result := TripsPer(trips, g)
+	sums := make(map[int64]float64, len(result))
+	for _, stat := range adjusted {
+		sums[g.Truncate(stat.Date, time.Sunday).Unix()] += stat.Data
+	}
+	for i := range result {
+		result[i] = &TimeStat{Date: result[i].Date, Data: sums[result[i].Date.Unix()]}
+	}
+	return result
+}
@@ -0,0 +1,167 @@
+package stats
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+func TestLoadWeatherDir(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	weather, err := LoadWeatherDir(filepath.Join("testdata", "weather"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, oakland := weather["sf"], weather["oakland"]
+	if sf == nil || oakland == nil {
+		t.Fatalf("expected weather for sf and oakland, got %v", weather)
+	}
+	if sf.Station != "USW00023272" || len(sf.Days()) != 31 {
+		t.Errorf("bad daily weather: station %q, %d days", sf.Station, len(sf.Days()))
+	}
+	jan3 := time.Date(2018, time.January, 3, 0, 0, 0, 0, tz)
+	if d := sf.On(jan3.Add(17 * time.Hour)); d == nil || d.Precipitation != 0.35 || !d.Rainy() || d.High != 55 || d.Low != 43 {
+		t.Errorf("bad weather for Jan 3: %+v", d)
+	}
+	// a trace of rain
+	if d := sf.On(jan3.AddDate(0, 0, 2)); d == nil || d.Precipitation != 0 || d.Rainy() {
+		t.Errorf("bad weather for Jan 5: %+v", d)
+	}
+	if d := sf.On(jan3.AddDate(0, 0, 60)); d != nil {
+		t.Errorf("expected no weather in March, got %+v", d)
+	}
+
+	// hourly reports are summed, leaving out the special report and the
+	// summary of the day
+	d := oakland.On(jan3)
+	if d == nil || math.Abs(d.Precipitation-0.15) > 1e-9 || d.High != 52 || d.Low != 49 {
+		t.Errorf("bad hourly weather for Jan 3: %+v", d)
+	}
+	if d := oakland.On(jan3.AddDate(0, 0, 1)); d == nil || d.Precipitation != 0.02 {
+		t.Errorf("bad hourly weather for Jan 4: %+v", d)
+	}
+
+	// in summer, the last report of the day is after midnight daylight time
+	summer, err := ParseWeather(strings.NewReader(`"DATE","REPORT_TYPE","HourlyPrecipitation"
+"2018-07-01T00:53:00","FM-15","0.01"
+"2018-07-01T23:53:00","FM-15","0.02"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	july1 := time.Date(2018, time.July, 1, 0, 0, 0, 0, tz)
+	if d := summer.On(july1); d == nil || math.Abs(d.Precipitation-0.03) > 1e-9 || !d.Date.Equal(july1) {
+		t.Errorf("bad hourly weather for Jul 1: %+v", d)
+	}
+	if d := summer.On(july1.AddDate(0, 0, 1)); d != nil {
+		t.Errorf("expected no weather for Jul 2, got %+v", d)
+	}
+
+	if _, err := ParseWeather(strings.NewReader("\"STATION\",\"DATE\",\"TAVG\"\n")); err == nil {
+		t.Error("expected an error for a file without precipitation")
+	}
+	if _, err := ParseWeather(strings.NewReader("\"DATE\",\"PRCP\"\n\"2018-01-01\",\"lots\"\n")); err == nil {
+		t.Error("expected an error for a bad value")
+	}
+}
+
+// januaryTrips returns the number of trips on day: 100 on dry weekdays, 70 on
+// rainy ones, 50 on dry weekend days, 40 on rainy ones, and 30 on holidays.
+func januaryTrips(w *Weather, day time.Time) int {
+	weekend := day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+	rainy := w.On(day).Rainy()
+	switch {
+	case IsHoliday(day):
+		return 30
+	case weekend && rainy:
+		return 40
+	case weekend:
+		return 50
+	case rainy:
+		return 70
+	default:
+		return 100
+	}
+}
+
+func TestRainBreakdown(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	w, err := LoadWeather(filepath.Join("testdata", "weather", "sf.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2018, time.January, 1, 0, 0, 0, 0, tz)
+	daily := dailySeries(start, 31, func(day time.Time) float64 {
+		return float64(januaryTrips(w, day))
+	})
+	b := RainBreakdown(daily, w)
+	// 23 weekdays less New Year's Day and MLK Day, 8 weekend days
+	if b.Weekday.RainyDays != 6 || b.Weekday.DryDays != 15 || b.Weekend.RainyDays != 3 || b.Weekend.DryDays != 5 {
+		t.Fatalf("bad days: %+v", b)
+	}
+	if b.Weekday.RainyAverage() != 70 || b.Weekday.DryAverage() != 100 {
+		t.Errorf("bad weekday averages: %v, %v", b.Weekday.RainyAverage(), b.Weekday.DryAverage())
+	}
+	if got := b.Weekday.RainEffectString(); got != "-30.0%" {
+		t.Errorf("bad weekday rain effect: %q", got)
+	}
+	if got := b.Weekend.RainEffectString(); got != "-20.0%" {
+		t.Errorf("bad weekend rain effect: %q", got)
+	}
+
+	adjusted := WeatherAdjusted(daily, w)
+	for i, stat := range adjusted {
+		want := daily[i].Data
+		switch daily[i].Data {
+		case 70:
+			want = 100
+		case 40:
+			want = 50
+		}
+		if math.Abs(stat.Data-want) > 1e-9 {
+			t.Errorf("%s: adjusted %v to %v, want %v", stat.Date.Format("Jan 2"), daily[i].Data, stat.Data, want)
+		}
+	}
+
+	// without weather, nothing changes
+	if got := WeatherAdjusted(daily, nil); got[2].Data != 70 {
+		t.Errorf("expected no adjustment without weather, got %v", got[2].Data)
+	}
+	if b := RainBreakdown(daily, nil); b.Weekday.RainyDays+b.Weekday.DryDays != 0 {
+		t.Errorf("expected no days without weather, got %+v", b)
+	}
+}
+
+func TestWeatherAdjustedTripsPer(t *testing.T) {
+	tzOnce.Do(populateTZ)
+	w, err := LoadWeather(filepath.Join("testdata", "weather", "sf.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trips := make([]*gobike.Trip, 0)
+	for day := time.Date(2018, time.January, 1, 0, 0, 0, 0, tz); day.Month() == time.January; day = Day.Next(day) {
+		for i := 0; i < januaryTrips(w, day); i++ {
+			trips = append(trips, &gobike.Trip{StartTime: day.Add(8 * time.Hour)})
+		}
+	}
+	raw := TripsPerWeek(trips)
+	adjusted := WeatherAdjustedTripsPer(trips, Week, w)
+	if len(adjusted) != len(raw) {
+		t.Fatalf("expected %d weeks, got %d", len(raw), len(adjusted))
+	}
+	// the week of Jan 7 rained Sunday, Monday and Tuesday
+	for i := range raw {
+		if !raw[i].Date.Equal(time.Date(2018, time.January, 7, 0, 0, 0, 0, tz)) {
+			continue
+		}
+		if raw[i].Data != 530 || math.Abs(adjusted[i].Data-600) > 1e-9 {
+			t.Errorf("expected 530 trips adjusted to 600, got %v and %v", raw[i].Data, adjusted[i].Data)
+		}
+		return
+	}
+	t.Fatal("no week of Jan 7")
+}
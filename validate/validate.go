@@ -0,0 +1,521 @@
+// Package validate checks GBFS station_information and station_status
+// payloads against the GBFS schema, and against invariants the rest of this
+// project relies on - integer station IDs, non-negative counts, timestamps
+// that are not in the future, and stations that don't hold more bikes and
+// docks than they have capacity for.
+//
+// Problems are collected into a Report, which marshals to JSON.
+package validate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/gobike"
+)
+
+// Feed names, as they appear in gbfs.json and in a Report.
+const (
+	StationInformation = "station_information"
+	StationStatus      = "station_status"
+)
+
+type Severity string
+
+const (
+	// Error problems break the GBFS schema, or break code in this project.
+	Error Severity = "error"
+	// Warning problems are allowed by the schema but are suspicious, or are
+	// patched over when we load the feed.
+	Warning Severity = "warning"
+)
+
+// A Problem is a single issue found in a feed.
+type Problem struct {
+	Feed      string   `json:"feed"`
+	StationID string   `json:"station_id,omitempty"`
+	Field     string   `json:"field,omitempty"`
+	Code      string   `json:"code"`
+	Severity  Severity `json:"severity"`
+	Message   string   `json:"message"`
+}
+
+func (p *Problem) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(string(p.Severity))
+	buf.WriteString(": ")
+	buf.WriteString(p.Feed)
+	if p.StationID != "" {
+		buf.WriteString(": station ")
+		buf.WriteString(p.StationID)
+	}
+	if p.Field != "" {
+		buf.WriteString(": ")
+		buf.WriteString(p.Field)
+	}
+	buf.WriteString(": ")
+	buf.WriteString(p.Message)
+	return buf.String()
+}
+
+// Report is the result of validating one or both feeds.
+type Report struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// Number of stations in station_information and station_status.
+	Stations int        `json:"stations"`
+	Statuses int        `json:"statuses"`
+	Errors   int        `json:"errors"`
+	Warnings int        `json:"warnings"`
+	Problems []*Problem `json:"problems"`
+}
+
+// OK reports whether the feeds had no errors. Warnings are allowed.
+func (r *Report) OK() bool {
+	return r.Errors == 0
+}
+
+func (r *Report) add(p *Problem) {
+	switch p.Severity {
+	case Error:
+		r.Errors++
+	case Warning:
+		r.Warnings++
+	}
+	r.Problems = append(r.Problems, p)
+}
+
+// Validator checks feeds. The zero value is ready to use.
+type Validator struct {
+	// Now is the time used to decide whether a timestamp is in the future. If
+	// zero, the current time is used.
+	Now time.Time
+	// MaxClockSkew is how far in the future a timestamp may be before it is
+	// reported. Defaults to DefaultMaxClockSkew.
+	MaxClockSkew time.Duration
+}
+
+// DefaultMaxClockSkew is the default value for Validator.MaxClockSkew.
+const DefaultMaxClockSkew = time.Minute
+
+// Validate checks a station_information payload and a station_status payload
+// using a zero Validator.
+func Validate(info, status []byte) *Report {
+	return new(Validator).Validate(info, status)
+}
+
+// Validate checks the given payloads. Either may be nil, in which case that
+// feed and the checks that need both feeds are skipped.
+func (v *Validator) Validate(info, status []byte) *Report {
+	now := v.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	skew := v.MaxClockSkew
+	if skew == 0 {
+		skew = DefaultMaxClockSkew
+	}
+	c := &checker{
+		report:     &Report{CheckedAt: now.UTC(), Problems: make([]*Problem, 0)},
+		now:        now,
+		skew:       skew,
+		capacities: make(map[string]int64),
+	}
+	if info != nil {
+		c.checkInformation(info)
+	}
+	if status != nil {
+		c.checkStatus(status, info != nil)
+	}
+	if info != nil && status != nil {
+		ids := make([]string, 0)
+		for id := range c.capacities {
+			if !c.reported[id] {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			c.problem(StationStatus, id, "", "missing_status", Warning, "station is in station_information but has no status")
+		}
+	}
+	return c.report
+}
+
+type checker struct {
+	report *Report
+	now    time.Time
+	skew   time.Duration
+
+	// capacities holds the capacity of every station in station_information
+	// (-1 if it's missing), keyed by station ID.
+	capacities map[string]int64
+	reported   map[string]bool
+}
+
+type object = map[string]interface{}
+
+func (c *checker) problem(feed, stationID, field, code string, severity Severity, format string, args ...interface{}) {
+	c.report.add(&Problem{
+		Feed:      feed,
+		StationID: stationID,
+		Field:     field,
+		Code:      code,
+		Severity:  severity,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+func decode(data []byte) (object, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var body object
+	if err := dec.Decode(&body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// integer returns the value of field in obj as an integer. present is false
+// if the field is missing or null; ok is false if it's present but not an
+// integer.
+func integer(obj object, field string) (val int64, present bool, ok bool) {
+	raw, present := obj[field]
+	if !present || raw == nil {
+		return 0, false, false
+	}
+	n, isNum := raw.(json.Number)
+	if !isNum {
+		return 0, true, false
+	}
+	val, err := n.Int64()
+	if err != nil {
+		return 0, true, false
+	}
+	return val, true, true
+}
+
+// checkHeader checks the fields common to every GBFS feed and returns the
+// list of stations, or nil if there isn't one.
+func (c *checker) checkHeader(feed string, data []byte) (lastUpdated time.Time, stations []interface{}) {
+	body, err := decode(data)
+	if err != nil {
+		c.problem(feed, "", "", "invalid_json", Error, "could not parse feed: %v", err)
+		return time.Time{}, nil
+	}
+	lu, present, ok := integer(body, "last_updated")
+	switch {
+	case !present:
+		c.problem(feed, "", "last_updated", "missing_field", Error, "required field is missing")
+	case !ok:
+		c.problem(feed, "", "last_updated", "invalid_type", Error, "should be an integer POSIX timestamp, got %v", body["last_updated"])
+	default:
+		lastUpdated = time.Unix(lu, 0)
+		if lastUpdated.After(c.now.Add(c.skew)) {
+			c.problem(feed, "", "last_updated", "future_timestamp", Error, "%s is %s in the future", lastUpdated.UTC().Format(time.RFC3339), lastUpdated.Sub(c.now).Round(time.Second))
+		}
+	}
+	ttl, present, ok := integer(body, "ttl")
+	switch {
+	case !present:
+		c.problem(feed, "", "ttl", "missing_field", Error, "required field is missing")
+	case !ok || ttl < 0:
+		c.problem(feed, "", "ttl", "invalid_type", Error, "should be a non-negative integer, got %v", body["ttl"])
+	}
+	dataObj, ok := body["data"].(object)
+	if !ok {
+		c.problem(feed, "", "data", "missing_field", Error, "required object is missing")
+		return lastUpdated, nil
+	}
+	stations, ok = dataObj["stations"].([]interface{})
+	if !ok {
+		c.problem(feed, "", "data.stations", "missing_field", Error, "required array is missing")
+		return lastUpdated, nil
+	}
+	return lastUpdated, stations
+}
+
+// stationID returns the station_id of a station, or "" if there isn't a
+// valid one.
+func (c *checker) stationID(feed string, i int, station object) string {
+	raw, present := station["station_id"]
+	if !present || raw == nil {
+		c.problem(feed, "", "data.stations["+strconv.Itoa(i)+"].station_id", "missing_field", Error, "required field is missing")
+		return ""
+	}
+	var id string
+	switch val := raw.(type) {
+	case string:
+		id = val
+	case json.Number:
+		// GBFS says IDs are strings, but they're easy enough to read.
+		id = val.String()
+		c.problem(feed, id, "station_id", "invalid_type", Warning, "station_id should be a string, got a number")
+	default:
+		c.problem(feed, "", "data.stations["+strconv.Itoa(i)+"].station_id", "invalid_type", Error, "station_id should be a string, got %v", raw)
+		return ""
+	}
+	if id == "" {
+		c.problem(feed, "", "data.stations["+strconv.Itoa(i)+"].station_id", "missing_field", Error, "station_id is empty")
+		return ""
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		c.problem(feed, id, "station_id", "non_integer_id", Error, "station_id %q is not an integer", id)
+	}
+	return id
+}
+
+func (c *checker) checkInformation(data []byte) {
+	_, stations := c.checkHeader(StationInformation, data)
+	c.report.Stations = len(stations)
+	for i := range stations {
+		station, ok := stations[i].(object)
+		if !ok {
+			c.problem(StationInformation, "", "data.stations["+strconv.Itoa(i)+"]", "invalid_type", Error, "station should be an object")
+			continue
+		}
+		id := c.stationID(StationInformation, i, station)
+		if id == "" {
+			continue
+		}
+		if _, ok := c.capacities[id]; ok {
+			c.problem(StationInformation, id, "station_id", "duplicate_id", Error, "station_id appears more than once")
+		}
+		if name, ok := station["name"].(string); !ok || name == "" {
+			c.problem(StationInformation, id, "name", "missing_field", Error, "required field is missing")
+		}
+		c.checkCoordinate(id, station, "lat", 90)
+		c.checkCoordinate(id, station, "lon", 180)
+		switch region := station["region_id"].(type) {
+		case nil:
+			c.problem(StationInformation, id, "region_id", "missing_region_id", Warning, "region_id is missing; it will be loaded as -1")
+		case string:
+			if region == "" {
+				c.problem(StationInformation, id, "region_id", "missing_region_id", Warning, "region_id is empty; it will be loaded as -1")
+			} else if _, err := strconv.Atoi(region); err != nil {
+				c.problem(StationInformation, id, "region_id", "non_integer_id", Error, "region_id %q is not an integer", region)
+			}
+		default:
+			c.problem(StationInformation, id, "region_id", "invalid_type", Error, "region_id should be a string, got %v", region)
+		}
+		capacity, present, ok := integer(station, "capacity")
+		switch {
+		case !present:
+			c.problem(StationInformation, id, "capacity", "missing_capacity", Warning, "capacity is missing; station counts can't be checked against it")
+			capacity = -1
+		case !ok || capacity < 0:
+			c.problem(StationInformation, id, "capacity", "invalid_count", Error, "should be a non-negative integer, got %v", station["capacity"])
+			capacity = -1
+		}
+		c.capacities[id] = capacity
+	}
+}
+
+func (c *checker) checkCoordinate(id string, station object, field string, max float64) {
+	raw, present := station[field]
+	if !present || raw == nil {
+		c.problem(StationInformation, id, field, "missing_field", Error, "required field is missing")
+		return
+	}
+	n, ok := raw.(json.Number)
+	if !ok {
+		c.problem(StationInformation, id, field, "invalid_type", Error, "should be a number, got %v", raw)
+		return
+	}
+	val, err := n.Float64()
+	if err != nil || math.Abs(val) > max {
+		c.problem(StationInformation, id, field, "invalid_coordinate", Error, "%s is not a valid coordinate", n)
+	}
+}
+
+// count checks a station_status count, returning 0 if it's missing or
+// invalid.
+func (c *checker) count(id string, station object, field string, required bool) int64 {
+	val, present, ok := integer(station, field)
+	switch {
+	case !present:
+		if required {
+			c.problem(StationStatus, id, field, "missing_field", Error, "required field is missing")
+		}
+		return 0
+	case !ok:
+		c.problem(StationStatus, id, field, "invalid_type", Error, "should be an integer, got %v", station[field])
+		return 0
+	case val < 0:
+		c.problem(StationStatus, id, field, "negative_count", Error, "count is negative: %d", val)
+		return 0
+	}
+	return val
+}
+
+func (c *checker) flag(id string, station object, field string) {
+	raw, present := station[field]
+	if !present || raw == nil {
+		c.problem(StationStatus, id, field, "missing_field", Error, "required field is missing")
+		return
+	}
+	switch val := raw.(type) {
+	case bool:
+		return
+	case json.Number:
+		if s := val.String(); s == "0" || s == "1" {
+			return
+		}
+	}
+	c.problem(StationStatus, id, field, "invalid_type", Error, "should be 0, 1 or a boolean, got %v", raw)
+}
+
+func (c *checker) checkStatus(data []byte, haveInformation bool) {
+	lastUpdated, stations := c.checkHeader(StationStatus, data)
+	c.report.Statuses = len(stations)
+	c.reported = make(map[string]bool, len(stations))
+	for i := range stations {
+		station, ok := stations[i].(object)
+		if !ok {
+			c.problem(StationStatus, "", "data.stations["+strconv.Itoa(i)+"]", "invalid_type", Error, "station should be an object")
+			continue
+		}
+		id := c.stationID(StationStatus, i, station)
+		if id == "" {
+			continue
+		}
+		if c.reported[id] {
+			c.problem(StationStatus, id, "station_id", "duplicate_id", Error, "station_id appears more than once")
+		}
+		c.reported[id] = true
+		bikes := c.count(id, station, "num_bikes_available", true)
+		ebikes := c.count(id, station, "num_ebikes_available", false)
+		bikesDisabled := c.count(id, station, "num_bikes_disabled", false)
+		docks := c.count(id, station, "num_docks_available", true)
+		docksDisabled := c.count(id, station, "num_docks_disabled", false)
+		// capacity.csv files store counts as int16.
+		for _, n := range []int64{bikes, ebikes, bikesDisabled, docks, docksDisabled} {
+			if n > math.MaxInt16 {
+				c.problem(StationStatus, id, "", "count_too_large", Error, "count %d is too large to record", n)
+				break
+			}
+		}
+		if ebikes > bikes {
+			c.problem(StationStatus, id, "num_ebikes_available", "invalid_count", Error, "%d e-bikes available but only %d bikes", ebikes, bikes)
+		}
+		c.flag(id, station, "is_installed")
+		c.flag(id, station, "is_renting")
+		c.flag(id, station, "is_returning")
+
+		lr, present, ok := integer(station, "last_reported")
+		switch {
+		case !present:
+			c.problem(StationStatus, id, "last_reported", "missing_field", Error, "required field is missing")
+		case !ok:
+			c.problem(StationStatus, id, "last_reported", "invalid_type", Error, "should be an integer POSIX timestamp, got %v", station["last_reported"])
+		default:
+			lastReported := time.Unix(lr, 0)
+			if lastReported.After(c.now.Add(c.skew)) {
+				c.problem(StationStatus, id, "last_reported", "future_timestamp", Error, "%s is %s in the future", lastReported.UTC().Format(time.RFC3339), lastReported.Sub(c.now).Round(time.Second))
+			} else if !lastUpdated.IsZero() && lastReported.After(lastUpdated.Add(c.skew)) {
+				c.problem(StationStatus, id, "last_reported", "future_timestamp", Error, "%s is after the feed's last_updated time (%s)", lastReported.UTC().Format(time.RFC3339), lastUpdated.UTC().Format(time.RFC3339))
+			}
+		}
+
+		if !haveInformation || gobike.InternalStation(id) {
+			continue
+		}
+		capacity, known := c.capacities[id]
+		if !known {
+			c.problem(StationStatus, id, "station_id", "unknown_station", Error, "station is not in station_information")
+			continue
+		}
+		if capacity < 0 {
+			continue
+		}
+		if total := bikes + bikesDisabled + docks + docksDisabled; total > capacity {
+			c.problem(StationStatus, id, "", "over_capacity", Error, "%d bikes + %d disabled bikes + %d docks + %d disabled docks = %d, more than capacity %d", bikes, bikesDisabled, docks, docksDisabled, total, capacity)
+		}
+	}
+}
+
+// Fetch retrieves a feed from src, which may be a URL or the name of a file
+// on disk.
+func Fetch(ctx context.Context, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return ioutil.ReadFile(src)
+	}
+	req, err := http.NewRequest("GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "gobike/"+gobike.Version+" (github.com/kevinburke/gobike)")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %s: %s: %s", src, resp.Status, truncate(data, 200))
+	}
+	return data, nil
+}
+
+func truncate(data []byte, n int) string {
+	if len(data) <= n {
+		return string(data)
+	}
+	return string(data[:n]) + "..."
+}
+
+var errNoFeeds = errors.New("validate: no feeds to check")
+
+// Sources configures the feeds checked by Run. Host is a GBFS base URL, like
+// client.Host; Information and Status override the location of the
+// individual feeds and may be URLs or files on disk.
+type Sources struct {
+	Host        string
+	Information string
+	Status      string
+}
+
+// Run fetches the feeds in src and validates them.
+func (v *Validator) Run(ctx context.Context, src Sources) (*Report, error) {
+	infoSrc, statusSrc := src.Information, src.Status
+	if src.Host != "" {
+		host := strings.TrimSuffix(src.Host, "/")
+		if infoSrc == "" {
+			infoSrc = host + "/" + StationInformation + ".json"
+		}
+		if statusSrc == "" {
+			statusSrc = host + "/" + StationStatus + ".json"
+		}
+	}
+	if infoSrc == "" && statusSrc == "" {
+		return nil, errNoFeeds
+	}
+	var info, status []byte
+	var err error
+	if infoSrc != "" {
+		info, err = Fetch(ctx, infoSrc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if statusSrc != "" {
+		status, err = Fetch(ctx, statusSrc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v.Validate(info, status), nil
+}
@@ -0,0 +1,133 @@
+package validate
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/gobike"
+	"github.com/kevinburke/gobike/client"
+	"github.com/kevinburke/gobike/gbfstest"
+)
+
+var now = time.Unix(1535241600, 0) // 2018-08-26T00:00:00Z
+
+const information = `{
+	"last_updated": 1535241600,
+	"ttl": 10,
+	"data": {"stations": [
+		{"station_id": "3", "name": "Powell St BART", "lat": 37.786, "lon": -122.404, "region_id": "3", "capacity": 35},
+		{"station_id": "4", "name": "Cyril Magnin St", "lat": 37.785, "lon": -122.408, "capacity": 35},
+		{"station_id": "SF-A1", "name": "Not an integer", "lat": 37.785, "lon": -122.408, "region_id": "3", "capacity": 10}
+	]}
+}`
+
+const status = `{
+	"last_updated": 1535241600,
+	"ttl": 10,
+	"data": {"stations": [
+		{"station_id": "3", "num_bikes_available": 20, "num_ebikes_available": 1, "num_bikes_disabled": 4, "num_docks_available": 12, "num_docks_disabled": 0, "is_installed": 1, "is_renting": 1, "is_returning": 1, "last_reported": 1535241500},
+		{"station_id": "4", "num_bikes_available": -1, "num_docks_available": 30, "is_installed": 1, "is_renting": 1, "is_returning": 1, "last_reported": 1535245200},
+		{"station_id": "99", "num_bikes_available": 1, "num_docks_available": 1, "is_installed": 1, "is_renting": 1, "is_returning": 1, "last_reported": 1535241500}
+	]}
+}`
+
+func codes(r *Report) map[string]int {
+	m := make(map[string]int)
+	for _, p := range r.Problems {
+		m[p.StationID+" "+p.Code]++
+	}
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	v := &Validator{Now: now}
+	r := v.Validate([]byte(information), []byte(status))
+	want := map[string]int{
+		"4 missing_region_id":  1,
+		"SF-A1 non_integer_id": 1,
+		"3 over_capacity":      1,
+		"4 negative_count":     1,
+		"4 future_timestamp":   1,
+		"99 unknown_station":   1,
+		"SF-A1 missing_status": 1,
+	}
+	got := codes(r)
+	for code, n := range want {
+		if got[code] != n {
+			t.Errorf("expected %d %q problems, got %d", n, code, got[code])
+		}
+	}
+	if len(r.Problems) != len(want) {
+		for _, p := range r.Problems {
+			t.Log(p)
+		}
+		t.Errorf("expected %d problems, got %d", len(want), len(r.Problems))
+	}
+	if r.OK() {
+		t.Errorf("expected report with errors to not be OK")
+	}
+	if r.Warnings != 2 || r.Errors != 5 {
+		t.Errorf("expected 5 errors and 2 warnings, got %d and %d", r.Errors, r.Warnings)
+	}
+	if r.Stations != 3 || r.Statuses != 3 {
+		t.Errorf("expected 3 stations and 3 statuses, got %d and %d", r.Stations, r.Statuses)
+	}
+	if _, err := json.Marshal(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	r := Validate(nil, []byte(`{"ttl": "ten", "data": {"stations": [{"num_bikes_available": 1.5, "is_renting": 2}]}}`))
+	got := codes(r)
+	for _, code := range []string{" missing_field", " invalid_type"} {
+		if got[code] == 0 {
+			t.Errorf("expected a %q problem, got %v", code, got)
+		}
+	}
+	r = Validate([]byte(`not json`), nil)
+	if r.Errors != 1 || r.Problems[0].Code != "invalid_json" {
+		t.Errorf("expected invalid_json error, got %v", r.Problems)
+	}
+}
+
+func TestValidateFixtures(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "data", "station_information.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Validate(data, nil)
+	if !r.OK() {
+		for _, p := range r.Problems {
+			t.Log(p)
+		}
+		t.Errorf("expected station data to have no errors, got %d", r.Errors)
+	}
+}
+
+func TestRun(t *testing.T) {
+	stations, err := client.LoadStations(filepath.Join("..", "gbfstest", "testdata", "station_information.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := gbfstest.NewServer(stations, []*gobike.StationStatus{
+		{ID: "3", NumBikesAvailable: 30, NumDocksAvailable: 30, LastReported: now, IsInstalled: true, IsRenting: true, IsReturning: true},
+	})
+	defer s.Close()
+	v := &Validator{Now: now}
+	r, err := v.Run(context.Background(), Sources{Host: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := codes(r)
+	if got["3 over_capacity"] != 1 {
+		t.Errorf("expected station 3 to be over capacity, got %v", got)
+	}
+	if got["4 missing_status"] != 1 {
+		t.Errorf("expected station 4 to be missing a status, got %v", got)
+	}
+}